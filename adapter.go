@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SchemaAdapter translates the demo's PQL, written against Pilosa's original
+// frame model, to the syntax of the connected server.
+type SchemaAdapter interface {
+	Name() string
+	Translate(pql string) string
+}
+
+// frameAdapter passes frame-model PQL through, for Pilosa before 1.0.
+type frameAdapter struct{}
+
+func (frameAdapter) Name() string                { return "frame" }
+func (frameAdapter) Translate(pql string) string { return pql }
+
+// fieldAdapter rewrites frame-model PQL for Pilosa 1.x, where frames became
+// fields and BSI fields are addressed directly:
+//
+//	Bitmap(frame="x", rowID=1)       -> Row(x=1)
+//	Range(frame="x", x >= 1)         -> Row(x >= 1)
+//	Sum(..., frame="x", field="x")   -> Sum(..., field="x")
+//	TopN(frame="x", ...)             -> TopN(x, ...)
+//	SetRowAttrs(frame="x", rowID=1,  -> SetRowAttrs(x, 1,
+type fieldAdapter struct{}
+
+var (
+	bitmapCall      = regexp.MustCompile(`Bitmap\(\s*frame="?(\w+)"?,\s*rowID=([^,)\s]+)\s*\)`)
+	rangeCall       = regexp.MustCompile(`Range\(\s*frame="?\w+"?,\s*`)
+	frameBeforeBSI  = regexp.MustCompile(`,?\s*frame="?\w+"?(,\s*field=)`)
+	topNCall        = regexp.MustCompile(`TopN\(\s*frame="?(\w+)"?`)
+	setRowAttrsCall = regexp.MustCompile(`SetRowAttrs\(\s*frame="?(\w+)"?,\s*rowID=`)
+)
+
+func (fieldAdapter) Name() string { return "field" }
+
+func (fieldAdapter) Translate(pql string) string {
+	pql = bitmapCall.ReplaceAllString(pql, "Row($1=$2)")
+	pql = rangeCall.ReplaceAllString(pql, "Row(")
+	pql = frameBeforeBSI.ReplaceAllString(pql, "$1")
+	pql = topNCall.ReplaceAllString(pql, "TopN($1")
+	pql = setRowAttrsCall.ReplaceAllString(pql, "SetRowAttrs($1, ")
+	return pql
+}
+
+// newSchemaAdapter returns the adapter for a --pql-syntax value: frame, field,
+// or auto to choose by the Pilosa version, e.g. "v1.2.0", or, if the version
+// is unknown, by whether the schema lists fields.
+func newSchemaAdapter(syntax, version string, fieldModel bool) (SchemaAdapter, error) {
+	switch syntax {
+	case "frame":
+		return frameAdapter{}, nil
+	case "field":
+		return fieldAdapter{}, nil
+	case "auto":
+		major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0])
+		if err != nil && fieldModel {
+			logWarn(nil, "unrecognized pilosa version %q, using the field model its schema has\n", version)
+			return fieldAdapter{}, nil
+		} else if err != nil {
+			logWarn(nil, "unrecognized pilosa version %q, assuming the frame model\n", version)
+			return frameAdapter{}, nil
+		}
+		if major >= 1 {
+			return fieldAdapter{}, nil
+		}
+		return frameAdapter{}, nil
+	}
+	return nil, fmt.Errorf("unknown PQL syntax %q, want auto, frame or field", syntax)
+}
+
+// pql translates frame-model PQL for the connected server.
+func (s *Server) pql(pql string) string {
+	if s.adapter == nil {
+		return pql
+	}
+	return s.adapter.Translate(pql)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxAdHocIterations bounds the number of queries in an ad-hoc query set, and
+// maxAdHocConcurrency and maxAdHocBatchSize the settings it may ask for.
+// maxMultiConcurrency bounds the workers of all the sets of a multi-set run
+// together.
+const (
+	maxAdHocIterations  = 1000000
+	maxAdHocConcurrency = 256
+	maxAdHocBatchSize   = 1000
+	maxMultiConcurrency = 1024
+)
+
+// adHocName matches the allowed names of ad-hoc query sets, which name their
+// results files.
+var adHocName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// adHocCall matches the name of every call in an ad-hoc query set's format.
+var adHocCall = regexp.MustCompile(`(\w+)\s*\(`)
+
+// readOnlyCalls are the PQL calls ad-hoc query sets may make, none of which
+// change the data behind the demo.
+var readOnlyCalls = map[string]bool{
+	"Sum": true, "Count": true, "TopN": true, "Bitmap": true, "Intersect": true,
+	"Union": true, "Difference": true, "Range": true, "Load": true,
+}
+
+// checkReadOnly returns an error if format makes a call that isn't read-only,
+// e.g. SetBit or Store.
+func checkReadOnly(format string) error {
+	for _, m := range adHocCall.FindAllStringSubmatch(format, -1) {
+		if !readOnlyCalls[m[1]] {
+			return fmt.Errorf("%v is not allowed, only read-only calls are", m[1])
+		}
+	}
+	return nil
+}
+
+// AdHocQuerySet is a query set posted by a client rather than built in, e.g.
+//
+//	{"name": "revenue-by-year", "format": "Sum(Bitmap(frame=\"lo_year\", rowID={{lo_year}}), frame=\"lo_revenue\", field=\"lo_revenue\")",
+//	 "argnames": ["lo_year"], "argsets": [[1992, 1993, 1994]], "concurrency": 8}
+//
+// ArgNames names each argset, referenced in the format as {{name}}; argsets
+// without a name fill the format's %d in order. Kind is the ResultKind of the
+// queries, sum by default. Concurrency and BatchSize default to the server's.
+type AdHocQuerySet struct {
+	Name        string   `json:"name"`
+	Format      string   `json:"format"`
+	Kind        string   `json:"kind,omitempty"`
+	ArgNames    []string `json:"argnames,omitempty"`
+	ArgSets     [][]int  `json:"argsets"`
+	Concurrency int      `json:"concurrency,omitempty"`
+	BatchSize   int      `json:"batchsize,omitempty"`
+}
+
+// QuerySet validates the ad-hoc query set and converts it.
+func (a AdHocQuerySet) QuerySet() (QuerySet, error) {
+	if a.Name == "" {
+		return QuerySet{}, fmt.Errorf("missing name")
+	}
+	if !adHocName.MatchString(a.Name) || strings.Contains(a.Name, "..") {
+		return QuerySet{}, fmt.Errorf("invalid name %q, want up to 64 letters, digits, '.', '_' or '-', without '..'", a.Name)
+	}
+	if a.Format == "" {
+		return QuerySet{}, fmt.Errorf("missing format")
+	}
+	iterations := 1
+	for _, argset := range a.ArgSets {
+		iterations *= len(argset)
+		if iterations > maxAdHocIterations {
+			return QuerySet{}, fmt.Errorf("more than %d queries", maxAdHocIterations)
+		}
+	}
+	if a.Concurrency < 0 || a.Concurrency > maxAdHocConcurrency {
+		return QuerySet{}, fmt.Errorf("concurrency must be 0 to %d", maxAdHocConcurrency)
+	}
+	if a.BatchSize < 0 || a.BatchSize > maxAdHocBatchSize {
+		return QuerySet{}, fmt.Errorf("batchsize must be 0 to %d", maxAdHocBatchSize)
+	}
+	kind, err := parseResultKind(a.Kind)
+	if err != nil {
+		return QuerySet{}, err
+	}
+	format, err := expandTemplates(a.Format)
+	if err != nil {
+		return QuerySet{}, err
+	}
+	if err := checkReadOnly(format); err != nil {
+		return QuerySet{}, err
+	}
+	qs := NewRegisterQuerySet(a.Name, format, nil, nil, a.ArgNames, a.ArgSets)
+	qs.Kind = kind
+	if err := qs.Validate(); err != nil {
+		return QuerySet{}, err
+	}
+	return qs, nil
+}
+
+// adHocSets holds the most recently posted ad-hoc query set of each name, so
+// runs of it can be repeated, and resumed after a restart if they are saved in
+// a state directory. Runs in progress carry their own query set, so a set
+// posted under the same name meanwhile doesn't change them.
+type adHocSets struct {
+	mu   sync.Mutex
+	sets map[string]QuerySet
+	// dir is the directory the posted query sets are saved in, or "" to keep
+	// them in memory only.
+	dir string
+}
+
+// Put stores qs, converted from a, saving a if there is a state directory.
+func (as *adHocSets) Put(a AdHocQuerySet, qs QuerySet) {
+	as.mu.Lock()
+	if as.sets == nil {
+		as.sets = make(map[string]QuerySet)
+	}
+	as.sets[qs.Name] = qs
+	dir := as.dir
+	as.mu.Unlock()
+
+	if dir == "" {
+		return
+	}
+	body, err := json.Marshal(a)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(dir, a.Name+".json"), body)
+	}
+	if err != nil {
+		logError(nil, "saving ad-hoc query set %v: %v\n", a.Name, err)
+	}
+}
+
+// Load reloads the ad-hoc query sets saved in dir and saves new ones there
+// from now on. Files that don't hold a valid query set, e.g. one saved before
+// a check it fails was added, are skipped with a warning rather than stopping
+// the server from starting.
+func (as *adHocSets) Load(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sets := make(map[string]QuerySet)
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var a AdHocQuerySet
+		if err := json.Unmarshal(body, &a); err != nil {
+			logWarn(nil, "skipping ad-hoc query set %v: parsing: %v\n", file, err)
+			continue
+		}
+		qs, err := a.QuerySet()
+		if err != nil {
+			logWarn(nil, "skipping ad-hoc query set %v: %v\n", file, err)
+			continue
+		}
+		sets[qs.Name] = qs
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.dir = dir
+	if as.sets == nil {
+		as.sets = make(map[string]QuerySet)
+	}
+	for name, qs := range sets {
+		as.sets[name] = qs
+	}
+	return nil
+}
+
+func (as *adHocSets) Get(name string) (QuerySet, bool) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	qs, ok := as.sets[name]
+	return qs, ok
+}
+
+// adHocQuerySet returns the query set an adhoc run executes: its own, or for
+// runs repeated or resumed without one, the saved set of its name.
+func (s *Server) adHocQuerySet(run *Run) (QuerySet, bool) {
+	if run.adHoc != nil {
+		return *run.adHoc, true
+	}
+	return s.adHoc.Get(run.Query)
+}
+
+// HandleAdHocQuery runs the AdHocQuerySet posted in the request body, e.g.
+// curl -d @query.json localhost:8000/query
+// or, with ?dryrun=true, returns its PQL instead (see DryRun). sample, shuffle
+// and seed are as for HandleQuery.
+func (s *Server) HandleAdHocQuery(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(w, r)
+	var a AdHocQuerySet
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		s.reject(w, "adhoc", a.Name, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "decoding query set: %v", err))
+		return
+	}
+	qs, err := a.QuerySet()
+	if err == nil {
+		err = qs.CheckFrames(s.liveFrames)
+	}
+	if err != nil {
+		s.reject(w, "adhoc", a.Name, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "invalid query set: %v", err))
+		return
+	}
+	if r.URL.Query().Get("dryrun") == "true" {
+		s.writeDryRun(w, qs)
+		return
+	}
+	if err := s.Supported(qs); err != nil {
+		s.reject(w, "adhoc", a.Name, requestID, newAPIError(http.StatusNotImplemented, errUnsupported, "%v", err))
+		return
+	}
+	concurrency, batchSize := s.concurrency, s.batchSize
+	if a.Concurrency > 0 {
+		concurrency = a.Concurrency
+	}
+	if a.BatchSize > 0 {
+		batchSize = a.BatchSize
+	}
+
+	index, apiErr := s.indexParam(r)
+	if apiErr != nil {
+		s.reject(w, "adhoc", a.Name, requestID, apiErr)
+		return
+	}
+	sample, shuffle, seed, apiErr := parseSample(r)
+	if apiErr != nil {
+		s.reject(w, "adhoc", a.Name, requestID, apiErr)
+		return
+	}
+
+	s.adHoc.Put(a, qs)
+
+	run := s.runs.Start("adhoc", qs.Name, requestID, concurrency, batchSize)
+	defer s.failOnPanic(run)
+	run.Index, run.Sample, run.Shuffle, run.Seed = index, sample, shuffle, seed
+	run.adHoc = &qs
+	logf(run, "handling %v %v\n", r.URL.Path, qs.Name)
+	w.Header().Set("X-Run-ID", run.ID)
+	results, _ := s.execute(run)
+	s.finish(run, results)
+	if err := resultsError(results); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logError(run, "writing results: %v\n", err)
+	}
+}
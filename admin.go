@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Snapshot string    `json:"snapshot,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// auditLog appends entries as JSON lines to a file.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Record appends an entry to the audit log, logging rather than returning
+// failures so they never block the audited operation.
+func (al *auditLog) Record(e AuditEntry) {
+	e.Time = time.Now().UTC()
+	logf(nil, "audit: %v %v %v %v\n", e.Action, e.Snapshot, e.Reason, e.Error)
+	if al == nil || al.path == "" {
+		return
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	f, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logError(nil, "opening audit log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		logError(nil, "writing audit log: %v\n", err)
+	}
+}
+
+// Entries reads the audit log.
+func (al *auditLog) Entries() ([]AuditEntry, error) {
+	if al == nil || al.path == "" {
+		return nil, nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	f, err := os.Open(al.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("parsing audit log: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// HasSnapshot reports whether the audit log records a successful snapshot with
+// the given ID. Without an audit log, no snapshot can be vouched for.
+func (al *auditLog) HasSnapshot(id string) (bool, error) {
+	if al == nil || al.path == "" {
+		return false, fmt.Errorf("no --audit-log to check snapshot IDs against")
+	}
+	entries, err := al.Entries()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.Action == "snapshot" && e.Snapshot == id && e.Error == "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// snapshotID matches snapshot IDs: the time the snapshot was taken, then
+// random hex so two snapshots in the same second differ. IDs from before the
+// suffix was added have only the time.
+var snapshotID = regexp.MustCompile(`^\d{8}T\d{6}Z(-[0-9a-f]+)?$`)
+
+// newSnapshotID returns a unique snapshot ID, e.g. 20171101T120000Z-3f9c01a2.
+func newSnapshotID() string {
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + randomHex(4)
+}
+
+// snapshotter takes and restores snapshots of the index by running the
+// configured scripts, which receive PILOSA_HOST, PILOSA_INDEX, SNAPSHOT_ID and
+// SNAPSHOT_FRAMES (space separated) in their environment, e.g. to run
+// `pilosa backup` for every frame into a directory named after the ID.
+type snapshotter struct {
+	snapshotScript string
+	restoreScript  string
+}
+
+// runSnapshotScript runs script for the snapshot with the given ID.
+func (s *Server) runSnapshotScript(script, id string) error {
+	frames := make([]string, 0, len(s.Frames))
+	for name := range s.Frames {
+		frames = append(frames, name)
+	}
+	sort.Strings(frames)
+
+	cmd := exec.Command(script)
+	cmd.Env = append(os.Environ(),
+		"PILOSA_HOST="+s.pilosaAddr(),
+		"PILOSA_HOSTS="+strings.Join(s.pilosaHosts(), ","),
+		"PILOSA_INDEX="+s.Index.Name(),
+		"SNAPSHOT_ID="+id,
+		"SNAPSHOT_FRAMES="+strings.Join(frames, " "),
+	)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logf(nil, "%v %v: %s", script, id, out)
+	}
+	if err != nil {
+		return fmt.Errorf("running %v: %v", script, err)
+	}
+	return nil
+}
+
+// Snapshot takes a snapshot of the index and records its ID in the audit log.
+func (s *Server) Snapshot(reason string) (string, error) {
+	if s.snapshots.snapshotScript == "" {
+		return "", fmt.Errorf("no snapshot script configured")
+	}
+	id := newSnapshotID()
+	err := s.runSnapshotScript(s.snapshots.snapshotScript, id)
+	e := AuditEntry{Action: "snapshot", Snapshot: id, Reason: reason}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.audit.Record(e)
+	return id, err
+}
+
+// Restore restores the snapshot with the given ID, which must be a snapshot
+// the audit log records, and records it in the audit log.
+func (s *Server) Restore(id, reason string) error {
+	if s.snapshots.restoreScript == "" {
+		return fmt.Errorf("no restore script configured")
+	}
+	if !snapshotID.MatchString(id) {
+		return fmt.Errorf("invalid snapshot id %q", id)
+	}
+	ok, err := s.audit.HasSnapshot(id)
+	if err != nil {
+		return fmt.Errorf("checking snapshot %v: %v", id, err)
+	} else if !ok {
+		return errNoSnapshot{id}
+	}
+	err = s.runSnapshotScript(s.snapshots.restoreScript, id)
+	e := AuditEntry{Action: "restore", Snapshot: id, Reason: reason}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.audit.Record(e)
+	if _, cerr := s.RefreshLineOrderCount(); cerr != nil {
+		logWarn(nil, "recounting lineorders after restore: %v\n", cerr)
+	}
+	return err
+}
+
+// beforeDestructive is called before operations that overwrite index data.
+// If a snapshot script is configured a snapshot is taken first, and the
+// operation must not proceed if it fails; otherwise the operation is only
+// audited.
+func (s *Server) beforeDestructive(operation string) error {
+	if s.snapshots.snapshotScript == "" {
+		s.audit.Record(AuditEntry{Action: operation, Reason: "no snapshot script configured"})
+		return nil
+	}
+	id, err := s.Snapshot("before " + operation)
+	if err != nil {
+		return fmt.Errorf("snapshot before %v failed, not proceeding: %v", operation, err)
+	}
+	s.audit.Record(AuditEntry{Action: operation, Snapshot: id})
+	return nil
+}
+
+// errNoSnapshot is the error restoring a snapshot the audit log doesn't
+// record.
+type errNoSnapshot struct{ id string }
+
+func (e errNoSnapshot) Error() string { return fmt.Sprintf("no snapshot %v in the audit log", e.id) }
+
+// requireAdmin guards an admin endpoint: it is forbidden unless --admin-token
+// is set, and the request must send the token as a bearer token.
+func (s *Server) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			writeError(w, newAPIError(http.StatusForbidden, errForbidden, "admin endpoints are disabled, start the demo with --admin-token"))
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			writeError(w, newAPIError(http.StatusUnauthorized, errUnauthorized, "missing or wrong admin token"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// HandleSnapshot takes a snapshot, e.g. POST /admin/snapshot?reason=demo
+func (s *Server) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	id, err := s.Snapshot(r.URL.Query().Get("reason"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "%v", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]string{"snapshot": id}); err != nil {
+		logError(nil, "writing snapshot: %v\n", err)
+	}
+}
+
+// HandleRestore restores a snapshot, e.g.
+// POST /admin/restore?id=20171101T120000Z-3f9c01a2
+func (s *Server) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "missing snapshot id"))
+		return
+	}
+	if !snapshotID.MatchString(id) {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "invalid snapshot id %q", id))
+		return
+	}
+	if err := s.Restore(id, r.URL.Query().Get("reason")); err != nil {
+		if _, ok := err.(errNoSnapshot); ok {
+			writeError(w, newAPIError(http.StatusNotFound, errNotFound, "%v", err))
+			return
+		}
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "%v", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]string{"restored": id}); err != nil {
+		logError(nil, "writing restore: %v\n", err)
+	}
+}
+
+// HandleAudit serves the audit log.
+func (s *Server) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.audit.Entries()
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "reading audit log: %v", err))
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logError(nil, "writing audit log: %v\n", err)
+	}
+}
+
+// runSnapshot implements the snapshot and restore commands.
+func (s *Server) runSnapshot(command string, args []string) error {
+	switch command {
+	case "snapshot":
+		id, err := s.Snapshot(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		logf(nil, "snapshot %v\n", id)
+		return nil
+	case "restore":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: restore <snapshot id> [reason]")
+		}
+		return s.Restore(args[0], strings.Join(args[1:], " "))
+	}
+	return fmt.Errorf("unknown command: %v", command)
+}
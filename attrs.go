@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// attrBatchSize is the number of SetRowAttrs calls sent per request.
+const attrBatchSize = 100
+
+// rowAttrs returns the row attributes of every dimension frame, keyed by frame
+// and rowID: the name of each row, and the name of its parent.
+func rowAttrs() map[string]map[int]map[string]interface{} {
+	attrs := make(map[string]map[int]map[string]interface{})
+	for _, h := range hierarchies {
+		for level, l := range h.Levels {
+			rows := make(map[int]map[string]interface{}, l.Size)
+			for id := 0; id < l.Size; id++ {
+				n, err := h.Node(l.Name, id)
+				if err != nil {
+					continue
+				}
+				a := map[string]interface{}{"name": n.Label}
+				if level > 0 {
+					a[n.Parent.Level] = n.Parent.Label
+				}
+				rows[id] = a
+			}
+			for _, frame := range l.Frames {
+				attrs[frame] = rows
+			}
+		}
+	}
+	return attrs
+}
+
+// StoreRowAttrs sets the name attributes of every dimension row in Pilosa, so
+// results can be labeled from Pilosa rather than the local label tables.
+func (s *Server) StoreRowAttrs() error {
+	attrs := rowAttrs()
+	frames := make([]string, 0, len(attrs))
+	for frame := range attrs {
+		frames = append(frames, frame)
+	}
+	sort.Strings(frames)
+
+	for _, frame := range frames {
+		if _, ok := s.Frames[frame]; !ok {
+			return fmt.Errorf("frame %v not in server's frame list", frame)
+		}
+		rows := attrs[frame]
+		var batch []string
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if _, err := s.query(s.Index.RawQuery(s.pql(strings.Join(batch, "\n")))); err != nil {
+				return fmt.Errorf("setting row attributes on %v: %v", frame, err)
+			}
+			batch = batch[:0]
+			return nil
+		}
+		for id := 0; id < len(rows); id++ {
+			batch = append(batch, setRowAttrs(frame, id, rows[id]))
+			if len(batch) == attrBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		logf(nil, "set attributes on %d rows of %v\n", len(rows), frame)
+	}
+	s.labels.Reset()
+	return nil
+}
+
+// setRowAttrs returns the PQL setting the attributes of one row.
+func setRowAttrs(frame string, id int, attrs map[string]interface{}) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v, _ := json.Marshal(attrs[k])
+		args = append(args, fmt.Sprintf("%s=%s", k, v))
+	}
+	return fmt.Sprintf(`SetRowAttrs(frame="%s", rowID=%d, %s)`, frame, id, strings.Join(args, ", "))
+}
+
+// getRowAttrs fetches all row attributes of a frame from Pilosa, using the
+// attribute diff endpoint with no blocks, which returns every row.
+func getRowAttrs(host, index, frame string) (map[int]map[string]interface{}, error) {
+	path := fmt.Sprintf("/index/%s/frame/%s/attr/diff", index, frame)
+	resp, err := pilosaHTTP.post(host, path, "application/json", bytes.NewBufferString(`{"blocks":[]}`))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v: %s", resp.Status, body)
+	}
+	var diff struct {
+		Attrs map[string]map[string]interface{} `json:"attrs"`
+	}
+	if err := json.Unmarshal(body, &diff); err != nil {
+		return nil, err
+	}
+	attrs := make(map[int]map[string]interface{}, len(diff.Attrs))
+	for id, a := range diff.Attrs {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rowID %q: %v", id, err)
+		}
+		attrs[n] = a
+	}
+	return attrs, nil
+}
+
+// localLabel returns the label of a row from the local mapping, or the rowID
+// itself for frames outside the hierarchies, such as lo_year.
+func localLabel(frame string, id int) string {
+	for _, h := range hierarchies {
+		for _, l := range h.Levels {
+			for _, f := range l.Frames {
+				if f != frame {
+					continue
+				}
+				if n, err := h.Node(l.Name, id); err == nil {
+					return n.Label
+				}
+			}
+		}
+	}
+	return strconv.Itoa(id)
+}
+
+// labelCache holds row names fetched from Pilosa row attributes, per frame.
+type labelCache struct {
+	mu     sync.Mutex
+	frames map[string]map[int]string
+}
+
+func newLabelCache() *labelCache {
+	return &labelCache{frames: make(map[string]map[int]string)}
+}
+
+// Reset drops all cached labels, e.g. after attributes are rewritten.
+func (lc *labelCache) Reset() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.frames = make(map[string]map[int]string)
+}
+
+// Label returns the name of a row. With --row-attrs, names come from Pilosa
+// row attributes, fetched once per frame; rows without a name attribute, and
+// all rows otherwise, are labeled from the local mapping.
+func (s *Server) Label(frame string, id int) string {
+	if !s.rowAttrs {
+		return localLabel(frame, id)
+	}
+	lc := s.labels
+	lc.mu.Lock()
+	names, ok := lc.frames[frame]
+	if !ok {
+		names = make(map[int]string)
+		attrs, err := getRowAttrs(s.pilosaAddr(), s.Index.Name(), frame)
+		if err != nil {
+			// Not cached, so the next lookup retries.
+			logWarn(nil, "fetching row attributes of %v: %v\n", frame, err)
+		} else {
+			for n, a := range attrs {
+				if name, ok := a["name"].(string); ok {
+					names[n] = name
+				}
+			}
+			lc.frames[frame] = names
+		}
+	}
+	lc.mu.Unlock()
+
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return localLabel(frame, id)
+}
+
+// runAttrs implements the attrs command.
+func (s *Server) runAttrs(args []string) error {
+	if len(args) != 1 || args[0] != "store" {
+		return fmt.Errorf("usage: attrs store")
+	}
+	if err := s.beforeDestructive("attrs store"); err != nil {
+		return err
+	}
+	return s.StoreRowAttrs()
+}
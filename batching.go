@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultBatchingSize is the batch size compared with unbatched execution
+// when neither the request nor --batchsize sets one above 1.
+const defaultBatchingSize = 8
+
+// BatchingSpeedup compares one query set run with batchSize=1 and with
+// batching, at the same concurrency. Speedup is the unbatched time over the
+// batched time; it is 0 if either run failed.
+type BatchingSpeedup struct {
+	Query            string  `json:"query"`
+	UnbatchedSeconds float64 `json:"unbatchedseconds"`
+	BatchedSeconds   float64 `json:"batchedseconds"`
+	Speedup          float64 `json:"speedup"`
+}
+
+// BatchingReport is the result of a batching experiment.
+type BatchingReport struct {
+	Concurrency int               `json:"concurrency"`
+	BatchSize   int               `json:"batchsize"`
+	Queries     []BatchingSpeedup `json:"queries"`
+}
+
+// batchingQueries returns the query sets named in a batching run's query: a
+// comma separated list, or "ssb" for the SSB suite.
+func batchingQueries(query string) []string {
+	if query == "ssb" {
+		return ssbQueries
+	}
+	return strings.Split(query, ",")
+}
+
+// RunBatching runs each query set unbatched and then with batchSize, recording
+// them in run unless it is nil. It returns the report and all results, two per
+// query set.
+func (s *Server) RunBatching(run *Run, names []string, concurrency, batchSize int) (BatchingReport, []BenchmarkResult) {
+	report := BatchingReport{Concurrency: concurrency, BatchSize: batchSize}
+	var results []BenchmarkResult
+	for _, name := range names {
+		qs := getQuerySet(name)
+		unbatched := s.runRecorded(run, qs, concurrency, 1)
+		batched := s.runRecorded(run, qs, concurrency, batchSize)
+		results = append(results, unbatched, batched)
+
+		bs := BatchingSpeedup{
+			Query:            name,
+			UnbatchedSeconds: unbatched.Seconds,
+			BatchedSeconds:   batched.Seconds,
+		}
+		if unbatched.Seconds > 0 && batched.Seconds > 0 {
+			bs.Speedup = unbatched.Seconds / batched.Seconds
+		}
+		logf(run, "batching %v: %.3fs unbatched, %.3fs with batchsize %d\n", name, bs.UnbatchedSeconds, bs.BatchedSeconds, batchSize)
+		report.Queries = append(report.Queries, bs)
+	}
+	return report, results
+}
+
+// HandleBatching runs the batching experiment, e.g.
+// /batching?queries=1.1,2.1&batchsize=8&concurrency=32
+// Queries default to the SSB suite, concurrency to --concurrency and the batch
+// size to --batchsize, or 8 if that is 1. Both are bounded as for ad-hoc query
+// sets.
+func (s *Server) HandleBatching(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(w, r)
+	q := r.URL.Query()
+	query := q.Get("queries")
+	if query == "" {
+		query = "ssb"
+	}
+	for _, name := range batchingQueries(query) {
+		if getQuerySet(name).Name == "" {
+			s.reject(w, "batching", query, requestID, newAPIError(http.StatusBadRequest, errUnknownQuery, "unknown query set %q; see /queries", name))
+			return
+		}
+	}
+	concurrency, batchSize := s.concurrency, s.batchSize
+	if batchSize <= 1 {
+		batchSize = defaultBatchingSize
+	}
+	for key, limit := range map[string]struct {
+		value *int
+		max   int
+	}{"concurrency": {&concurrency, maxAdHocConcurrency}, "batchsize": {&batchSize, maxAdHocBatchSize}} {
+		v := q.Get(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > limit.max {
+			s.reject(w, "batching", query, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "invalid %v: %q, want 1 to %d", key, v, limit.max))
+			return
+		}
+		*limit.value = n
+	}
+
+	run := s.runs.Start("batching", query, requestID, concurrency, batchSize)
+	defer s.failOnPanic(run)
+	logf(run, "handling %v\n", r.URL.Path)
+	w.Header().Set("X-Run-ID", run.ID)
+	results, report := s.execute(run)
+	s.finish(run, results)
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logError(run, "writing batching report: %v\n", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// runBench implements the bench command, e.g.
+// demo-ssb bench 2.1 --concurrency 16 --batchsize 4 --repeats 3
+// It runs a query set as /query does and prints the results to stdout, as
+// JSON or a table, returning an error if any benchmark failed so scripts see
+// a non-zero exit status. Logs go to stderr.
+func (s *Server) runBench(args []string) error {
+	flags := pflag.NewFlagSet("bench", pflag.ContinueOnError)
+	concurrency := flags.IntP("concurrency", "c", 0, "number of queries to execute in parallel (default: the server's, or the query set's override)")
+	batchSize := flags.IntP("batchsize", "b", 0, "number of queries to combine into a single batch request (default: as for concurrency)")
+	repeats := flags.Int("repeats", 1, "run the query set this many times, printing RepeatedResults as JSON")
+	warmup := flags.String("warmup", "", "queries to run untimed first: a count, e.g. 500, or a duration, e.g. 10s (default: --warmup)")
+	index := flags.String("index", "", "one of the indexes given with --indexes")
+	sample := flags.Int("sample", 0, "run this many of the query set's queries, chosen at random")
+	shuffle := flags.Bool("shuffle", false, "run the queries in a random order rather than cartesian order")
+	seed := flags.Int64("seed", 0, "seed for --sample and --shuffle, to choose the same queries and order again (default: from the clock)")
+	format := flags.String("format", "json", "output format: json or table")
+	dryrun := flags.Bool("dryrun", false, "print the query set's PQL instead of running it: a DryRun as JSON, or one query per line with --format table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: bench [--concurrency N] [--batchsize N] [--repeats N] [--warmup W] [--index NAME] [--sample N] [--shuffle] [--seed S] [--format json|table] [--dryrun] QUERYSET")
+	}
+	qname := flags.Arg(0)
+	if *format != "json" && *format != "table" {
+		return fmt.Errorf("unknown format %q, want json or table", *format)
+	}
+	qs := getQuerySet(qname)
+	if qs.Name == "" {
+		return fmt.Errorf("unknown query set %q", qname)
+	}
+	if *dryrun {
+		return writeDryRun(os.Stdout, s.dryRun(qs), *format)
+	}
+	if err := s.Supported(qs); err != nil {
+		return err
+	}
+	if *repeats < 1 || *repeats > maxRepeats {
+		return fmt.Errorf("invalid repeats %d, want 1 to %d", *repeats, maxRepeats)
+	}
+	if _, err := parseWarmup(*warmup); err != nil {
+		return err
+	}
+	if _, ok := s.demoIndex(*index); !ok {
+		return fmt.Errorf("unknown index %q, want one of %v", *index, s.IndexNames())
+	}
+	if *sample < 0 {
+		return fmt.Errorf("invalid sample %d, want a positive query count", *sample)
+	}
+	if !flags.Changed("seed") {
+		*seed = time.Now().UnixNano()
+	}
+
+	c, b := s.querySettings(qname)
+	if *concurrency > 0 {
+		c = *concurrency
+	}
+	if *batchSize > 0 {
+		b = *batchSize
+	}
+	run := s.runs.Start("query", qname, "", c, b)
+	run.Warmup, run.Repeats, run.Index = *warmup, *repeats, *index
+	if *sample > 0 || *shuffle {
+		run.Sample, run.Shuffle, run.Seed = *sample, *shuffle, *seed
+	}
+	results, response := s.execute(run)
+	s.finish(run, results)
+
+	if *format == "table" {
+		writeBenchTable(os.Stdout, results)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(response); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	for _, br := range results {
+		if br.Error != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d benchmarks failed", failed, len(results))
+	}
+	return nil
+}
+
+// writeDryRun writes dr for bench --dryrun, returning an error if any query
+// didn't expand cleanly.
+func writeDryRun(w io.Writer, dr DryRun, format string) error {
+	if format == "table" {
+		for _, q := range dr.Queries {
+			fmt.Fprintln(w, q.PQL)
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(dr); err != nil {
+			return err
+		}
+	}
+	if len(dr.Problems) > 0 {
+		return fmt.Errorf("%d expansion problems, e.g. %v", len(dr.Problems), dr.Problems[0])
+	}
+	return nil
+}
+
+// writeBenchTable writes one line per result.
+func writeBenchTable(w io.Writer, results []BenchmarkResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCONCURRENCY\tBATCHSIZE\tITERATIONS\tSECONDS\tQPS\tERROR")
+	for _, br := range results {
+		qps, errMsg := 0.0, ""
+		if br.Seconds > 0 {
+			qps = float64(br.Iterations) / br.Seconds
+		}
+		if br.Error != nil {
+			errMsg = br.Error.Message
+		}
+		fmt.Fprintf(tw, "%v\t%d\t%d\t%d\t%.3f\t%.1f\t%v\n", br.Name, br.Concurrency, br.BatchSize, br.Iterations, br.Seconds, qps, errMsg)
+	}
+	tw.Flush()
+}
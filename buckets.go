@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// bucketFrame describes a frame holding one row per value of a BSI field, used
+// by the "b" query variants to express ranges as Unions of bitmaps.
+type bucketFrame struct {
+	Frame string
+	Field string
+	Min   int
+	Max   int
+}
+
+var bucketFrames = []bucketFrame{
+	{"lo_discount_b", "lo_discount", 0, 10},
+	{"lo_quantity_b", "lo_quantity", 1, 50},
+}
+
+// BucketCheck compares the count of one bucket row against the count of the
+// matching value in its BSI field.
+type BucketCheck struct {
+	Frame       string `json:"frame"`
+	Row         int    `json:"row"`
+	BucketCount uint64 `json:"bucketcount"`
+	FieldCount  uint64 `json:"fieldcount"`
+	OK          bool   `json:"ok"`
+}
+
+// sliceBitIterator implements pilosa.BitIterator over a slice of bits.
+type sliceBitIterator struct {
+	bits []pilosa.Bit
+	n    int
+}
+
+func (it *sliceBitIterator) NextBit() (pilosa.Bit, error) {
+	if it.n >= len(it.bits) {
+		return pilosa.Bit{}, io.EOF
+	}
+	bit := it.bits[it.n]
+	it.n++
+	return bit, nil
+}
+
+// fieldEquals returns the PQL for columns whose BSI field equals value.
+func (b bucketFrame) fieldEquals(value int) string {
+	return fmt.Sprintf(`Range(frame="%s", %s == %d)`, b.Field, b.Field, value)
+}
+
+// RebuildBuckets regenerates every bucket frame from its BSI field: the frame is
+// recreated empty, then for each value the matching columns are fetched with a
+// range query and imported as bits in the row for that value.
+func (s *Server) RebuildBuckets() error {
+	for _, b := range bucketFrames {
+		frame, ok := s.Frames[b.Frame]
+		if !ok {
+			return fmt.Errorf("unknown bucket frame: %v", b.Frame)
+		}
+		if err := s.pilosaClient().DeleteFrame(frame); err != nil {
+			return fmt.Errorf("client.DeleteFrame %v: %v", b.Frame, err)
+		}
+		if err := s.pilosaClient().EnsureFrame(frame); err != nil {
+			return fmt.Errorf("client.EnsureFrame %v: %v", b.Frame, err)
+		}
+
+		for value := b.Min; value <= b.Max; value++ {
+			response, err := s.query(s.Index.RawQuery(s.pql(b.fieldEquals(value))))
+			if err != nil {
+				return fmt.Errorf("querying %v == %d: %v", b.Field, value, err)
+			}
+			cols := response.Result().Bitmap.Bits
+			bits := make([]pilosa.Bit, len(cols))
+			for n, col := range cols {
+				bits[n] = pilosa.Bit{RowID: uint64(value), ColumnID: col}
+			}
+			if err := s.pilosaClient().ImportFrame(frame, &sliceBitIterator{bits: bits}, 100000); err != nil {
+				return fmt.Errorf("importing %v row %d: %v", b.Frame, value, err)
+			}
+			logDebug(nil, "%v row %d: imported %d bits\n", b.Frame, value, len(bits))
+		}
+	}
+	return nil
+}
+
+// VerifyBuckets checks that every bucket row holds exactly as many columns as
+// its BSI field value, and that the bucket frame totals match the totals over
+// the full field range (reported with Row = -1).
+func (s *Server) VerifyBuckets() ([]BucketCheck, error) {
+	var checks []BucketCheck
+	for _, b := range bucketFrames {
+		var bucketTotal, fieldTotal uint64
+		for value := b.Min; value <= b.Max; value++ {
+			check := BucketCheck{Frame: b.Frame, Row: value}
+			raw := fmt.Sprintf(`Count(Bitmap(frame="%s", rowID=%d))`, b.Frame, value) +
+				fmt.Sprintf("Count(%s)", b.fieldEquals(value))
+			response, err := s.query(s.Index.RawQuery(s.pql(raw)))
+			if err != nil {
+				return nil, fmt.Errorf("counting %v row %d: %v", b.Frame, value, err)
+			}
+			results := response.Results()
+			check.BucketCount, check.FieldCount = results[0].Count, results[1].Count
+			check.OK = check.BucketCount == check.FieldCount
+			bucketTotal += check.BucketCount
+			fieldTotal += check.FieldCount
+			checks = append(checks, check)
+		}
+
+		raw := fmt.Sprintf(`Count(Range(frame="%s", %s >< [%d,%d]))`, b.Field, b.Field, b.Min, b.Max)
+		response, err := s.query(s.Index.RawQuery(s.pql(raw)))
+		if err != nil {
+			return nil, fmt.Errorf("counting %v: %v", b.Field, err)
+		}
+		rangeTotal := response.Result().Count
+		checks = append(checks, BucketCheck{
+			Frame:       b.Frame,
+			Row:         -1,
+			BucketCount: bucketTotal,
+			FieldCount:  rangeTotal,
+			OK:          bucketTotal == rangeTotal && fieldTotal == rangeTotal,
+		})
+	}
+	return checks, nil
+}
+
+// runBuckets implements the "buckets rebuild|verify" command.
+func (s *Server) runBuckets(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: buckets rebuild|verify")
+	}
+	switch args[0] {
+	case "rebuild":
+		if err := s.beforeDestructive("buckets rebuild"); err != nil {
+			return err
+		}
+		if err := s.RebuildBuckets(); err != nil {
+			return err
+		}
+	case "verify":
+	default:
+		return fmt.Errorf("unknown buckets operation: %v", args[0])
+	}
+
+	checks, err := s.VerifyBuckets()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, c := range checks {
+		if !c.OK {
+			logWarn(nil, "mismatch in %v row %d: bucket=%d field=%d\n", c.Frame, c.Row, c.BucketCount, c.FieldCount)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d bucket checks failed", failed)
+	}
+	logf(nil, "all %d bucket checks passed\n", len(checks))
+	return nil
+}
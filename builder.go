@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuilderRow is one selectable row of a set frame.
+type BuilderRow struct {
+	ID    int    `json:"id"`
+	Label string `json:"label"`
+}
+
+// BuilderFrame describes a frame for the query builder: set frames list their
+// rows, range frames the bounds of their field where Pilosa reports them.
+type BuilderFrame struct {
+	Name  string       `json:"name"`
+	Type  string       `json:"type"`
+	Rows  []BuilderRow `json:"rows,omitempty"`
+	Min   *int64       `json:"min,omitempty"`
+	Max   *int64       `json:"max,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// frameRows returns the rows of a set frame with their labels, or nil if the
+// frame's domain isn't known.
+func frameRows(frame string) []BuilderRow {
+	var rows []BuilderRow
+	add := func(ids []int, label func(int) string) {
+		for _, id := range ids {
+			rows = append(rows, BuilderRow{id, label(id)})
+		}
+	}
+	switch frame {
+	case "lo_year":
+		add(arange(1992, 1999, 1), strconv.Itoa)
+	case "lo_month":
+		add(arange(0, 12, 1), func(id int) string { return time.Month(id + 1).String() })
+	case "lo_weeknum":
+		add(arange(1, 54, 1), strconv.Itoa)
+	}
+	for _, b := range bucketFrames {
+		if b.Frame == frame {
+			add(arange(b.Min, b.Max+1, 1), strconv.Itoa)
+		}
+	}
+	for _, h := range hierarchies {
+		for _, l := range h.Levels {
+			for _, f := range l.Frames {
+				if f == frame {
+					add(arange(0, l.Size, 1), func(id int) string { return localLabel(frame, id) })
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// fieldBounds returns the min and max of a range frame's field from its
+// Pilosa frame options.
+func fieldBounds(options map[string]interface{}, field string) (min, max *int64) {
+	fields, _ := options["fields"].([]interface{})
+	for _, f := range fields {
+		fm, ok := f.(map[string]interface{})
+		if !ok || fm["name"] != field {
+			continue
+		}
+		if v, ok := fm["min"].(float64); ok {
+			n := int64(v)
+			min = &n
+		}
+		if v, ok := fm["max"].(float64); ok {
+			n := int64(v)
+			max = &n
+		}
+	}
+	return min, max
+}
+
+// BuilderFrames lists every frame with its selectable rows or field bounds.
+func (s *Server) BuilderFrames() []BuilderFrame {
+	live, err := getPilosaSchema(s.pilosaAddr(), s.Index.Name())
+	names := make([]string, 0, len(s.Frames))
+	for name := range s.Frames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	frames := make([]BuilderFrame, 0, len(names))
+	for _, name := range names {
+		bf := BuilderFrame{Name: name, Type: "set"}
+		if rangeFrames[name] {
+			bf.Type = "range"
+			if err != nil {
+				bf.Error = fmt.Sprintf("fetching pilosa schema: %v", err)
+			} else {
+				bf.Min, bf.Max = fieldBounds(live[name], name)
+			}
+		} else {
+			bf.Rows = frameRows(name)
+		}
+		frames = append(frames, bf)
+	}
+	return frames
+}
+
+// Filter is a query built by clicking: either an operator (intersect, union or
+// difference) over Args, a row of a set frame, or an inclusive range of a
+// range frame's field, e.g.
+//
+//	{"op": "intersect", "args": [
+//		{"frame": "c_region", "row": 2},
+//		{"frame": "lo_discount", "range": [1, 3]}]}
+type Filter struct {
+	Op    string   `json:"op,omitempty"`
+	Args  []Filter `json:"args,omitempty"`
+	Frame string   `json:"frame,omitempty"`
+	Row   *int     `json:"row,omitempty"`
+	Range []int64  `json:"range,omitempty"`
+}
+
+// BuilderQuery is a filter and an optional range frame to Sum over it. Without
+// Sum, the filter's columns are counted.
+type BuilderQuery struct {
+	Filter Filter `json:"filter"`
+	Sum    string `json:"sum,omitempty"`
+}
+
+var builderOps = map[string]string{
+	"intersect":  "Intersect",
+	"union":      "Union",
+	"difference": "Difference",
+}
+
+// validateFilter returns every problem with the filter, prefixed with its path.
+func (s *Server) validateFilter(f Filter, path string) []string {
+	var errs []string
+	if f.Op != "" {
+		if _, ok := builderOps[f.Op]; !ok {
+			errs = append(errs, fmt.Sprintf("%v: unknown op %q", path, f.Op))
+		}
+		if len(f.Args) == 0 {
+			errs = append(errs, fmt.Sprintf("%v: %v needs at least one argument", path, f.Op))
+		}
+		if f.Frame != "" || f.Row != nil || f.Range != nil {
+			errs = append(errs, fmt.Sprintf("%v: op can't also have frame, row or range", path))
+		}
+		for n, arg := range f.Args {
+			errs = append(errs, s.validateFilter(arg, fmt.Sprintf("%v.args[%d]", path, n))...)
+		}
+		return errs
+	}
+
+	if _, ok := s.Frames[f.Frame]; !ok {
+		return append(errs, fmt.Sprintf("%v: unknown frame %q", path, f.Frame))
+	}
+	if rangeFrames[f.Frame] {
+		if len(f.Range) != 2 || f.Range[0] > f.Range[1] {
+			errs = append(errs, fmt.Sprintf("%v: range frame %v needs range [min, max]", path, f.Frame))
+		}
+		if f.Row != nil {
+			errs = append(errs, fmt.Sprintf("%v: range frame %v doesn't take a row", path, f.Frame))
+		}
+		return errs
+	}
+	if f.Row == nil || *f.Row < 0 {
+		errs = append(errs, fmt.Sprintf("%v: set frame %v needs a row", path, f.Frame))
+	}
+	if f.Range != nil {
+		errs = append(errs, fmt.Sprintf("%v: set frame %v doesn't take a range", path, f.Frame))
+	}
+	return errs
+}
+
+// Validate returns every problem with the query; none means it can be run.
+func (s *Server) Validate(q BuilderQuery) []string {
+	errs := s.validateFilter(q.Filter, "filter")
+	if q.Sum != "" && !rangeFrames[q.Sum] {
+		errs = append(errs, fmt.Sprintf("sum: %q is not a range frame", q.Sum))
+	}
+	return errs
+}
+
+// PQL returns the PQL for a valid filter.
+func (f Filter) PQL() string {
+	if f.Op != "" {
+		args := make([]string, len(f.Args))
+		for n, arg := range f.Args {
+			args[n] = arg.PQL()
+		}
+		return fmt.Sprintf("%s(%s)", builderOps[f.Op], strings.Join(args, ", "))
+	}
+	if f.Range != nil {
+		return fmt.Sprintf(`Range(frame="%s", %s >< [%d,%d])`, f.Frame, f.Frame, f.Range[0], f.Range[1])
+	}
+	return fmt.Sprintf(`Bitmap(frame="%s", rowID=%d)`, f.Frame, *f.Row)
+}
+
+// PQL returns the PQL for a valid query.
+func (q BuilderQuery) PQL() string {
+	if q.Sum == "" {
+		return fmt.Sprintf("Count(%s)", q.Filter.PQL())
+	}
+	return fmt.Sprintf(`Sum(%s, frame="%s", field="%s")`, q.Filter.PQL(), q.Sum, q.Sum)
+}
+
+// bsiCost is the relative cost of a range comparison, which reads a row per
+// bit of the field, against a single bitmap.
+const bsiCost = 20
+
+// Estimate is the relative cost of a query, counting the bitmaps and range
+// comparisons it reads and the operators combining them.
+type Estimate struct {
+	Bitmaps   int    `json:"bitmaps"`
+	Ranges    int    `json:"ranges"`
+	Operators int    `json:"operators"`
+	Depth     int    `json:"depth"`
+	Cost      int    `json:"cost"`
+	Class     string `json:"class"`
+}
+
+func (e *Estimate) add(f Filter, depth int) {
+	if depth > e.Depth {
+		e.Depth = depth
+	}
+	switch {
+	case f.Op != "":
+		e.Operators++
+		for _, arg := range f.Args {
+			e.add(arg, depth+1)
+		}
+	case f.Range != nil:
+		e.Ranges++
+	default:
+		e.Bitmaps++
+	}
+}
+
+// EstimateCost estimates the relative cost of a valid query.
+func EstimateCost(q BuilderQuery) Estimate {
+	var e Estimate
+	e.add(q.Filter, 1)
+	if q.Sum != "" {
+		e.Ranges++
+	}
+	e.Cost = e.Bitmaps + bsiCost*e.Ranges + e.Operators
+	switch {
+	case e.Cost <= 10:
+		e.Class = "cheap"
+	case e.Cost <= 100:
+		e.Class = "moderate"
+	default:
+		e.Class = "expensive"
+	}
+	return e
+}
+
+// BuilderResult is the outcome of validating, estimating or running a query.
+type BuilderResult struct {
+	Valid    bool      `json:"valid"`
+	Errors   []string  `json:"errors,omitempty"`
+	PQL      string    `json:"pql,omitempty"`
+	Estimate *Estimate `json:"estimate,omitempty"`
+	Value    *int64    `json:"value,omitempty"`
+	Noise    string    `json:"noise,omitempty"`
+	Seconds  float64   `json:"seconds,omitempty"`
+}
+
+// HandleBuilderFrames serves the frames available to the query builder, with
+// row labels translated per ?lang= or Accept-Language.
+func (s *Server) HandleBuilderFrames(w http.ResponseWriter, r *http.Request) {
+	frames := s.BuilderFrames()
+	if lang := s.translations.Lang(r); lang != "" {
+		for _, bf := range frames {
+			for k := range bf.Rows {
+				bf.Rows[k].Label = s.translations.Translate(lang, bf.Rows[k].Label)
+			}
+		}
+		w.Header().Set("Content-Language", lang)
+	}
+	if err := json.NewEncoder(w).Encode(frames); err != nil {
+		logError(nil, "writing builder frames: %v\n", err)
+	}
+}
+
+// HandleBuilder validates, estimates or runs the BuilderQuery posted in the
+// request body, depending on the action in the path, e.g. /builder/run.
+// Invalid queries are answered with 422 and the list of problems.
+func (s *Server) HandleBuilder(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/builder/")
+	var q BuilderQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "decoding query: %v", err))
+		return
+	}
+
+	res := BuilderResult{Errors: s.Validate(q)}
+	res.Valid = len(res.Errors) == 0
+	status := http.StatusOK
+	if !res.Valid {
+		status = http.StatusUnprocessableEntity
+	} else {
+		res.PQL = q.PQL()
+		switch action {
+		case "validate":
+		case "estimate":
+			e := EstimateCost(q)
+			res.Estimate = &e
+		case "run":
+			start := time.Now()
+			response, err := s.query(s.Index.RawQuery(s.pql(res.PQL)))
+			res.Seconds = time.Since(start).Seconds()
+			if err != nil {
+				logWarn(nil, "running builder query %v: %v\n", res.PQL, err)
+				writeError(w, pilosaError(err))
+				return
+			}
+			kind, v := ResultSum, response.Result().Sum
+			if q.Sum == "" {
+				kind, v = ResultCount, int64(response.Result().Count)
+			}
+			v = s.noise.Apply(kind, v)
+			res.Value, res.Noise = &v, s.noise.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logError(nil, "writing builder result: %v\n", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// histogramBins is the number of bins in a bundle's latency histograms.
+const histogramBins = 20
+
+// Histogram counts samples in equal-width bins from Min to Max.
+type Histogram struct {
+	Name   string    `json:"name"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Counts []int     `json:"counts"`
+	Edges  []float64 `json:"edges"` // lower edge of each bin
+}
+
+// newHistogram bins xs into n equal-width bins.
+func newHistogram(name string, xs []float64, n int) Histogram {
+	h := Histogram{Name: name, Counts: make([]int, n), Edges: make([]float64, n)}
+	if len(xs) == 0 {
+		return h
+	}
+	h.Min, h.Max = xs[0], xs[0]
+	for _, x := range xs {
+		if x < h.Min {
+			h.Min = x
+		}
+		if x > h.Max {
+			h.Max = x
+		}
+	}
+	width := (h.Max - h.Min) / float64(n)
+	for i := range h.Edges {
+		h.Edges[i] = h.Min + float64(i)*width
+	}
+	for _, x := range xs {
+		i := n - 1
+		if width > 0 && x < h.Max {
+			i = int((x - h.Min) / width)
+		}
+		h.Counts[i]++
+	}
+	return h
+}
+
+// ClusterSnapshot describes the cluster a bundle was exported from.
+type ClusterSnapshot struct {
+	Time         time.Time    `json:"time"`
+	Pilosa       string       `json:"pilosa"`
+	Capabilities Capabilities `json:"capabilities"`
+	Schema       *Schema      `json:"schema,omitempty"`
+	SchemaError  string       `json:"schemaerror,omitempty"`
+}
+
+// bundleReport summarizes a run as plain text.
+func bundleReport(run Run) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Run %v: %v %v (%v)\n", run.ID, run.Type, run.Query, run.Status)
+	fmt.Fprintf(&buf, "Started %v", run.Started.UTC().Format(time.RFC3339))
+	if run.Finished != nil {
+		fmt.Fprintf(&buf, ", finished %v", run.Finished.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&buf, "\n\n")
+	for _, br := range run.Results {
+		if br.Error != nil {
+			fmt.Fprintf(&buf, "%v failed: %v\n", br.Name, br.Error)
+			continue
+		}
+		fmt.Fprintln(&buf, narrate(br))
+	}
+	if run.Diagnostic != nil {
+		fmt.Fprintf(&buf, "\nThe run stalled at %v with %d batches in flight; see diagnostic.json.\n",
+			run.Diagnostic.Time.UTC().Format(time.RFC3339), len(run.Diagnostic.InFlight))
+	}
+	return buf.String()
+}
+
+// Bundle writes a gzipped tarball of everything known about a run: its
+// metadata, per-query records as ndjson, latency histograms, the stall
+// diagnostic if any, a snapshot of the cluster, and a text report.
+func (s *Server) Bundle(run Run, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, body []byte) error {
+		hdr := &tar.Header{Name: run.ID + "/" + name, Mode: 0644, Size: int64(len(body)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(body)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		body, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, body)
+	}
+
+	if err := addJSON("run.json", run); err != nil {
+		return err
+	}
+	var records bytes.Buffer
+	enc := json.NewEncoder(&records)
+	for _, rec := range run.records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if err := add("results.ndjson", records.Bytes()); err != nil {
+		return err
+	}
+	histograms := []Histogram{}
+	for _, br := range run.Results {
+		if len(br.latencies) > 0 {
+			histograms = append(histograms, newHistogram(br.Name, br.latencies, histogramBins))
+		}
+	}
+	if err := addJSON("histograms.json", histograms); err != nil {
+		return err
+	}
+	if run.Diagnostic != nil {
+		if err := addJSON("diagnostic.json", run.Diagnostic); err != nil {
+			return err
+		}
+	}
+	cluster := ClusterSnapshot{Time: now.UTC(), Pilosa: strings.Join(s.pilosaHosts(), ","), Capabilities: s.GetCapabilities()}
+	if schema, err := s.GetSchema(); err != nil {
+		cluster.SchemaError = err.Error()
+	} else {
+		cluster.Schema = &schema
+	}
+	if err := addJSON("cluster.json", cluster); err != nil {
+		return err
+	}
+	if err := add("report.txt", []byte(bundleReport(run))); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// HandleBundle serves a run's bundle as a .tar.gz download.
+func (s *Server) HandleBundle(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.runs.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "run not found"))
+		return
+	}
+	var buf bytes.Buffer
+	if err := s.Bundle(run, &buf); err != nil {
+		logError(&run, "bundling run %v: %v\n", run.ID, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "%v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="run-%s.tar.gz"`, run.ID))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logError(&run, "writing bundle of run %v: %v\n", run.ID, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// PilosaFeatures are the features detected on the Pilosa server.
+type PilosaFeatures struct {
+	Version     string `json:"version"`
+	RangeFrames bool   `json:"rangeframes"`
+	RowAttrs    bool   `json:"rowattrs"`
+	ExistsFrame bool   `json:"existsframe"`
+	// IntersectReg is detected at startup; query sets using it are listed
+	// in Capabilities.Unsupported when it's missing.
+	IntersectReg bool   `json:"intersectreg"`
+	PQLSyntax    string `json:"pqlsyntax"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Capabilities lists the optional subsystems enabled in this deployment, so
+// clients can adapt to it. Subsystems that aren't part of this build are
+// listed as false.
+type Capabilities struct {
+	DemoVersion string          `json:"demoversion"`
+	Subsystems  map[string]bool `json:"subsystems"`
+	Languages   []string        `json:"languages"`
+	Pilosa      PilosaFeatures  `json:"pilosa"`
+	Unsupported []string        `json:"unsupported"`
+}
+
+// detectPilosaFeatures checks the live Pilosa schema for range frames and the
+// exists frame, and probes the row attribute API.
+func (s *Server) detectPilosaFeatures() PilosaFeatures {
+	var f PilosaFeatures
+	live, err := getPilosaSchema(s.pilosaAddr(), s.Index.Name())
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	if f.Version, err = s.PilosaVersion(); err != nil {
+		f.Version = versionUnreachable
+	}
+	f.IntersectReg = s.intersectReg
+	if s.adapter != nil {
+		f.PQLSyntax = s.adapter.Name()
+	}
+	_, f.ExistsFrame = live[existsFrame]
+	for name, options := range live {
+		if enabled, _ := options["rangeEnabled"].(bool); enabled || rangeFrames[name] {
+			f.RangeFrames = true
+		}
+	}
+	if f.ExistsFrame {
+		_, err := getRowAttrs(s.pilosaAddr(), s.Index.Name(), existsFrame)
+		f.RowAttrs = err == nil
+	}
+	return f
+}
+
+// GetCapabilities describes the enabled subsystems and detected Pilosa
+// features.
+func (s *Server) GetCapabilities() Capabilities {
+	c := Capabilities{
+		DemoVersion: Version,
+		Subsystems: map[string]bool{
+			"loader":            true,
+			"sqlcomparison":     false,
+			"registerqueries":   true,
+			"auth":              pilosaHTTP.authenticates(),
+			"scheduler":         false,
+			"distributedagents": false,
+			"rowattrlabels":     s.rowAttrs,
+			"discovery":         s.discovery != nil,
+			"snapshots":         s.snapshots.snapshotScript != "",
+			"restore":           s.snapshots.restoreScript != "",
+			"traceslowest":      s.traceSlowest > 0,
+			"noise":             s.noise != nil,
+		},
+		Languages:   []string{},
+		Pilosa:      s.detectPilosaFeatures(),
+		Unsupported: s.UnsupportedQuerySets(),
+	}
+	for lang := range s.translations {
+		c.Languages = append(c.Languages, lang)
+	}
+	sort.Strings(c.Languages)
+	return c
+}
+
+// HandleCapabilities serves the deployment's capabilities.
+func (s *Server) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(s.GetCapabilities()); err != nil {
+		logError(nil, "writing capabilities: %v\n", err)
+	}
+}
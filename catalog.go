@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// QueryDef defines a query set in the catalog, one JSON file per query set in
+// the queries directory. Format may be given as a string or as an array of
+// lines, and references each argset by name as {{name}}, in any order. It may
+// also call template functions, e.g. {{unionRows "lo_quantity_b" 1 24}}; see
+// templateFuncs. Setup and Teardown are as for NewRegisterQuerySet. Each
+// argset is generated from an ArgSpec, so rowIDs follow the dimension
+// mapping, and the order of ArgSets is the order queries iterate in.
+// OrderBy sorts written results, as parsed by ParseOrder. Kind is the
+// ResultKind of the queries, sum by default.
+type QueryDef struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Format      lines     `json:"format"`
+	Kind        string    `json:"kind,omitempty"`
+	Setup       []string  `json:"setup,omitempty"`
+	Teardown    []string  `json:"teardown,omitempty"`
+	ArgSets     []ArgSpec `json:"argsets"`
+	OrderBy     string    `json:"orderby,omitempty"`
+}
+
+// lines is a string that may be written in JSON as an array of lines.
+type lines string
+
+func (l *lines) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = lines(s)
+		return nil
+	}
+	var ls []string
+	if err := json.Unmarshal(b, &ls); err != nil {
+		return fmt.Errorf("want a string or an array of lines")
+	}
+	*l = lines(strings.Join(ls, "\n"))
+	return nil
+}
+
+// CityRef names a city by its nation and digit, e.g. UNITED KI1.
+type CityRef struct {
+	Nation string `json:"nation"`
+	Digit  int    `json:"digit"`
+}
+
+// ArgSpec generates one argset, named by Name; conventionally the name is the
+// frame whose rowID it fills in, e.g. lo_year. Exactly one generator may be
+// set:
+//
+//	{"values": [1993]}                rowIDs or values as given
+//	{"range": [1992, 1999]}           start to stop (exclusive), optional step
+//	{"regions": true}                 every region
+//	{"nations_of": "ASIA"}            the nations of a region
+//	{"cities_of": "UNITED STATES"}    the cities of a nation
+//	{"cities": [{"nation": "UNITED KINGDOM", "digit": 1}]}
+//	{"categories_of": [1, 2]}         the categories of SSB mfgrs MFGR#1 and MFGR#2
+//	{"brands_of": [1, 2]}             the brands of category MFGR#12
+//	{"brand_range": [2, 2, 21, 28]}   brands MFGR#2221 to MFGR#2228
+//
+// Note documents the argset and is otherwise ignored.
+type ArgSpec struct {
+	Name         string    `json:"name"`
+	Values       []int     `json:"values,omitempty"`
+	Range        []int     `json:"range,omitempty"`
+	Regions      bool      `json:"regions,omitempty"`
+	NationsOf    string    `json:"nations_of,omitempty"`
+	CitiesOf     string    `json:"cities_of,omitempty"`
+	Cities       []CityRef `json:"cities,omitempty"`
+	CategoriesOf []int     `json:"categories_of,omitempty"`
+	BrandsOf     []int     `json:"brands_of,omitempty"`
+	BrandRange   []int     `json:"brand_range,omitempty"`
+	Note         string    `json:"note,omitempty"`
+}
+
+// indexOrError looks up a region or nation name.
+func indexOrError(kind string, names []string, name string) (int, error) {
+	if n := indexOf(names, name); n >= 0 {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown %v %q", kind, name)
+}
+
+// Generate returns the argset's values under m.
+func (a ArgSpec) Generate(m *RowMapping) ([]int, error) {
+	set := 0
+	for _, isSet := range []bool{
+		a.Values != nil, a.Range != nil, a.Regions, a.NationsOf != "", a.CitiesOf != "",
+		a.Cities != nil, a.CategoriesOf != nil, a.BrandsOf != nil, a.BrandRange != nil,
+	} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("argset needs exactly one generator, has %d", set)
+	}
+
+	switch {
+	case a.Values != nil:
+		return a.Values, nil
+	case a.Range != nil:
+		if len(a.Range) != 2 && len(a.Range) != 3 {
+			return nil, fmt.Errorf("range needs start, stop and optional step")
+		}
+		step := 1
+		if len(a.Range) == 3 {
+			step = a.Range[2]
+		}
+		if step <= 0 || a.Range[1] < a.Range[0] {
+			return nil, fmt.Errorf("invalid range %v", a.Range)
+		}
+		return arange(a.Range[0], a.Range[1], step), nil
+	case a.Regions:
+		return arange(0, len(m.Regions), 1), nil
+	case a.NationsOf != "":
+		region, err := indexOrError("region", m.Regions, a.NationsOf)
+		if err != nil {
+			return nil, err
+		}
+		return m.NationsOf(region), nil
+	case a.CitiesOf != "":
+		nation, err := indexOrError("nation", m.Nations, a.CitiesOf)
+		if err != nil {
+			return nil, err
+		}
+		return m.CitiesOf(nation), nil
+	case a.Cities != nil:
+		var cities []int
+		for _, c := range a.Cities {
+			nation, err := indexOrError("nation", m.Nations, c.Nation)
+			if err != nil {
+				return nil, err
+			}
+			if c.Digit < 0 || c.Digit >= m.CitiesPerNation {
+				return nil, fmt.Errorf("invalid city digit %d", c.Digit)
+			}
+			cities = append(cities, m.City(nation, c.Digit))
+		}
+		return cities, nil
+	case a.CategoriesOf != nil:
+		var categories []int
+		for _, mfgr := range a.CategoriesOf {
+			if mfgr < 1 || mfgr > m.Mfgrs {
+				return nil, fmt.Errorf("invalid mfgr %d", mfgr)
+			}
+			categories = append(categories, m.CategoriesOf(mfgr)...)
+		}
+		return categories, nil
+	case a.BrandsOf != nil:
+		if len(a.BrandsOf) != 2 {
+			return nil, fmt.Errorf("brands_of needs mfgr and category")
+		}
+		return m.BrandsOf(a.BrandsOf[0], a.BrandsOf[1]), nil
+	default:
+		if len(a.BrandRange) != 4 {
+			return nil, fmt.Errorf("brand_range needs mfgr, category, first and last brand")
+		}
+		return m.BrandRange(a.BrandRange[0], a.BrandRange[1], a.BrandRange[2], a.BrandRange[3]), nil
+	}
+}
+
+// QuerySet builds the query set the definition describes under m.
+func (d QueryDef) QuerySet(m *RowMapping) (QuerySet, error) {
+	if d.Name == "" || d.Format == "" {
+		return QuerySet{}, fmt.Errorf("query set needs a name and a format")
+	}
+	argsets := make([][]int, len(d.ArgSets))
+	argnames := make([]string, len(d.ArgSets))
+	for k, spec := range d.ArgSets {
+		if spec.Name == "" {
+			return QuerySet{}, fmt.Errorf("%v: argset %d has no name", d.Name, k)
+		}
+		argnames[k] = spec.Name
+		values, err := spec.Generate(m)
+		if err != nil {
+			return QuerySet{}, fmt.Errorf("%v: argset %d: %v", d.Name, k, err)
+		}
+		if len(values) == 0 {
+			return QuerySet{}, fmt.Errorf("%v: argset %d is empty", d.Name, k)
+		}
+		argsets[k] = values
+	}
+	kind, err := parseResultKind(d.Kind)
+	if err != nil {
+		return QuerySet{}, fmt.Errorf("%v: %v", d.Name, err)
+	}
+	format, err := expandTemplates(string(d.Format))
+	if err != nil {
+		return QuerySet{}, fmt.Errorf("%v: %v", d.Name, err)
+	}
+	qs := NewRegisterQuerySet(d.Name, format, d.Setup, d.Teardown, argnames, argsets)
+	qs.Kind = kind
+	if err := qs.Validate(); err != nil {
+		return QuerySet{}, fmt.Errorf("%v: %v", d.Name, err)
+	}
+	order, err := qs.ParseOrder(d.OrderBy)
+	if err != nil {
+		return QuerySet{}, err
+	}
+	qs.order = order
+	return qs, nil
+}
+
+// queryCatalog holds the query sets loaded from the queries directory. It is
+// safe for concurrent use, and can be reloaded while the server runs.
+type queryCatalog struct {
+	mu           sync.RWMutex
+	names        []string
+	sets         map[string]QuerySet
+	descriptions map[string]string
+}
+
+// queries is the catalog getQuerySet draws from.
+var queries = &queryCatalog{sets: make(map[string]QuerySet)}
+
+// Load replaces the catalog with the query sets defined in dir, one *.json
+// file each, built under m. On error the catalog is left unchanged.
+func (c *queryCatalog) Load(dir string, m *RowMapping) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no query sets in %v", dir)
+	}
+	sets := make(map[string]QuerySet, len(files))
+	descriptions := make(map[string]string, len(files))
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var d QueryDef
+		if err := json.Unmarshal(body, &d); err != nil {
+			return fmt.Errorf("parsing %v: %v", file, err)
+		}
+		qs, err := d.QuerySet(m)
+		if err != nil {
+			return fmt.Errorf("%v: %v", file, err)
+		}
+		if _, ok := sets[qs.Name]; ok {
+			return fmt.Errorf("%v: query set %v is defined twice", file, qs.Name)
+		}
+		sets[qs.Name] = qs
+		descriptions[qs.Name] = d.Description
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names, c.sets, c.descriptions = names, sets, descriptions
+	return nil
+}
+
+// Get returns the named query set, or a zero QuerySet if there is none.
+func (c *queryCatalog) Get(name string) QuerySet {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sets[name]
+}
+
+// Description returns the named query set's description from its definition.
+func (c *queryCatalog) Description(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.descriptions[name]
+}
+
+// Names lists the query sets in the catalog, sorted.
+func (c *queryCatalog) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.names...)
+}
+
+// ReloadOnHangup reloads the catalog from dir whenever the process receives
+// SIGHUP, keeping the current query sets if the new ones don't load.
+func (c *queryCatalog) ReloadOnHangup(dir string, m *RowMapping) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := c.Load(dir, m); err != nil {
+				logWarn(nil, "reloading query sets: %v; keeping the previous catalog\n", err)
+				continue
+			}
+			logf(nil, "reloaded %d query sets from %v\n", len(c.Names()), dir)
+		}
+	}()
+}
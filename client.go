@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// retryPolicy says how often, and how patiently, requests to Pilosa that fail
+// transiently are retried.
+type retryPolicy struct {
+	// Attempts is the number of attempts per request, including the first.
+	Attempts int
+	// Backoff is the wait before the first retry. It doubles with each
+	// retry up to MaxBackoff, and each wait is jittered by up to half.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var defaultRetry = retryPolicy{Attempts: 3, Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// wait returns how long to wait before retrying after the nth attempt.
+func (p retryPolicy) wait(n int) time.Duration {
+	d := p.Backoff << uint(n-1)
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// serverError matches the status go-pilosa puts in errors for non-2xx
+// responses.
+var serverError = regexp.MustCompile(`\b5\d\d\b`)
+
+// isTransient reports whether err is worth retrying: a connection that was
+// refused, reset or dropped, or a 5xx from Pilosa. Timeouts are not, since
+// retrying a query that is too slow only makes it slower.
+func isTransient(err error) bool {
+	if isTimeout(err) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"connection reset", "connection refused", "broken pipe", "eof"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return strings.Contains(msg, "server error") && serverError.MatchString(msg)
+}
+
+// retry calls f until it succeeds, fails with an error that isn't transient,
+// or has been called s.retries.Attempts times, logging each failed attempt
+// against run, which may be nil. It returns f's last error.
+func (s *Server) retry(run *Run, what string, f func() error) error {
+	attempts := s.retries.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = f(); err == nil || !isTransient(err) || attempt == attempts {
+			return err
+		}
+		wait := s.retries.wait(attempt)
+		logWarn(run, "%v, attempt %d of %d: %v; retrying in %v\n", what, attempt, attempts, err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// query sends a query to Pilosa, retrying transient failures.
+func (s *Server) query(q pilosa.PQLQuery) (*pilosa.QueryResponse, error) {
+	var response *pilosa.QueryResponse
+	err := s.retry(nil, "pilosa query", func() error {
+		var err error
+		response, err = s.pilosaClient().Query(q, nil)
+		return err
+	})
+	return response, err
+}
+
+// parseHosts splits a --pilosa value, a comma-separated list of host:port,
+// into its hosts.
+func parseHosts(addrs string) []string {
+	var hosts []string
+	for _, host := range strings.Split(addrs, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// connection is the Pilosa client and the hosts it connects to, the first of
+// which gets the requests the client doesn't make. Rediscovery replaces it as
+// a whole, so readers never see a client and hosts that don't match.
+type connection struct {
+	client *pilosa.Client
+	hosts  []string
+}
+
+func (s *Server) connection() *connection {
+	return s.conn.Load().(*connection)
+}
+
+// pilosaClient returns the client for the current Pilosa hosts.
+func (s *Server) pilosaClient() *pilosa.Client {
+	return s.connection().client
+}
+
+// pilosaAddr returns the host requests the client doesn't make go to.
+func (s *Server) pilosaAddr() string {
+	return s.connection().hosts[0]
+}
+
+// pilosaHosts returns the current Pilosa hosts.
+func (s *Server) pilosaHosts() []string {
+	return s.connection().hosts
+}
+
+// newClient returns a client for hosts with the server's pool size. The client
+// balances queries across the hosts, and stops sending them to a host that
+// fails until the others have failed too.
+func (s *Server) newClient(hosts []string) (*pilosa.Client, error) {
+	uris := make([]*pilosa.URI, len(hosts))
+	for n, host := range hosts {
+		uri, err := pilosa.NewURIFromAddress(pilosaHTTP.url(host, ""))
+		if err != nil {
+			return nil, fmt.Errorf("parsing pilosa address %v: %v", host, err)
+		}
+		uris[n] = uri
+	}
+	options := &pilosa.ClientOptions{TLSConfig: pilosaHTTP.tls}
+	if s.poolSize > 0 {
+		options.PoolSizePerRoute, options.TotalPoolSize = s.poolSize, s.poolSize*len(uris)
+	}
+	return pilosa.NewClientWithCluster(pilosa.NewClusterWithHost(uris...), options), nil
+}
+
+// setHosts points the server at the Pilosa hosts in addrs. Requests Pilosa's
+// client doesn't make, such as for the schema, go to the first.
+func (s *Server) setHosts(addrs string) error {
+	hosts := parseHosts(addrs)
+	if len(hosts) == 0 {
+		return fmt.Errorf("no pilosa hosts in %q", addrs)
+	}
+	client, err := s.newClient(hosts)
+	if err != nil {
+		return err
+	}
+	s.conn.Store(&connection{client, hosts})
+	return nil
+}
+
+// SetPoolSize replaces the client with one keeping up to n connections to
+// each Pilosa host open, which should be at least the concurrency to avoid
+// reconnecting between queries.
+func (s *Server) SetPoolSize(n int) error {
+	s.poolSize = n
+	hosts := s.pilosaHosts()
+	client, err := s.newClient(hosts)
+	if err != nil {
+		return err
+	}
+	s.conn.Store(&connection{client, hosts})
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// maxSumMismatches bounds the mismatched sums listed in a RunComparison.
+const maxSumMismatches = 100
+
+// RunComparison diffs two runs of the same query set, e.g. before and after a
+// Pilosa upgrade. Changes are percentages of run 1's values. Any query whose
+// sum differs between the runs is a correctness regression.
+type RunComparison struct {
+	Run1       string         `json:"run1"`
+	Run2       string         `json:"run2"`
+	Query      string         `json:"query"`
+	Results    []ResultChange `json:"results"`
+	Compared   int            `json:"compared"`
+	Missing    int            `json:"missing"`
+	Mismatches int            `json:"mismatches"`
+	Sums       []SumMismatch  `json:"sums"`
+	Regression bool           `json:"regression"`
+}
+
+// ResultChange compares the results at the same position in two runs.
+// Latency tests whether their batch latencies differ significantly.
+type ResultChange struct {
+	Set           int        `json:"set"`
+	Name          string     `json:"name"`
+	Seconds1      float64    `json:"seconds1"`
+	Seconds2      float64    `json:"seconds2"`
+	SecondsChange float64    `json:"secondschange"`
+	QPS1          float64    `json:"qps1"`
+	QPS2          float64    `json:"qps2"`
+	QPSChange     float64    `json:"qpschange"`
+	Latency       Comparison `json:"latency"`
+}
+
+// SumMismatch is a query whose sum differs between two runs.
+type SumMismatch struct {
+	Set    int           `json:"set"`
+	Inputs []interface{} `json:"inputs"`
+	Sum1   interface{}   `json:"sum1"`
+	Sum2   interface{}   `json:"sum2"`
+	Change float64       `json:"change"`
+}
+
+// percentChange returns the change from a to b as a percentage of a, or 0 if
+// a is 0.
+func percentChange(a, b float64) float64 {
+	if a == 0 {
+		return 0
+	}
+	return 100 * (b - a) / a
+}
+
+// recordKey identifies a query within a run by its result set and inputs.
+func recordKey(rec QueryRecord) string {
+	return fmt.Sprintf("%d %v", rec.Set, rec.Inputs)
+}
+
+// CompareRuns diffs two runs. Queries present in only one run, for instance
+// because it failed partway, are counted as missing rather than mismatched.
+func CompareRuns(a, b Run) RunComparison {
+	c := RunComparison{Run1: a.ID, Run2: b.ID, Query: a.Query, Results: []ResultChange{}, Sums: []SumMismatch{}}
+	for n := 0; n < len(a.Results) && n < len(b.Results); n++ {
+		ra, rb := a.Results[n], b.Results[n]
+		c.Results = append(c.Results, ResultChange{
+			Set:           n,
+			Name:          ra.Name,
+			Seconds1:      ra.Seconds,
+			Seconds2:      rb.Seconds,
+			SecondsChange: percentChange(ra.Seconds, rb.Seconds),
+			QPS1:          ra.QPS,
+			QPS2:          rb.QPS,
+			QPSChange:     percentChange(ra.QPS, rb.QPS),
+			Latency:       CompareResults(ra, rb),
+		})
+	}
+
+	sums := make(map[string]QueryRecord, len(a.records))
+	for _, rec := range a.records {
+		sums[recordKey(rec)] = rec
+	}
+	for _, rec := range b.records {
+		key := recordKey(rec)
+		prev, ok := sums[key]
+		if !ok {
+			c.Missing++
+			continue
+		}
+		delete(sums, key)
+		c.Compared++
+		s1, s2 := sortValue(prev.Output), sortValue(rec.Output)
+		if s1 == s2 {
+			continue
+		}
+		c.Mismatches++
+		if len(c.Sums) < maxSumMismatches {
+			c.Sums = append(c.Sums, SumMismatch{
+				Set:    rec.Set,
+				Inputs: rec.Inputs,
+				Sum1:   prev.Output,
+				Sum2:   rec.Output,
+				Change: percentChange(s1, s2),
+			})
+		}
+	}
+	c.Missing += len(sums)
+	c.Regression = c.Mismatches > 0
+	return c
+}
+
+// findRun returns the run with the given ID, or else the only run started at
+// the given Unix timestamp.
+func (rs *runStore) findRun(ref string) (Run, bool) {
+	if run, ok := rs.Get(ref); ok {
+		return run, true
+	}
+	ts, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return Run{}, false
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var found *Run
+	for _, run := range rs.runs {
+		if run.Started.Unix() == ts {
+			if found != nil {
+				return Run{}, false
+			}
+			found = run
+		}
+	}
+	if found == nil {
+		return Run{}, false
+	}
+	return *found, true
+}
+
+// HandleCompare diffs two stored runs of the same query set, named by run ID
+// or start timestamp, e.g. /compare?run1=1514764800&run2=1514851200. It also
+// serves /runs/compare?a=RUN_ID&b=RUN_ID, which takes them as a and b.
+func (s *Server) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	keys := []string{"run1", "run2"}
+	if q.Get("run1") == "" && q.Get("run2") == "" {
+		keys = []string{"a", "b"}
+	}
+	var runs [2]Run
+	for n, key := range keys {
+		run, ok := s.runs.findRun(q.Get(key))
+		if !ok {
+			writeError(w, newAPIError(http.StatusNotFound, errNotFound, "%v %q not found, or ambiguous", key, q.Get(key)))
+			return
+		}
+		if run.Finished == nil {
+			writeError(w, newAPIError(http.StatusConflict, errConflict, "run %v hasn't finished", run.ID))
+			return
+		}
+		runs[n] = run
+	}
+	if runs[0].Query != runs[1].Query {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "runs are of different query sets, %v and %v", runs[0].Query, runs[1].Query))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(CompareRuns(runs[0], runs[1])); err != nil {
+		logError(nil, "writing comparison: %v\n", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// QueryOverride overrides the server's concurrency and batch size for one
+// query set. Zero values keep the server's.
+type QueryOverride struct {
+	Concurrency int `json:"concurrency,omitempty"`
+	BatchSize   int `json:"batchsize,omitempty"`
+}
+
+// Config is a benchmark profile loaded with --config. Options holds flag
+// values keyed by flag name, e.g.
+//
+//	{
+//		"pilosa": "node0.cluster:10101",
+//		"index": "ssb",
+//		"concurrency": 16,
+//		"listen": ":9000",
+//		"queries": {"3.1": {"concurrency": 8, "batchsize": 4}}
+//	}
+//
+// or the same in YAML or TOML.
+type Config struct {
+	Options map[string]interface{}
+	Queries map[string]QueryOverride
+}
+
+// loadConfig reads a config file: YAML if its name ends in .yaml or .yml,
+// TOML if in .toml, and JSON otherwise.
+func loadConfig(path string) (Config, error) {
+	var c Config
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(body, &c.Options)
+	case ".toml":
+		_, err = toml.Decode(string(body), &c.Options)
+	default:
+		err = json.Unmarshal(body, &c.Options)
+	}
+	if err != nil {
+		return c, fmt.Errorf("parsing %v: %v", path, err)
+	}
+	if queries, ok := c.Options["queries"]; ok {
+		delete(c.Options, "queries")
+		raw, err := json.Marshal(stringKeys(queries))
+		if err == nil {
+			err = json.Unmarshal(raw, &c.Queries)
+		}
+		if err != nil {
+			return c, fmt.Errorf("parsing queries in %v: %v", path, err)
+		}
+	}
+	return c, nil
+}
+
+// stringKeys converts the map[interface{}]interface{} YAML decodes nested
+// mappings to, which JSON can't encode, to map[string]interface{}.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = stringKeys(value)
+		}
+		return m
+	case map[string]interface{}:
+		for k, value := range v {
+			v[k] = stringKeys(value)
+		}
+	case []interface{}:
+		for n, value := range v {
+			v[n] = stringKeys(value)
+		}
+	}
+	return v
+}
+
+// Apply sets every flag in the config that wasn't given on the command line.
+// Flags set this way aren't marked as changed, so environment fallbacks still
+// take precedence over the config.
+func (c Config) Apply(flags *pflag.FlagSet) error {
+	names := make([]string, 0, len(c.Options))
+	for name := range c.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := flags.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown option %q in config", name)
+		}
+		if f.Changed {
+			continue
+		}
+		value := fmt.Sprint(c.Options[name])
+		if n, ok := c.Options[name].(float64); ok {
+			// Avoid exponents, which integer flags don't parse.
+			value = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("config option %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// querySettings returns the concurrency and batch size to run a query set
+// with: the server's, unless the config overrides them for it.
+func (s *Server) querySettings(qname string) (concurrency, batchSize int) {
+	concurrency, batchSize = s.concurrency, s.batchSize
+	if o, ok := s.queryOverrides[qname]; ok {
+		if o.Concurrency > 0 {
+			concurrency = o.Concurrency
+		}
+		if o.BatchSize > 0 {
+			batchSize = o.BatchSize
+		}
+	}
+	return concurrency, batchSize
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pipeline tracks one benchmark's batch and result channels. Both are
+// unbuffered, so their depth is the number of senders blocked on them:
+// batchesWaiting is 1 while the generator waits for a free worker, and
+// resultsWaiting counts workers waiting for the collector to take a result.
+type pipeline struct {
+	id             int
+	run            string
+	querySet       string
+	workers        int
+	started        time.Time
+	batchesWaiting int64
+	resultsWaiting int64
+}
+
+// pipelineTracker keeps track of the benchmarks currently running.
+type pipelineTracker struct {
+	mu        sync.Mutex
+	seq       int
+	pipelines map[int]*pipeline
+}
+
+func newPipelineTracker() *pipelineTracker {
+	return &pipelineTracker{pipelines: make(map[int]*pipeline)}
+}
+
+// Add registers a running benchmark of querySet with workers workers.
+func (t *pipelineTracker) Add(run *Run, querySet string, workers int) *pipeline {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	p := &pipeline{id: t.seq, querySet: querySet, workers: workers, started: time.Now()}
+	if run != nil {
+		p.run = run.ID
+	}
+	t.pipelines[p.id] = p
+	return p
+}
+
+// Remove unregisters a benchmark once it returns.
+func (t *pipelineTracker) Remove(p *pipeline) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pipelines, p.id)
+}
+
+// PipelineStats is the state of a running benchmark's channels.
+type PipelineStats struct {
+	Run            string    `json:"run,omitempty"`
+	QuerySet       string    `json:"queryset"`
+	Workers        int       `json:"workers"`
+	Started        time.Time `json:"started"`
+	BatchesWaiting int64     `json:"batcheswaiting"`
+	ResultsWaiting int64     `json:"resultswaiting"`
+}
+
+// List returns the running benchmarks' channel depths, oldest first.
+func (t *pipelineTracker) List() []PipelineStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]int, 0, len(t.pipelines))
+	for id := range t.pipelines {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	list := make([]PipelineStats, 0, len(ids))
+	for _, id := range ids {
+		p := t.pipelines[id]
+		list = append(list, PipelineStats{
+			Run:            p.run,
+			QuerySet:       p.querySet,
+			Workers:        p.workers,
+			Started:        p.started,
+			BatchesWaiting: atomic.LoadInt64(&p.batchesWaiting),
+			ResultsWaiting: atomic.LoadInt64(&p.resultsWaiting),
+		})
+	}
+	return list
+}
+
+// MemoryStats is the part of runtime.MemStats worth watching in a long run.
+type MemoryStats struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"totalalloc"`
+	Sys          uint64 `json:"sys"`
+	HeapInuse    uint64 `json:"heapinuse"`
+	HeapObjects  uint64 `json:"heapobjects"`
+	NumGC        uint32 `json:"numgc"`
+	PauseTotalNs uint64 `json:"pausetotalns"`
+}
+
+// DebugStats is a snapshot of the process's internals, for diagnosing a stuck
+// or slow run without attaching a debugger.
+type DebugStats struct {
+	Time       time.Time       `json:"time"`
+	Goroutines int             `json:"goroutines"`
+	InFlight   []InFlightBatch `json:"inflight"`
+	Pipelines  []PipelineStats `json:"pipelines"`
+	Memory     MemoryStats     `json:"memory"`
+	Jobs       []Progress      `json:"jobs"`
+}
+
+// active returns the Progress of every run that hasn't finished, oldest
+// first.
+func (rs *runStore) active() []Progress {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	jobs := []Progress{}
+	for _, id := range rs.order {
+		run := rs.runs[id]
+		if run.Finished != nil {
+			continue
+		}
+		jobs = append(jobs, Progress{
+			ID:        run.ID,
+			Type:      run.Type,
+			Query:     run.Query,
+			Status:    run.Status,
+			Completed: run.completed,
+			Total:     run.total,
+		})
+	}
+	return jobs
+}
+
+// DebugStats takes a DebugStats snapshot.
+func (s *Server) DebugStats() DebugStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return DebugStats{
+		Time:       time.Now().UTC(),
+		Goroutines: runtime.NumGoroutine(),
+		InFlight:   s.inflight.List(),
+		Pipelines:  s.pipelines.List(),
+		Memory: MemoryStats{
+			Alloc:        m.Alloc,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			HeapInuse:    m.HeapInuse,
+			HeapObjects:  m.HeapObjects,
+			NumGC:        m.NumGC,
+			PauseTotalNs: m.PauseTotalNs,
+		},
+		Jobs: s.runs.active(),
+	}
+}
+
+// HandleDebugStats serves a DebugStats snapshot.
+func (s *Server) HandleDebugStats(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(s.DebugStats()); err != nil {
+		logError(nil, "writing debug stats: %v\n", err)
+	}
+}
+
+// HandlePprof serves net/http/pprof under /debug/pprof/ if --pprof is set.
+// It is registered behind requireAdmin, since /debug/pprof/cmdline shows the
+// command line, with any tokens and secrets passed as flags.
+func (s *Server) HandlePprof(w http.ResponseWriter, r *http.Request) {
+	if !s.profiling {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "profiling is disabled, start the demo with --pprof"))
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+)
+
+// QueryDiff is a structural comparison of two query sets.
+type QueryDiff struct {
+	A          string            `json:"a"`
+	B          string            `json:"b"`
+	Added      []string          `json:"added"`
+	Removed    []string          `json:"removed"`
+	Reordered  []string          `json:"reordered"`
+	Operators  map[string][2]int `json:"operators"`
+	Iterations [2]int            `json:"iterations"`
+	ArgSets    []ArgSetDiff      `json:"argsets"`
+}
+
+// ArgSetDiff compares the argset for one dimension across two query sets.
+// IndexA or IndexB is -1 if the dimension is missing from that set.
+type ArgSetDiff struct {
+	Dimension  string `json:"dimension"`
+	IndexA     int    `json:"indexa"`
+	IndexB     int    `json:"indexb"`
+	LenA       int    `json:"lena"`
+	LenB       int    `json:"lenb"`
+	SameValues bool   `json:"samevalues"`
+}
+
+var (
+	// leafTerm matches the innermost calls of a format, e.g. Bitmap(...) and Range(...).
+	leafTerm = regexp.MustCompile(`\w+\([^()]*\)`)
+	// operator matches the name of every call in a format.
+	operator = regexp.MustCompile(`(\w+)\(`)
+	spaces   = regexp.MustCompile(`\s+`)
+)
+
+// formatTerms returns the leaf terms of a format in order, with whitespace
+// removed so formatting differences are ignored.
+func formatTerms(format string) []string {
+	return leafTerm.FindAllString(spaces.ReplaceAllString(format, ""), -1)
+}
+
+// lcs returns the longest common subsequence of a and b.
+func lcs(a, b []string) []string {
+	m := make([][]int, len(a)+1)
+	for i := range m {
+		m[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				m[i][j] = m[i+1][j+1] + 1
+			} else if m[i+1][j] >= m[i][j+1] {
+				m[i][j] = m[i+1][j]
+			} else {
+				m[i][j] = m[i][j+1]
+			}
+		}
+	}
+	var seq []string
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] == b[j] {
+			seq = append(seq, a[i])
+			i++
+			j++
+		} else if m[i+1][j] >= m[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return seq
+}
+
+// count returns the number of occurrences of each string.
+func count(xs []string) map[string]int {
+	c := make(map[string]int)
+	for _, x := range xs {
+		c[x]++
+	}
+	return c
+}
+
+// DiffQuerySets compares the formats and argsets of two query sets. Terms
+// present in both but outside their longest common ordering are reported as
+// reordered; argsets are matched by dimension name.
+func DiffQuerySets(a, b QuerySet) QueryDiff {
+	d := QueryDiff{
+		A:          a.Name,
+		B:          b.Name,
+		Added:      []string{},
+		Removed:    []string{},
+		Reordered:  []string{},
+		Operators:  make(map[string][2]int),
+		Iterations: [2]int{a.iterations, b.iterations},
+	}
+
+	ta, tb := formatTerms(a.Format), formatTerms(b.Format)
+	ca, cb := count(ta), count(tb)
+	for _, t := range ta {
+		if ca[t] > cb[t] {
+			d.Removed = append(d.Removed, t)
+			ca[t]--
+		}
+	}
+	ca = count(ta)
+	for _, t := range tb {
+		if cb[t] > ca[t] {
+			d.Added = append(d.Added, t)
+			cb[t]--
+		}
+	}
+	inOrder := count(lcs(ta, tb))
+	ca, cb = count(ta), count(tb)
+	for t, n := range ca {
+		common := n
+		if cb[t] < common {
+			common = cb[t]
+		}
+		for ; common > inOrder[t]; common-- {
+			d.Reordered = append(d.Reordered, t)
+		}
+	}
+
+	oa, ob := map[string]int{}, map[string]int{}
+	for _, m := range operator.FindAllStringSubmatch(a.Format, -1) {
+		oa[m[1]]++
+	}
+	for _, m := range operator.FindAllStringSubmatch(b.Format, -1) {
+		ob[m[1]]++
+	}
+	for op := range oa {
+		if oa[op] != ob[op] {
+			d.Operators[op] = [2]int{oa[op], ob[op]}
+		}
+	}
+	for op := range ob {
+		if oa[op] != ob[op] {
+			d.Operators[op] = [2]int{oa[op], ob[op]}
+		}
+	}
+
+	da, db := a.Dimensions(), b.Dimensions()
+	matched := make([]bool, len(db))
+	for i, name := range da {
+		diff := ArgSetDiff{Dimension: name, IndexA: i, IndexB: -1, LenA: len(a.ArgSets[i])}
+		for j, other := range db {
+			if !matched[j] && other == name {
+				matched[j] = true
+				diff.IndexB, diff.LenB = j, len(b.ArgSets[j])
+				diff.SameValues = reflect.DeepEqual(a.ArgSets[i], b.ArgSets[j])
+				break
+			}
+		}
+		d.ArgSets = append(d.ArgSets, diff)
+	}
+	for j, name := range db {
+		if !matched[j] {
+			d.ArgSets = append(d.ArgSets, ArgSetDiff{Dimension: name, IndexA: -1, IndexB: j, LenB: len(b.ArgSets[j])})
+		}
+	}
+	return d
+}
+
+// HandleQueryDiff serves the structural diff of two query sets, e.g.
+// /queries/diff?a=3.1&b=3.1r
+func (s *Server) HandleQueryDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	for _, key := range []string{"a", "b"} {
+		if getQuerySet(q.Get(key)).Format == "" {
+			writeError(w, newAPIError(http.StatusBadRequest, errUnknownQuery, "unknown query set %q; see /queries", q.Get(key)))
+			return
+		}
+	}
+	a, b := getQuerySet(q.Get("a")), getQuerySet(q.Get("b"))
+
+	diff := DiffQuerySets(a, b)
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		logError(nil, "writing diff %v/%v: %v\n", a.Name, b.Name, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// ValueLatency is the latency of the queries sharing one value of a
+// dimension. Each query is charged the latency of the batch it was sent in.
+type ValueLatency struct {
+	Value       int     `json:"value"`
+	Label       string  `json:"label,omitempty"`
+	Queries     int     `json:"queries"`
+	MeanSeconds float64 `json:"meanseconds"`
+	MaxSeconds  float64 `json:"maxseconds"`
+}
+
+// DimensionLatency breaks down query latency by the values of one argset.
+// Skew is the ratio of the slowest value's mean latency to the fastest's, so
+// e.g. a year whose shards are much slower than the rest stands out.
+type DimensionLatency struct {
+	Dimension string         `json:"dimension"`
+	Values    []ValueLatency `json:"values"`
+	Skew      float64        `json:"skew"`
+}
+
+// dimensionStats accumulates per-value latencies for each dimension of a
+// query set.
+type dimensionStats struct {
+	names []string
+	// sums, counts and maxes are keyed by dimension, then value.
+	sums   []map[int]float64
+	counts []map[int]int
+	maxes  []map[int]float64
+}
+
+// newDimensionStats returns a dimensionStats for qs, or nil if qs has fewer
+// than two dimensions, for which the breakdown adds nothing to the total.
+func newDimensionStats(qs QuerySet) *dimensionStats {
+	if qs.dim < 2 {
+		return nil
+	}
+	ds := &dimensionStats{names: qs.Dimensions()}
+	for range ds.names {
+		ds.sums = append(ds.sums, make(map[int]float64))
+		ds.counts = append(ds.counts, make(map[int]int))
+		ds.maxes = append(ds.maxes, make(map[int]float64))
+	}
+	return ds
+}
+
+func (ds *dimensionStats) add(res QueryResult) {
+	if ds == nil {
+		return
+	}
+	seconds := res.latency.Seconds()
+	for k, in := range res.inputs {
+		v, ok := in.(int)
+		if !ok || k >= len(ds.names) {
+			continue
+		}
+		ds.sums[k][v] += seconds
+		ds.counts[k][v]++
+		if seconds > ds.maxes[k][v] {
+			ds.maxes[k][v] = seconds
+		}
+	}
+}
+
+// Latencies returns the breakdown for each dimension, values in ascending
+// order.
+func (ds *dimensionStats) Latencies() []DimensionLatency {
+	if ds == nil {
+		return nil
+	}
+	var dls []DimensionLatency
+	for k, name := range ds.names {
+		dl := DimensionLatency{Dimension: name}
+		for v, n := range ds.counts[k] {
+			vl := ValueLatency{
+				Value:       v,
+				Queries:     n,
+				MeanSeconds: ds.sums[k][v] / float64(n),
+				MaxSeconds:  ds.maxes[k][v],
+			}
+			if label := localLabel(name, v); label != strconv.Itoa(v) {
+				vl.Label = label
+			}
+			dl.Values = append(dl.Values, vl)
+		}
+		if len(dl.Values) == 0 {
+			continue
+		}
+		sort.Slice(dl.Values, func(i, j int) bool { return dl.Values[i].Value < dl.Values[j].Value })
+		lo, hi := dl.Values[0].MeanSeconds, dl.Values[0].MeanSeconds
+		for _, vl := range dl.Values {
+			if vl.MeanSeconds < lo {
+				lo = vl.MeanSeconds
+			}
+			if vl.MeanSeconds > hi {
+				hi = vl.MeanSeconds
+			}
+		}
+		if lo > 0 {
+			dl.Skew = hi / lo
+		}
+		dls = append(dls, dl)
+	}
+	return dls
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rediscoverInterval bounds how often a failing connection triggers
+// re-resolution.
+const rediscoverInterval = 10 * time.Second
+
+// discovery resolves the Pilosa address, and optionally the index, from one
+// of:
+//
+//	srv:_pilosa._tcp.example.com        DNS SRV record
+//	consul:http://consul:8500/pilosa    Consul KV key
+//	etcd:http://etcd:2379/pilosa        etcd v2 key
+//
+// A key's value is either "host:port" or a JSON object
+// {"pilosa": "host:port", "index": "ssb"}.
+type discovery struct {
+	kind   string
+	target string
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newDiscovery(spec string) (*discovery, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid discovery spec %q, want srv:NAME, consul:URL or etcd:URL", spec)
+	}
+	switch parts[0] {
+	case "srv", "consul", "etcd":
+	default:
+		return nil, fmt.Errorf("unknown discovery type %q", parts[0])
+	}
+	return &discovery{kind: parts[0], target: parts[1]}, nil
+}
+
+// Resolve looks up the Pilosa address and index. The index is empty if the
+// source doesn't provide one.
+func (d *discovery) Resolve() (addr, index string, err error) {
+	switch d.kind {
+	case "srv":
+		_, srvs, err := net.LookupSRV("", "", d.target)
+		if err != nil {
+			return "", "", err
+		}
+		if len(srvs) == 0 {
+			return "", "", fmt.Errorf("no SRV records for %v", d.target)
+		}
+		// LookupSRV sorts by priority and randomizes by weight.
+		host := strings.TrimSuffix(srvs[0].Target, ".")
+		return fmt.Sprintf("%s:%d", host, srvs[0].Port), "", nil
+	case "consul":
+		var kvs []struct {
+			Value string `json:"Value"`
+		}
+		if err := getJSON(consulKVURL(d.target), &kvs); err != nil {
+			return "", "", err
+		}
+		if len(kvs) == 0 {
+			return "", "", fmt.Errorf("consul key %v is empty", d.target)
+		}
+		value, err := base64.StdEncoding.DecodeString(kvs[0].Value)
+		if err != nil {
+			return "", "", fmt.Errorf("decoding consul value: %v", err)
+		}
+		return parseDiscoveredValue(string(value))
+	case "etcd":
+		var resp struct {
+			Node struct {
+				Value string `json:"value"`
+			} `json:"node"`
+		}
+		if err := getJSON(etcdKeyURL(d.target), &resp); err != nil {
+			return "", "", err
+		}
+		return parseDiscoveredValue(resp.Node.Value)
+	}
+	return "", "", fmt.Errorf("unknown discovery type %q", d.kind)
+}
+
+// consulKVURL turns http://host:port/key into the Consul KV API URL.
+func consulKVURL(target string) string {
+	scheme, rest := splitScheme(target)
+	hostKey := strings.SplitN(rest, "/", 2)
+	if len(hostKey) == 1 {
+		return scheme + hostKey[0] + "/v1/kv/"
+	}
+	return scheme + hostKey[0] + "/v1/kv/" + hostKey[1]
+}
+
+// etcdKeyURL turns http://host:port/key into the etcd v2 keys API URL.
+func etcdKeyURL(target string) string {
+	scheme, rest := splitScheme(target)
+	hostKey := strings.SplitN(rest, "/", 2)
+	if len(hostKey) == 1 {
+		return scheme + hostKey[0] + "/v2/keys/"
+	}
+	return scheme + hostKey[0] + "/v2/keys/" + hostKey[1]
+}
+
+func splitScheme(url string) (scheme, rest string) {
+	if i := strings.Index(url, "://"); i >= 0 {
+		return url[:i+3], url[i+3:]
+	}
+	return "http://", url
+}
+
+// discoveryClient fetches keys from Consul or etcd. Its timeout bounds how
+// long a hung discovery service can hold up startup and rediscover, which
+// failing batch workers wait on.
+var discoveryClient = &http.Client{Timeout: 10 * time.Second}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := discoveryClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: unexpected status %v: %s", url, resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// parseDiscoveredValue parses a key's value: "host:port" or a JSON object
+// with pilosa and index.
+func parseDiscoveredValue(value string) (addr, index string, err error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") {
+		if value == "" {
+			return "", "", fmt.Errorf("empty pilosa address")
+		}
+		return value, "", nil
+	}
+	var v struct {
+		Pilosa string `json:"pilosa"`
+		Index  string `json:"index"`
+	}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return "", "", fmt.Errorf("parsing discovered value: %v", err)
+	}
+	if v.Pilosa == "" {
+		return "", "", fmt.Errorf("discovered value has no pilosa address")
+	}
+	return v.Pilosa, v.Index, nil
+}
+
+// rediscover re-resolves the Pilosa address after a connection failure and,
+// if it changed, switches the client to the new address. It does nothing
+// without --discover, or if it ran within rediscoverInterval.
+func (s *Server) rediscover(cause error) {
+	d := s.discovery
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.last) < rediscoverInterval {
+		return
+	}
+	d.last = time.Now()
+
+	addr, _, err := d.Resolve()
+	if err != nil {
+		logWarn(nil, "re-resolving pilosa after %v: %v\n", cause, err)
+		return
+	}
+	if addr == s.pilosaAddr() {
+		return
+	}
+	old := s.pilosaAddr()
+	if err := s.setHosts(addr); err != nil {
+		logWarn(nil, "re-resolved pilosa address %v: %v\n", addr, err)
+		return
+	}
+	logf(nil, "pilosa moved from %v to %v\n", old, addr)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DryRun is a query set's PQL as it would be sent, translated for the
+// connected Pilosa, for checking formats against argsets without running
+// anything. Problems lists expansions that went wrong: fmt's %!d(MISSING)
+// and %!(EXTRA ...) markers, where the format's placeholders and the
+// positional argsets don't match, and {{name}} placeholders no argset filled.
+// At most maxDetailRecords queries are listed; Truncated is set if there were
+// more.
+type DryRun struct {
+	Name       string        `json:"name"`
+	Iterations int           `json:"iterations"`
+	Setup      []string      `json:"setup,omitempty"`
+	Teardown   []string      `json:"teardown,omitempty"`
+	Queries    []DryRunQuery `json:"queries"`
+	Truncated  bool          `json:"truncated,omitempty"`
+	Problems   []string      `json:"problems,omitempty"`
+}
+
+// DryRunQuery is one query of a DryRun.
+type DryRunQuery struct {
+	Inputs []interface{} `json:"inputs"`
+	PQL    string        `json:"pql"`
+}
+
+// badExpansion matches what a format and argsets that don't fit leave in a
+// query.
+var badExpansion = regexp.MustCompile(`%!\w*\([^)]*\)|%!\w+|\{\{\w+\}\}`)
+
+// dryRun expands every query of qs without contacting Pilosa.
+func (s *Server) dryRun(qs QuerySet) DryRun {
+	dr := DryRun{Name: qs.Name, Iterations: qs.iterations, Queries: []DryRunQuery{}}
+	seen := make(map[string]bool)
+	check := func(what, pql string) {
+		for _, bad := range badExpansion.FindAllString(pql, -1) {
+			if !seen[bad] {
+				seen[bad] = true
+				dr.Problems = append(dr.Problems, fmt.Sprintf("%v: %v in %q", what, bad, pql))
+			}
+		}
+	}
+	for _, stmt := range qs.expandStatements(qs.setup) {
+		dr.Setup = append(dr.Setup, s.pql(stmt))
+		check("setup", stmt)
+	}
+	for _, stmt := range qs.expandStatements(qs.teardown) {
+		dr.Teardown = append(dr.Teardown, s.pql(stmt))
+		check("teardown", stmt)
+	}
+	for n := 0; n < qs.iterations; n++ {
+		q := qs.QueryResultN(n)
+		raw := strings.TrimSuffix(q.raw, "\n")
+		check(fmt.Sprintf("query %d", n), raw)
+		if n >= maxDetailRecords {
+			dr.Truncated = true
+			continue
+		}
+		dr.Queries = append(dr.Queries, DryRunQuery{Inputs: q.inputs, PQL: s.pql(raw)})
+	}
+	return dr
+}
+
+// writeDryRun serves the dry run of qs.
+func (s *Server) writeDryRun(w http.ResponseWriter, qs QuerySet) {
+	if err := json.NewEncoder(w).Encode(s.dryRun(qs)); err != nil {
+		logError(nil, "writing dry run of %v: %v\n", qs.Name, err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// EdgeCase is an unusual but valid query, along with whether Pilosa is
+// expected to return a zero sum for it.
+type EdgeCase struct {
+	Name       string `json:"name"`
+	Query      string `json:"query"`
+	ExpectZero bool   `json:"expectzero"`
+}
+
+// EdgeCaseResult is the outcome of running a single EdgeCase.
+type EdgeCaseResult struct {
+	EdgeCase
+	Sum   int64  `json:"sum"`
+	Noise string `json:"noise,omitempty"`
+	Error string `json:"error,omitempty"`
+	OK    bool   `json:"ok"`
+}
+
+// outOfRangeRowID is larger than any rowID used by the SSB dimension frames.
+const outOfRangeRowID = 1 << 32
+
+// dimensionFrames are the bitmap frames that query sets filter on.
+var dimensionFrames = []string{
+	"c_city", "c_nation", "c_region",
+	"s_city", "s_nation", "s_region",
+	"p_mfgr", "p_category", "p_brand1",
+	"lo_year", "lo_month", "lo_weeknum",
+}
+
+// sumRevenue wraps a filter in the Sum used by the SSB flight 1 queries.
+func sumRevenue(filter string) string {
+	return fmt.Sprintf(`Sum(%s, frame="lo_revenue_computed", field="lo_revenue_computed")`, filter)
+}
+
+// edgeCases generates queries covering empty intersections, out-of-range
+// rowIDs and maximal ranges.
+func edgeCases() []EdgeCase {
+	var cases []EdgeCase
+
+	// Out-of-range rowIDs on every dimension frame.
+	for _, frame := range dimensionFrames {
+		cases = append(cases, EdgeCase{
+			Name:       "out-of-range " + frame,
+			Query:      sumRevenue(fmt.Sprintf(`Bitmap(frame="%s", rowID=%d)`, frame, outOfRangeRowID)),
+			ExpectZero: true,
+		})
+	}
+
+	// Empty intersections.
+	cases = append(cases,
+		EdgeCase{
+			Name:       "empty intersect disjoint regions",
+			Query:      sumRevenue(`Intersect(Bitmap(frame="s_region", rowID=0), Bitmap(frame="s_region", rowID=1))`),
+			ExpectZero: true,
+		},
+		EdgeCase{
+			Name:       "empty intersect year outside data",
+			Query:      sumRevenue(`Intersect(Bitmap(frame="lo_year", rowID=1991), Bitmap(frame="s_region", rowID=0))`),
+			ExpectZero: true,
+		},
+		EdgeCase{
+			Name:       "empty intersect inverted range",
+			Query:      sumRevenue(`Intersect(Range(frame="lo_discount", lo_discount >= 7), Range(frame="lo_discount", lo_discount <= 3))`),
+			ExpectZero: true,
+		},
+	)
+
+	// Maximal ranges should succeed; their sums are data dependent.
+	cases = append(cases,
+		EdgeCase{
+			Name:  "maximal discount range",
+			Query: sumRevenue(`Range(frame="lo_discount", lo_discount >< [0,10])`),
+		},
+		EdgeCase{
+			Name:  "maximal quantity range",
+			Query: sumRevenue(`Range(frame="lo_quantity", lo_quantity >< [1,50])`),
+		},
+	)
+	return cases
+}
+
+// RunEdgeCases runs every edge case, verifying that Pilosa returns a result
+// rather than an error, and a zero sum where one is expected. Sums are
+// reported with noise added, like every other answer.
+func (s *Server) RunEdgeCases() []EdgeCaseResult {
+	cases := edgeCases()
+	results := make([]EdgeCaseResult, len(cases))
+	for n, ec := range cases {
+		res := EdgeCaseResult{EdgeCase: ec}
+		response, err := s.query(s.Index.RawQuery(s.pql(ec.Query)))
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			sum := response.Result().Sum
+			res.OK = !ec.ExpectZero || sum == 0
+			res.Sum, res.Noise = s.noise.Apply(ResultSum, sum), s.noise.String()
+		}
+		results[n] = res
+	}
+	return results
+}
+
+// HandleEdgeCases runs the edge-case queries and reports each outcome,
+// responding with a 500 status if any of them failed.
+func (s *Server) HandleEdgeCases(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.Path)
+	results := s.RunEdgeCases()
+	for _, res := range results {
+		if !res.OK {
+			logWarn(nil, "edge case %q failed: sum=%d err=%v\n", res.Name, res.Sum, res.Error)
+			w.WriteHeader(http.StatusInternalServerError)
+			break
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	err := enc.Encode(results)
+	if err != nil {
+		logError(nil, "writing results: %v to responsewriter: %v", results, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// APIError describes a failed request or benchmark. Handlers send it as
+// {"error": {...}} with Status as the HTTP status; in a BenchmarkResult it
+// explains why the result has no timings.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Codes of APIError.
+const (
+	errBadRequest   = "bad_request"
+	errUnknownQuery = "unknown_query"
+	errUnknownType  = "unknown_type"
+	errNotFound     = "not_found"
+	errUnsupported  = "unsupported"
+	errPilosa       = "pilosa_error"
+	errTimeout      = "timeout"
+	errInternal     = "internal"
+	errUnauthorized = "unauthorized"
+	errForbidden    = "forbidden"
+	errConflict     = "conflict"
+)
+
+func (e *APIError) Error() string { return e.Message }
+
+func newAPIError(status int, code, format string, args ...interface{}) *APIError {
+	return &APIError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// pilosaError classifies an error from a Pilosa request: 504 if it timed
+// out, else 502.
+func pilosaError(err error) *APIError {
+	if isTimeout(err) {
+		return newAPIError(http.StatusGatewayTimeout, errTimeout, "pilosa timed out: %v", err)
+	}
+	return newAPIError(http.StatusBadGateway, errPilosa, "pilosa: %v", err)
+}
+
+// isTimeout reports whether err is a network timeout. Errors wrapped with
+// fmt.Errorf lose their type, so it falls back to the message.
+func isTimeout(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded")
+}
+
+// writeError sends e as a JSON error envelope.
+func writeError(w http.ResponseWriter, e *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	body := struct {
+		Error *APIError `json:"error"`
+	}{e}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logError(nil, "writing error: %v\n", err)
+	}
+}
+
+// resultsError returns the error to respond with for a run's results: the
+// first result's error if every result failed, or nil if any succeeded or
+// there are none. Partial failures are reported in each result instead.
+func resultsError(results []BenchmarkResult) *APIError {
+	for _, br := range results {
+		if br.Error == nil {
+			return nil
+		}
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return results[0].Error
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scales maps the names accepted by --value-scale to a divisor and suffix.
+var scales = map[string]struct {
+	divisor float64
+	suffix  string
+}{
+	"":          {1, ""},
+	"none":      {1, ""},
+	"thousands": {1e3, "K"},
+	"millions":  {1e6, "M"},
+	"billions":  {1e9, "B"},
+}
+
+// ValueFormat controls how result values are displayed to people, in the
+// results viewer. Results files always hold raw values, so they can be
+// compared and parsed. The zero value prints raw values unchanged.
+type ValueFormat struct {
+	Scale     string
+	Currency  string
+	Separator string
+	Decimals  int
+}
+
+// NewValueFormat returns a ValueFormat, validating the scale name.
+func NewValueFormat(scale, currency, separator string, decimals int) (ValueFormat, error) {
+	if _, ok := scales[scale]; !ok {
+		return ValueFormat{}, fmt.Errorf("unknown scale: %q", scale)
+	}
+	if decimals < 0 {
+		return ValueFormat{}, fmt.Errorf("invalid decimals: %d", decimals)
+	}
+	return ValueFormat{scale, currency, separator, decimals}, nil
+}
+
+// IsRaw reports whether values are printed unchanged.
+func (f ValueFormat) IsRaw() bool {
+	return scales[f.Scale].divisor == 1 && f.Currency == "" && f.Separator == "" && f.Decimals == 0
+}
+
+// Format renders a result value. Non-integer values are printed as is.
+func (f ValueFormat) Format(v interface{}) string {
+	var n int64
+	switch x := v.(type) {
+	case int:
+		n = int64(x)
+	case int64:
+		n = x
+	case uint64:
+		n = int64(x)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+	if f.IsRaw() {
+		return strconv.FormatInt(n, 10)
+	}
+
+	sc := scales[f.Scale]
+	num := strconv.FormatFloat(float64(n)/sc.divisor, 'f', f.Decimals, 64)
+	sign := ""
+	if strings.HasPrefix(num, "-") {
+		sign, num = "-", num[1:]
+	}
+	intPart, fracPart := num, ""
+	if i := strings.IndexByte(num, '.'); i >= 0 {
+		intPart, fracPart = num[:i], num[i:]
+	}
+	return sign + f.Currency + groupDigits(intPart, f.Separator) + fracPart + sc.suffix
+}
+
+// groupDigits inserts sep between groups of three digits, e.g. 1234567 -> 1,234,567
+func groupDigits(digits, sep string) string {
+	if sep == "" || len(digits) <= 3 {
+		return digits
+	}
+	head := len(digits) % 3
+	if head == 0 {
+		head = 3
+	}
+	parts := []string{digits[:head]}
+	for n := head; n < len(digits); n += 3 {
+		parts = append(parts, digits[n:n+3])
+	}
+	return strings.Join(parts, sep)
+}
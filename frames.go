@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// demoFrames are the frames the demo's queries use.
+var demoFrames = []string{
+	"lo_quantity", // these frames X each have one field, field_X
+	"lo_quantity_b",
+	"lo_extendedprice",
+	"lo_discount",
+	"lo_discount_b",
+	"lo_revenue",
+	"lo_supplycost",
+	"lo_profit",
+	"lo_revenue_computed",
+	"c_city",
+	"c_nation",
+	"c_region",
+	"s_city",
+	"s_nation",
+	"s_region",
+	"p_mfgr",
+	"p_category",
+	"p_brand1",
+	"lo_year",
+	"lo_month",
+	"lo_weeknum",
+	existsFrame,
+}
+
+// frameRef matches the frame argument of a PQL call.
+var frameRef = regexp.MustCompile(`frame="?(\w+)"?`)
+
+// RequiredFrames returns the frames a query set's queries, setup and
+// teardown reference, sorted.
+func (s *QuerySet) RequiredFrames() []string {
+	seen := make(map[string]bool)
+	for _, stmt := range append(append([]string{s.Format}, s.setup...), s.teardown...) {
+		for _, m := range frameRef.FindAllStringSubmatch(stmt, -1) {
+			seen[m[1]] = true
+		}
+	}
+	frames := make([]string, 0, len(seen))
+	for f := range seen {
+		frames = append(frames, f)
+	}
+	sort.Strings(frames)
+	return frames
+}
+
+// MissingFrames maps each frame that query sets need but the index lacks to
+// the query sets needing it.
+func (s *Server) MissingFrames() map[string][]string {
+	return missingFrames(s.liveFrames)
+}
+
+// missingFrames maps each frame that query sets need but live lacks to the
+// query sets needing it.
+func missingFrames(live map[string]map[string]interface{}) map[string][]string {
+	missing := make(map[string][]string)
+	for _, name := range queries.Names() {
+		qs := getQuerySet(name)
+		for _, f := range qs.RequiredFrames() {
+			if _, ok := live[f]; !ok {
+				missing[f] = append(missing[f], name)
+			}
+		}
+	}
+	return missing
+}
+
+// ValidateFrames returns an error listing every missing frame and the query
+// sets needing it, or nil if all frames exist. Those query sets are
+// unsupported; the rest can still run.
+func (s *Server) ValidateFrames() error {
+	missing := s.MissingFrames()
+	if len(missing) == 0 {
+		return nil
+	}
+	frames := make([]string, 0, len(missing))
+	for f := range missing {
+		frames = append(frames, f)
+	}
+	sort.Strings(frames)
+	lines := make([]string, len(frames))
+	for n, f := range frames {
+		lines[n] = fmt.Sprintf("  %v (needed by %v)", f, strings.Join(missing[f], ", "))
+	}
+	return fmt.Errorf("index %v is missing %d frames:\n%v", s.Index.Name(), len(frames), strings.Join(lines, "\n"))
+}
+
+// intersectRegProbe is a cheap query that fails on Pilosa builds without the
+// IntersectReg extension.
+var intersectRegProbe = fmt.Sprintf(`Count(IntersectReg(Bitmap(frame="%s", rowID=0)))`, existsFrame)
+
+// probeIntersectReg reports whether the cluster supports IntersectReg.
+func (s *Server) probeIntersectReg() bool {
+	_, err := s.query(s.Index.RawQuery(s.pql(intersectRegProbe)))
+	if err != nil {
+		logWarn(nil, "IntersectReg not supported, disabling the r query sets: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// UsesIntersectReg reports whether a query set needs the IntersectReg
+// extension, which only patched Pilosa builds have.
+func (s *QuerySet) UsesIntersectReg() bool {
+	return strings.Contains(s.Format, "IntersectReg(")
+}
+
+// Supported returns an error explaining why the connected cluster can't run
+// a query set, or nil if it can.
+func (s *Server) Supported(qs QuerySet) error {
+	if qs.UsesIntersectReg() && !s.intersectReg {
+		return fmt.Errorf("query set %v uses IntersectReg, which this Pilosa cluster doesn't support; it requires a patched Pilosa", qs.Name)
+	}
+	if s.liveFrames == nil {
+		return nil
+	}
+	var missing []string
+	for _, f := range qs.RequiredFrames() {
+		if _, ok := s.liveFrames[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("query set %v needs frames %v, which index %v lacks", qs.Name, strings.Join(missing, ", "), s.Index.Name())
+	}
+	return nil
+}
+
+// UnsupportedQuerySets lists the query sets the connected cluster can't run.
+func (s *Server) UnsupportedQuerySets() []string {
+	unsupported := []string{}
+	for _, name := range queries.Names() {
+		if s.Supported(getQuerySet(name)) != nil {
+			unsupported = append(unsupported, name)
+		}
+	}
+	return unsupported
+}
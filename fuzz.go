@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// defaultFieldBounds are used for range frames whose bounds Pilosa doesn't
+// report.
+var defaultFieldBounds = map[string][2]int64{
+	"lo_quantity": {1, 50},
+	"lo_discount": {0, 10},
+}
+
+// fuzzer generates random valid builder queries over the server's frames.
+type fuzzer struct {
+	rng      *rand.Rand
+	maxDepth int
+	sets     []BuilderFrame
+	ranges   []BuilderFrame
+}
+
+func newFuzzer(frames []BuilderFrame, seed int64, maxDepth int) *fuzzer {
+	f := &fuzzer{rng: rand.New(rand.NewSource(seed)), maxDepth: maxDepth}
+	for _, bf := range frames {
+		switch {
+		case bf.Type == "range":
+			f.ranges = append(f.ranges, bf)
+		case len(bf.Rows) > 0:
+			f.sets = append(f.sets, bf)
+		}
+	}
+	return f
+}
+
+// bounds returns the field bounds of a range frame.
+func (f *fuzzer) bounds(bf BuilderFrame) (min, max int64) {
+	if bf.Min != nil && bf.Max != nil {
+		return *bf.Min, *bf.Max
+	}
+	if b, ok := defaultFieldBounds[bf.Name]; ok {
+		return b[0], b[1]
+	}
+	return 0, 10000000
+}
+
+// leaf returns a random Bitmap or Range term. Rows and range bounds are
+// occasionally picked outside the known domain.
+func (f *fuzzer) leaf() Filter {
+	if len(f.ranges) > 0 && (len(f.sets) == 0 || f.rng.Intn(4) == 0) {
+		bf := f.ranges[f.rng.Intn(len(f.ranges))]
+		min, max := f.bounds(bf)
+		lo := min + f.rng.Int63n(max-min+1)
+		hi := lo + f.rng.Int63n(max-lo+1)
+		if f.rng.Intn(20) == 0 {
+			hi = max + 1 + f.rng.Int63n(100)
+		}
+		return Filter{Frame: bf.Name, Range: []int64{lo, hi}}
+	}
+	bf := f.sets[f.rng.Intn(len(f.sets))]
+	row := bf.Rows[f.rng.Intn(len(bf.Rows))].ID
+	if f.rng.Intn(20) == 0 {
+		row = bf.Rows[len(bf.Rows)-1].ID + 1 + f.rng.Intn(100)
+	}
+	return Filter{Frame: bf.Name, Row: &row}
+}
+
+// filter returns a random filter nested at most depth operators deep.
+func (f *fuzzer) filter(depth int) Filter {
+	if depth == 0 || f.rng.Intn(3) == 0 {
+		return f.leaf()
+	}
+	ops := []string{"intersect", "union", "difference"}
+	op := Filter{Op: ops[f.rng.Intn(len(ops))]}
+	for n := 1 + f.rng.Intn(4); n > 0; n-- {
+		op.Args = append(op.Args, f.filter(depth-1))
+	}
+	return op
+}
+
+// Query returns a random Count or Sum query.
+func (f *fuzzer) Query() BuilderQuery {
+	q := BuilderQuery{Filter: f.filter(f.maxDepth)}
+	if len(f.ranges) > 0 && f.rng.Intn(2) == 0 {
+		q.Sum = f.ranges[f.rng.Intn(len(f.ranges))].Name
+	}
+	return q
+}
+
+// FuzzResult summarizes a fuzz run.
+type FuzzResult struct {
+	Seed    int64   `json:"seed"`
+	Sent    int     `json:"sent"`
+	Errors  int     `json:"errors"`
+	Seconds float64 `json:"seconds"`
+	// Down is set if Pilosa stopped answering, which ends the run.
+	Down bool `json:"down"`
+}
+
+// Fuzz sends random queries at rate per second until duration has passed or
+// count queries were sent (0 for no limit), logging every query that fails.
+// After a failure the cluster is checked with a trivial query; if that fails
+// too, Pilosa is assumed to have crashed and fuzzing stops.
+func (s *Server) Fuzz(seed int64, rate float64, duration time.Duration, count, maxDepth int) FuzzResult {
+	f := newFuzzer(s.BuilderFrames(), seed, maxDepth)
+	res := FuzzResult{Seed: seed}
+	if len(f.sets) == 0 && len(f.ranges) == 0 {
+		logf(nil, "fuzz: no frames to generate queries from\n")
+		return res
+	}
+
+	start := time.Now()
+	tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer tick.Stop()
+	for range tick.C {
+		if time.Since(start) > duration || (count > 0 && res.Sent >= count) {
+			break
+		}
+		q := f.Query()
+		pql := q.PQL()
+		res.Sent++
+		if _, err := s.pilosaClient().Query(s.Index.RawQuery(s.pql(pql)), nil); err != nil {
+			res.Errors++
+			logWarn(nil, "fuzz: query %d failed: %v\n%v\n", res.Sent, err, pql)
+			if _, err := s.pilosaClient().Query(s.Index.RawQuery(s.pql(fmt.Sprintf(`Count(Bitmap(frame="%s", rowID=0))`, existsFrame))), nil); err != nil {
+				logError(nil, "fuzz: pilosa not answering after query %d: %v\n", res.Sent, err)
+				res.Down = true
+				break
+			}
+		}
+		if res.Sent%1000 == 0 {
+			logf(nil, "fuzz: sent %d queries, %d errors\n", res.Sent, res.Errors)
+		}
+	}
+	res.Seconds = time.Since(start).Seconds()
+	return res
+}
+
+// runFuzz implements the fuzz command.
+func (s *Server) runFuzz(args []string) error {
+	flags := pflag.NewFlagSet("fuzz", pflag.ContinueOnError)
+	seed := flags.Int64("seed", 0, "random seed (default: current time)")
+	rate := flags.Float64("rate", 10, "queries per second")
+	duration := flags.Duration("duration", time.Minute, "how long to fuzz for")
+	count := flags.Int("count", 0, "stop after this many queries (0 for no limit)")
+	depth := flags.Int("depth", 3, "maximum nesting of Intersect/Union/Difference")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	res := s.Fuzz(*seed, *rate, *duration, *count, *depth)
+	logf(nil, "fuzz: seed %d, sent %d queries in %.1fs, %d errors\n", res.Seed, res.Sent, res.Seconds, res.Errors)
+	if res.Down {
+		return fmt.Errorf("pilosa stopped answering")
+	}
+	if res.Errors > 0 {
+		return fmt.Errorf("%d queries failed", res.Errors)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GenerateOptions configures the synthetic data generator, given to
+// --generate as e.g. "sf=0.01,seed=7".
+type GenerateOptions struct {
+	ScaleFactor float64
+	Seed        int64
+}
+
+// parseGenerate parses a --generate value. The seed defaults to 1, so the
+// same scale factor always produces the same data.
+func parseGenerate(s string) (GenerateOptions, error) {
+	opts := GenerateOptions{Seed: 1}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return opts, fmt.Errorf("invalid option %q, want key=value", kv)
+		}
+		var err error
+		switch parts[0] {
+		case "sf":
+			opts.ScaleFactor, err = strconv.ParseFloat(parts[1], 64)
+			if err == nil && (opts.ScaleFactor <= 0 || opts.ScaleFactor > 1) {
+				err = fmt.Errorf("want more than 0 and at most 1")
+			}
+		case "seed":
+			opts.Seed, err = strconv.ParseInt(parts[1], 10, 64)
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return opts, fmt.Errorf("invalid %v %q: %v", parts[0], parts[1], err)
+		}
+	}
+	if opts.ScaleFactor == 0 {
+		return opts, fmt.Errorf("missing sf")
+	}
+	return opts, nil
+}
+
+// scaled returns n times the scale factor, at least 1.
+func (o GenerateOptions) scaled(n int) int {
+	return int(math.Max(1, float64(n)*o.ScaleFactor+0.5))
+}
+
+// generator produces an SSB-like data set from a seed: table sizes follow
+// dbgen's at the scale factor, keys and dimension values are uniform, and
+// prices follow the TPC-H part price formula. It is not dbgen's data, but
+// the queries find the same shapes in it.
+type generator struct {
+	opts   GenerateOptions
+	rng    *rand.Rand
+	tables *dimensionTables
+	days   []int
+}
+
+// ssbFirstDay and ssbLastDay bound the date table, and ssbLastOrder the
+// order dates.
+var (
+	ssbFirstDay  = time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC)
+	ssbLastDay   = time.Date(1998, 12, 31, 0, 0, 0, 0, time.UTC)
+	ssbLastOrder = time.Date(1998, 8, 2, 0, 0, 0, 0, time.UTC)
+)
+
+// newGenerator generates the dimension tables under m.
+func newGenerator(opts GenerateOptions, m *RowMapping) *generator {
+	g := &generator{
+		opts: opts,
+		rng:  rand.New(rand.NewSource(opts.Seed)),
+		tables: &dimensionTables{
+			customers: make(map[int]geoRows),
+			suppliers: make(map[int]geoRows),
+			parts:     make(map[int]partRows),
+			dates:     make(map[int]dateRows),
+		},
+	}
+	geo := func() geoRows {
+		nation := g.rng.Intn(len(m.Nations))
+		return geoRows{City: m.City(nation, g.rng.Intn(m.CitiesPerNation)), Nation: nation, Region: m.RegionOf(nation)}
+	}
+	for key := 1; key <= opts.scaled(30000); key++ {
+		g.tables.customers[key] = geo()
+	}
+	for key := 1; key <= opts.scaled(2000); key++ {
+		g.tables.suppliers[key] = geo()
+	}
+	for key := 1; key <= opts.scaled(200000); key++ {
+		mfgr, category := g.rng.Intn(m.Mfgrs)+1, g.rng.Intn(m.CategoriesPerMfgr)+1
+		g.tables.parts[key] = partRows{
+			Mfgr:     m.Mfgr(mfgr),
+			Category: m.Category(mfgr, category),
+			Brand:    m.Brand(mfgr, category, g.rng.Intn(m.BrandsPerCategory)+1),
+		}
+	}
+	for day := ssbFirstDay; !day.After(ssbLastDay); day = day.AddDate(0, 0, 1) {
+		key := day.Year()*10000 + int(day.Month())*100 + day.Day()
+		g.tables.dates[key] = dateRows{Year: day.Year(), Month: int(day.Month()) - 1, Week: (day.YearDay()-1)/7 + 1}
+		if !day.After(ssbLastOrder) {
+			g.days = append(g.days, key)
+		}
+	}
+	return g
+}
+
+// lineOrders returns the number of lineorders to generate.
+func (g *generator) lineOrders() int {
+	return g.opts.scaled(6000000)
+}
+
+// retailPrice is the TPC-H price of a part, in cents.
+func retailPrice(partKey int) int {
+	return 90000 + (partKey/10)%20001 + 100*(partKey%1000)
+}
+
+// lineOrder generates the next lineorder.
+func (g *generator) lineOrder() lineOrder {
+	lo := lineOrder{
+		CustKey:   g.rng.Intn(len(g.tables.customers)) + 1,
+		PartKey:   g.rng.Intn(len(g.tables.parts)) + 1,
+		SuppKey:   g.rng.Intn(len(g.tables.suppliers)) + 1,
+		OrderDate: g.days[g.rng.Intn(len(g.days))],
+		Quantity:  g.rng.Intn(50) + 1,
+		Discount:  g.rng.Intn(11),
+	}
+	price := retailPrice(lo.PartKey)
+	lo.ExtendedPrice = lo.Quantity * price
+	lo.Revenue = lo.ExtendedPrice * (100 - lo.Discount) / 100
+	lo.SupplyCost = 6 * price / 10
+	return lo
+}
+
+// Generate creates a synthetic data set as described by opts and imports it
+// like Load, returning the number of lineorders imported. The same options
+// always produce the same data, so sums are reproducible.
+func (s *Server) Generate(opts GenerateOptions, batchSize uint) (int, error) {
+	g := newGenerator(opts, rowMap)
+	frames, err := s.InitSchema()
+	if err != nil {
+		return 0, err
+	}
+	l := s.newLineOrderLoader(frames, batchSize)
+	start := time.Now()
+	n := g.lineOrders()
+	for col := 0; col < n; col++ {
+		if err := l.add(g.tables, g.lineOrder(), uint64(col)); err != nil {
+			l.Close()
+			return col, err
+		}
+	}
+	if err := l.Close(); err != nil {
+		return n, err
+	}
+	logf(nil, "generated %d lineorders (sf=%v, seed=%d) in %v\n", n, opts.ScaleFactor, opts.Seed, time.Since(start))
+	return n, nil
+}
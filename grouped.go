@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// GroupedResult is a BenchmarkResult with the query set's outputs assembled
+// into a table, the way a SQL engine returns the GROUP BY of the SSB query:
+// one row per combination of values of the leading dimensions (GroupBy), and
+// one column per value of the last dimension (Pivot). Rows and columns are
+// labeled, and ordered as the argsets are. Rows whose outputs are all zero,
+// which a SQL engine would not return, are left out.
+type GroupedResult struct {
+	BenchmarkResult
+	GroupBy []string     `json:"groupby"`
+	Pivot   string       `json:"pivot"`
+	Columns []string     `json:"columns"`
+	Rows    []GroupedRow `json:"rows"`
+}
+
+// GroupedRow is a row of a GroupedResult. Values has one output per column,
+// null for queries that failed.
+type GroupedRow struct {
+	Group  []string      `json:"group"`
+	Values []interface{} `json:"values"`
+
+	// key orders rows by the position of their values in the argsets.
+	key []int
+}
+
+// argIndex returns the position of a value in the kth argset, or -1. Values
+// are compared as numbers, as inputs reloaded from JSON are float64.
+func (s *QuerySet) argIndex(k int, v interface{}) int {
+	for n, value := range s.ArgSets[k] {
+		if sortValue(v) == float64(value) {
+			return n
+		}
+	}
+	return -1
+}
+
+// groupRecords pivots the records of one result of qs into a GroupedResult.
+func (s *Server) groupRecords(qs QuerySet, br BenchmarkResult, records []QueryRecord) GroupedResult {
+	dims := qs.Dimensions()
+	g := GroupedResult{BenchmarkResult: br, GroupBy: []string{}, Columns: []string{}, Rows: []GroupedRow{}}
+	if len(dims) == 0 {
+		return g
+	}
+	last := len(dims) - 1
+	g.GroupBy, g.Pivot = dims[:last], dims[last]
+	for _, id := range qs.ArgSets[last] {
+		g.Columns = append(g.Columns, s.Label(g.Pivot, id))
+	}
+
+	rows := make(map[string]*GroupedRow)
+records:
+	for _, rec := range records {
+		if len(rec.Inputs) != len(dims) {
+			continue
+		}
+		key := make([]int, last)
+		for k := range key {
+			if key[k] = qs.argIndex(k, rec.Inputs[k]); key[k] < 0 {
+				continue records
+			}
+		}
+		col := qs.argIndex(last, rec.Inputs[last])
+		if col < 0 {
+			continue
+		}
+		row, ok := rows[fmt.Sprint(key)]
+		if !ok {
+			row = &GroupedRow{Group: make([]string, last), Values: make([]interface{}, len(g.Columns)), key: key}
+			for k := range row.Group {
+				row.Group[k] = s.Label(dims[k], qs.ArgSets[k][key[k]])
+			}
+			rows[fmt.Sprint(key)] = row
+		}
+		row.Values[col] = rec.Output
+	}
+
+	for _, row := range rows {
+		for _, v := range row.Values {
+			if sortValue(v) != 0 {
+				g.Rows = append(g.Rows, *row)
+				break
+			}
+		}
+	}
+	sort.Slice(g.Rows, func(i, j int) bool {
+		a, b := g.Rows[i].key, g.Rows[j].key
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	return g
+}
+
+// groupedResults pivots each of a run's results, for the grouped run type.
+func (s *Server) groupedResults(run *Run, qs QuerySet, results []BenchmarkResult) []GroupedResult {
+	bySet := s.runs.recordsBySet(run, len(results))
+	grouped := make([]GroupedResult, len(results))
+	for n, br := range results {
+		grouped[n] = s.groupRecords(qs, br, bySet[n])
+	}
+	return grouped
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Readiness reports whether the demo can serve benchmarks: Pilosa answers,
+// the index has every frame the query sets need, and it holds lineorders.
+// Checks maps each check to "ok" or what is wrong.
+type Readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Readiness checks Pilosa and the index now, apart from the lineorder count,
+// which is the one last counted (see RefreshLineOrderCount).
+func (s *Server) Readiness() Readiness {
+	rd := Readiness{Ready: true, Checks: make(map[string]string)}
+	fail := func(check string, format string, args ...interface{}) {
+		rd.Ready = false
+		rd.Checks[check] = fmt.Sprintf(format, args...)
+	}
+
+	live, err := getPilosaSchema(s.pilosaAddr(), s.Index.Name())
+	if err != nil {
+		fail("pilosa", "unreachable: %v", err)
+		fail("schema", "unknown")
+	} else {
+		rd.Checks["pilosa"] = "ok"
+		if missing := missingFrames(live); len(missing) > 0 {
+			frames := make([]string, 0, len(missing))
+			for f := range missing {
+				frames = append(frames, f)
+			}
+			sort.Strings(frames)
+			fail("schema", "index %v is missing frames %v", s.Index.Name(), frames)
+		} else {
+			rd.Checks["schema"] = "ok"
+		}
+	}
+
+	if s.NumLineOrders() == 0 {
+		fail("lineorders", "index %v has no lineorders", s.Index.Name())
+	} else {
+		rd.Checks["lineorders"] = "ok"
+	}
+	return rd
+}
+
+// HandleHealth reports that the process is alive, for liveness probes. It
+// doesn't contact Pilosa; see HandleReady.
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		logError(nil, "writing health: %v\n", err)
+	}
+}
+
+// HandleReady serves the Readiness, with status 503 if the demo isn't ready,
+// for readiness probes and load balancer health checks.
+func (s *Server) HandleReady(w http.ResponseWriter, r *http.Request) {
+	rd := s.Readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if !rd.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(rd); err != nil {
+		logError(nil, "writing readiness: %v\n", err)
+	}
+}
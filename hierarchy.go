@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Level is one level of a dimension hierarchy, e.g. nation within geography.
+type Level struct {
+	Name   string   `json:"name"`
+	Frames []string `json:"frames"`
+	Size   int      `json:"size"`
+}
+
+// Hierarchy is an ordered list of levels, from coarsest to finest. Parents and
+// children of a row are found through the RowMapping.
+type Hierarchy struct {
+	Name   string  `json:"name"`
+	Levels []Level `json:"levels"`
+
+	label    func(level, id int) string
+	children func(level, id int) []int
+	parent   func(level, id int) int
+}
+
+// Node is a row at one level of a hierarchy, with its parent and children.
+type Node struct {
+	Hierarchy string   `json:"hierarchy"`
+	Level     string   `json:"level"`
+	ID        int      `json:"id"`
+	Label     string   `json:"label"`
+	Frames    []string `json:"frames"`
+	Parent    *Node    `json:"parent,omitempty"`
+	Children  []Node   `json:"children,omitempty"`
+}
+
+// hierarchies is built from rowMap, and rebuilt if the mapping is replaced.
+var hierarchies = newHierarchies(rowMap)
+
+// newHierarchies returns the geography and part hierarchies of a mapping.
+func newHierarchies(m *RowMapping) map[string]*Hierarchy {
+	nations := len(m.Regions) * m.NationsPerRegion
+	categories := m.Mfgrs * m.CategoriesPerMfgr
+	return map[string]*Hierarchy{
+		"geography": {
+			Name: "geography",
+			Levels: []Level{
+				{"region", []string{"c_region", "s_region"}, len(m.Regions)},
+				{"nation", []string{"c_nation", "s_nation"}, nations},
+				{"city", []string{"c_city", "s_city"}, nations * m.CitiesPerNation},
+			},
+			label: func(level, id int) string {
+				switch level {
+				case 0:
+					return m.Regions[id]
+				case 1:
+					return m.Nations[id]
+				}
+				return m.CityName(id)
+			},
+			children: func(level, id int) []int {
+				if level == 0 {
+					return m.NationsOf(id)
+				}
+				return m.CitiesOf(id)
+			},
+			parent: func(level, id int) int {
+				if level == 1 {
+					return m.RegionOf(id)
+				}
+				return m.NationOf(id)
+			},
+		},
+		"part": {
+			Name: "part",
+			Levels: []Level{
+				{"mfgr", []string{"p_mfgr"}, m.Mfgrs},
+				{"category", []string{"p_category"}, categories},
+				{"brand", []string{"p_brand1"}, categories * m.BrandsPerCategory},
+			},
+			label: func(level, id int) string {
+				switch level {
+				case 0:
+					return m.MfgrName(id)
+				case 1:
+					return m.CategoryName(id)
+				}
+				return m.BrandName(id)
+			},
+			children: func(level, id int) []int {
+				if level == 0 {
+					return m.CategoriesOf(id + 1)
+				}
+				return m.BrandsOf(m.MfgrOf(id)+1, id%m.CategoriesPerMfgr+1)
+			},
+			parent: func(level, id int) int {
+				if level == 1 {
+					return m.MfgrOf(id)
+				}
+				return m.CategoryOf(id)
+			},
+		},
+	}
+}
+
+// levelIndex returns the index of the named level, or -1.
+func (h *Hierarchy) levelIndex(name string) int {
+	for n, l := range h.Levels {
+		if l.Name == name {
+			return n
+		}
+	}
+	return -1
+}
+
+func (h *Hierarchy) node(level, id int) Node {
+	return Node{
+		Hierarchy: h.Name,
+		Level:     h.Levels[level].Name,
+		ID:        id,
+		Label:     h.label(level, id),
+		Frames:    h.Levels[level].Frames,
+	}
+}
+
+// Node returns the row id at the named level, with its parent (roll-up) and
+// children (drill-down).
+func (h *Hierarchy) Node(levelName string, id int) (Node, error) {
+	level := h.levelIndex(levelName)
+	if level < 0 {
+		return Node{}, fmt.Errorf("unknown level %q in %v", levelName, h.Name)
+	}
+	if id < 0 || id >= h.Levels[level].Size {
+		return Node{}, fmt.Errorf("%v %d out of range", levelName, id)
+	}
+	n := h.node(level, id)
+	if level > 0 {
+		p := h.node(level-1, h.parent(level, id))
+		n.Parent = &p
+	}
+	if level < len(h.Levels)-1 {
+		for _, child := range h.children(level, id) {
+			n.Children = append(n.Children, h.node(level+1, child))
+		}
+	}
+	return n, nil
+}
+
+// RollUp returns the parent of a row, e.g. the region of a nation.
+func (h *Hierarchy) RollUp(levelName string, id int) (Node, error) {
+	n, err := h.Node(levelName, id)
+	if err != nil {
+		return Node{}, err
+	}
+	if n.Parent == nil {
+		return Node{}, fmt.Errorf("%v is the top level of %v", levelName, h.Name)
+	}
+	return *n.Parent, nil
+}
+
+// DrillDown returns the children of a row, e.g. the cities of a nation.
+func (h *Hierarchy) DrillDown(levelName string, id int) ([]Node, error) {
+	n, err := h.Node(levelName, id)
+	if err != nil {
+		return nil, err
+	}
+	if n.Children == nil {
+		return nil, fmt.Errorf("%v is the bottom level of %v", levelName, h.Name)
+	}
+	return n.Children, nil
+}
+
+// HandleHierarchies lists the hierarchies and their levels.
+func (s *Server) HandleHierarchies(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(hierarchies); err != nil {
+		logError(nil, "writing hierarchies: %v\n", err)
+	}
+}
+
+// HandleHierarchyNode serves one row of a hierarchy with its parent and
+// children, e.g. /hierarchy/geography/nation/12, with labels translated per
+// ?lang= or Accept-Language.
+func (s *Server) HandleHierarchyNode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h, ok := hierarchies[vars["hierarchy"]]
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "unknown hierarchy %q", vars["hierarchy"]))
+		return
+	}
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "invalid id %q", vars["id"]))
+		return
+	}
+	n, err := h.Node(vars["level"], id)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "%v", err))
+		return
+	}
+	if lang := s.translations.Lang(r); lang != "" {
+		s.translations.translateNode(lang, &n)
+		w.Header().Set("Content-Language", lang)
+	}
+	if err := json.NewEncoder(w).Encode(n); err != nil {
+		logError(nil, "writing hierarchy node: %v\n", err)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// HistoryEntry is a BenchmarkResult recorded in the benchmark history, with
+// what's needed to compare it with results from other days and clusters.
+type HistoryEntry struct {
+	Time          time.Time       `json:"time"`
+	RunID         string          `json:"runid"`
+	RunType       string          `json:"runtype"`
+	PilosaVersion string          `json:"pilosaversion"`
+	Index         string          `json:"index"`
+	ColumnCount   uint64          `json:"columncount"`
+	Result        BenchmarkResult `json:"result"`
+}
+
+// historyBucket holds the history's entries, keyed by historyKey, so they're
+// ordered by time and a time range is a cursor seek rather than a scan.
+var historyBucket = []byte("results")
+
+// historyOpenTimeout is how long to wait for another process to close the
+// history; BoltDB lets one process at a time open it.
+const historyOpenTimeout = time.Second
+
+// history records every finished BenchmarkResult in a BoltDB file, so results
+// outlive runs and restarts. Readers don't block Record: each Find reads a
+// consistent snapshot of the file. A nil history records nothing.
+type history struct {
+	db *bolt.DB
+}
+
+// openHistory returns the history stored in path, creating it and its
+// directory. A new history imports the JSON lines history that earlier
+// versions kept next to it, e.g. history.jsonl for history.db, if there is
+// one.
+func openHistory(path string) (*history, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: historyOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%v is open in another process", path)
+	} else if err != nil {
+		return nil, err
+	}
+	empty := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		empty = b.Stats().KeyN == 0
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	h := &history{db: db}
+	if legacy := strings.TrimSuffix(path, filepath.Ext(path)) + ".jsonl"; empty && legacy != path {
+		if err := h.importJSONL(legacy); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("importing %v: %v", legacy, err)
+		}
+	}
+	return h, nil
+}
+
+// importJSONL records the entries of a JSON lines history, then renames it so
+// it isn't imported again. A missing file imports nothing.
+func (h *history) importJSONL(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := h.Record(entries); err != nil {
+		return err
+	}
+	logf(nil, "imported %d history entries from %v\n", len(entries), path)
+	return os.Rename(path, path+".imported")
+}
+
+// Close closes the history's file.
+func (h *history) Close() error {
+	if h == nil {
+		return nil
+	}
+	return h.db.Close()
+}
+
+// historyKey is the key of an entry recorded at t: the time in nanoseconds,
+// then a sequence number, so entries recorded at the same time don't collide.
+func historyKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// Record adds entries to the history.
+func (h *history) Record(entries []HistoryEntry) error {
+	if h == nil || len(entries) == 0 {
+		return nil
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		for _, e := range entries {
+			value, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := b.Put(historyKey(e.Time, seq), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HistoryFilter selects history entries. Zero fields match everything; Limit
+// keeps the most recent entries.
+type HistoryFilter struct {
+	Query string
+	Index string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+func (f HistoryFilter) match(e HistoryEntry) bool {
+	return (f.Query == "" || e.Result.Name == f.Query) &&
+		(f.Index == "" || e.Index == f.Index) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Find returns the entries matching f, oldest first. Only entries between
+// Since and Until are read; with a Limit, they're read newest first and
+// reading stops once Limit entries match.
+func (h *history) Find(f HistoryFilter) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	if h == nil {
+		return entries, nil
+	}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		decode := func(k, v []byte) (HistoryEntry, error) {
+			var e HistoryEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return e, fmt.Errorf("entry %x: %v", k, err)
+			}
+			return e, nil
+		}
+		if f.Limit > 0 {
+			var k, v []byte
+			if f.Until.IsZero() {
+				k, v = c.Last()
+			} else if k, v = c.Seek(historyKey(f.Until, 0)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+			for ; k != nil && len(entries) < f.Limit; k, v = c.Prev() {
+				e, err := decode(k, v)
+				if err != nil {
+					return err
+				}
+				if !f.Since.IsZero() && e.Time.Before(f.Since) {
+					break
+				}
+				if f.match(e) {
+					entries = append(entries, e)
+				}
+			}
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+			return nil
+		}
+		k, v := c.First()
+		if !f.Since.IsZero() {
+			k, v = c.Seek(historyKey(f.Since, 0))
+		}
+		for ; k != nil; k, v = c.Next() {
+			e, err := decode(k, v)
+			if err != nil {
+				return err
+			}
+			if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+				break
+			}
+			if f.match(e) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// finish marks a run finished with its results and records them in the
+// history.
+func (s *Server) finish(run *Run, results []BenchmarkResult) {
+	s.runs.Finish(run, results)
+	entries := make([]HistoryEntry, len(results))
+	for n, br := range results {
+		entries[n] = HistoryEntry{
+			Time:          br.Started,
+			RunID:         run.ID,
+			RunType:       run.Type,
+			PilosaVersion: s.pilosaVersion,
+			Index:         br.Index,
+			ColumnCount:   br.ColumnCount,
+			Result:        br,
+		}
+		if br.Started.IsZero() {
+			entries[n].Time = run.Started.UTC()
+		}
+		if br.Index == "" {
+			entries[n].Index = s.Index.Name()
+		}
+	}
+	if err := s.history.Record(entries); err != nil {
+		logf(run, "recording history: %v\n", err)
+	}
+	s.influx.Write(s.runs, run, entries)
+	if finished, ok := s.runs.Get(run.ID); ok {
+		s.notifier.Notify(finished)
+	}
+}
+
+// reject answers a benchmark request of the given type and query set that
+// failed before its run started with err, and notifies --notify-url of it.
+func (s *Server) reject(w http.ResponseWriter, typ, query, requestID string, err *APIError) {
+	writeError(w, err)
+	s.notifier.Reject(typ, query, requestID, err)
+}
+
+// failOnPanic, deferred once a handler has started run, finishes the run
+// with a failed result if the handler panics before finishing it, so the
+// failure is recorded and notified, then lets the panic go on to
+// recoverPanics.
+func (s *Server) failOnPanic(run *Run) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if p != http.ErrAbortHandler && run.Finished == nil {
+		s.finish(run, []BenchmarkResult{failedResult(run.Query, run.Started, newAPIError(http.StatusInternalServerError, errInternal, "internal error: %v", p))})
+	}
+	panic(p)
+}
+
+// parseHistoryFilter parses the query, index, since, until and limit
+// parameters of a request for history entries.
+func parseHistoryFilter(r *http.Request) (HistoryFilter, *APIError) {
+	q := r.URL.Query()
+	f := HistoryFilter{Query: q.Get("query"), Index: q.Get("index")}
+	for key, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(key); v != "" {
+			parsed, err := parseHistoryTime(v)
+			if err != nil {
+				return f, newAPIError(http.StatusBadRequest, errBadRequest, "invalid %v %q, want a date or RFC 3339 time", key, v)
+			}
+			*t = parsed
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return f, newAPIError(http.StatusBadRequest, errBadRequest, "invalid limit %q", v)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// parseHistoryTime parses an RFC 3339 time or a date.
+func parseHistoryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// HandleResults lists recorded results, oldest first, optionally filtered by
+// query set, index and date range, e.g.
+// /results?query=2.1&since=2018-01-01&until=2018-02-01&limit=100
+// since is inclusive and until exclusive; both take dates or RFC 3339 times.
+func (s *Server) HandleResults(w http.ResponseWriter, r *http.Request) {
+	f, apiErr := parseHistoryFilter(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	entries, err := s.history.Find(f)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "reading history: %v", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logError(nil, "writing results: %v\n", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h, err := openHistory(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// Entries a minute apart, named by their minute, with two at minute 2;
+	// 2b is recorded after 2a, so it is newer. They are recorded out of
+	// order, as finishing runs may be.
+	t0 := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time { return t0.Add(time.Duration(minute) * time.Minute) }
+	entry := func(name string, minute int) HistoryEntry {
+		return HistoryEntry{Time: at(minute), Result: BenchmarkResult{Name: name}}
+	}
+	if err := h.Record([]HistoryEntry{entry("3", 3), entry("0", 0), entry("1", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Record([]HistoryEntry{entry("2a", 2), entry("2b", 2), entry("4", 4)}); err != nil {
+		t.Fatal(err)
+	}
+
+	halfPast := func(minute int) time.Time { return at(minute).Add(30 * time.Second) }
+	for _, test := range []struct {
+		name string
+		f    HistoryFilter
+		want []string
+	}{
+		{"all", HistoryFilter{}, []string{"0", "1", "2a", "2b", "3", "4"}},
+		{"since", HistoryFilter{Since: at(2)}, []string{"2a", "2b", "3", "4"}},
+		{"since between", HistoryFilter{Since: halfPast(2)}, []string{"3", "4"}},
+		{"since after last", HistoryFilter{Since: at(5)}, []string{}},
+		{"until", HistoryFilter{Until: at(2)}, []string{"0", "1"}},
+		{"until between", HistoryFilter{Until: halfPast(2)}, []string{"0", "1", "2a", "2b"}},
+		{"until before first", HistoryFilter{Until: at(0)}, []string{}},
+		{"since and until", HistoryFilter{Since: at(1), Until: at(4)}, []string{"1", "2a", "2b", "3"}},
+		{"limit", HistoryFilter{Limit: 2}, []string{"3", "4"}},
+		{"limit above count", HistoryFilter{Limit: 10}, []string{"0", "1", "2a", "2b", "3", "4"}},
+		{"limit within a time", HistoryFilter{Limit: 3}, []string{"2b", "3", "4"}},
+		{"limit and until", HistoryFilter{Limit: 2, Until: at(3)}, []string{"2a", "2b"}},
+		{"limit and until between", HistoryFilter{Limit: 1, Until: halfPast(2)}, []string{"2b"}},
+		{"limit and until after last", HistoryFilter{Limit: 2, Until: at(9)}, []string{"3", "4"}},
+		{"limit and until before first", HistoryFilter{Limit: 2, Until: at(0)}, []string{}},
+		{"limit and since", HistoryFilter{Limit: 10, Since: at(2)}, []string{"2a", "2b", "3", "4"}},
+		{"limit, since and until", HistoryFilter{Limit: 2, Since: at(1), Until: at(4)}, []string{"2b", "3"}},
+		{"limit beyond since", HistoryFilter{Limit: 10, Since: at(1), Until: at(3)}, []string{"1", "2a", "2b"}},
+		{"query", HistoryFilter{Query: "2a", Limit: 1}, []string{"2a"}},
+	} {
+		entries, err := h.Find(test.f)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Result.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
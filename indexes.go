@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// demoIndex is an index the demo can query, with its own lineorder count,
+// e.g. one per SSB scale factor.
+type demoIndex struct {
+	index      *pilosa.Index
+	lineOrders *lineOrderCount
+}
+
+// AddIndex makes an existing index available to requests naming it with
+// ?index=, and counts its lineorders.
+func (s *Server) AddIndex(name string) error {
+	if _, ok := s.indexes[name]; ok {
+		return nil
+	}
+	live, err := getPilosaSchema(s.pilosaAddr(), name)
+	if err != nil {
+		return fmt.Errorf("fetching pilosa schema: %v", err)
+	}
+	if len(live) == 0 {
+		return fmt.Errorf("index %v doesn't exist or has no frames", name)
+	}
+	index, err := pilosa.NewIndex(name, nil)
+	if err != nil {
+		return fmt.Errorf("pilosa.NewIndex: %v", err)
+	}
+	di := &demoIndex{index: index, lineOrders: &lineOrderCount{}}
+	count, err := s.getLineOrderCount(index)
+	if err != nil {
+		return err
+	}
+	di.lineOrders.set(count)
+	s.indexes[name] = di
+	return nil
+}
+
+// demoIndex returns the named index, or the server's own if name is "".
+func (s *Server) demoIndex(name string) (*demoIndex, bool) {
+	if name == "" {
+		name = s.Index.Name()
+	}
+	di, ok := s.indexes[name]
+	return di, ok
+}
+
+// IndexNames lists the indexes requests can select, sorted.
+func (s *Server) IndexNames() []string {
+	names := make([]string, 0, len(s.indexes))
+	for name := range s.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lineOrdersIn returns the lineorder count of an index and its age, or zeros
+// for an index the server doesn't manage, such as a tenant's.
+func (s *Server) lineOrdersIn(index *pilosa.Index) (uint64, time.Duration) {
+	if di, ok := s.indexes[index.Name()]; ok {
+		return di.lineOrders.Get()
+	}
+	return 0, 0
+}
+
+// indexParam validates the index query parameter of a request.
+func (s *Server) indexParam(r *http.Request) (string, *APIError) {
+	name := r.URL.Query().Get("index")
+	if _, ok := s.demoIndex(name); !ok {
+		return "", newAPIError(http.StatusBadRequest, errBadRequest, "unknown index %q, want one of %v", name, s.IndexNames())
+	}
+	return name, nil
+}
+
+// onIndex directs a query set at the run's index.
+func (s *Server) onIndex(run *Run, qs QuerySet) QuerySet {
+	if run.Index != "" {
+		if di, ok := s.demoIndex(run.Index); ok {
+			qs.index = di.index
+		}
+	}
+	return qs
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// influxWriteInterval is how often queued points are written, and
+// maxQueuedInfluxWrites how many runs' points may wait between writes before
+// new ones are dropped.
+const (
+	influxWriteInterval   = 5 * time.Second
+	maxQueuedInfluxWrites = 1000
+)
+
+// influx writes benchmark results to InfluxDB, or any time-series database
+// that accepts its line protocol over HTTP (VictoriaMetrics, QuestDB, ...), so
+// performance can be trended over months. Points are queued and written in
+// the background, so a slow database never holds up a response. A nil influx
+// writes nothing.
+type influx struct {
+	url     string
+	token   string
+	queries bool
+	client  *http.Client
+
+	mu      sync.Mutex
+	queue   [][]byte
+	dropped int
+
+	// writing is held while queued points are written, so Flush returns
+	// only once they're all out.
+	writing sync.Mutex
+}
+
+// newInflux returns an influx writing to url, the database's write endpoint,
+// e.g. http://localhost:8086/write?db=ssb for InfluxDB 1.x or
+// http://localhost:8086/api/v2/write?org=perf&bucket=ssb for 2.x, which needs
+// token. If queries is set, each query's latency is written too.
+func newInflux(url, token string, queries bool) *influx {
+	return &influx{url: url, token: token, queries: queries, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// influxEscaper escapes tag keys and values, and measurement names, for the
+// line protocol.
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+// influxString quotes a string field value.
+func influxString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// influxFloat formats a float field value.
+func influxFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// influxPoint is a line of the line protocol. Tags and fields are written in
+// order; field values are already formatted.
+type influxPoint struct {
+	measurement string
+	tags        [][2]string
+	fields      [][2]string
+	time        time.Time
+}
+
+func (p influxPoint) String() string {
+	var b bytes.Buffer
+	b.WriteString(influxEscaper.Replace(p.measurement))
+	for _, tag := range p.tags {
+		if tag[1] != "" {
+			fmt.Fprintf(&b, ",%s=%s", influxEscaper.Replace(tag[0]), influxEscaper.Replace(tag[1]))
+		}
+	}
+	for n, field := range p.fields {
+		sep := ","
+		if n == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(&b, "%s%s=%s", sep, influxEscaper.Replace(field[0]), field[1])
+	}
+	fmt.Fprintf(&b, " %d\n", p.time.UnixNano())
+	return b.String()
+}
+
+// influxPoints returns the points of a run's history entries: a benchmark
+// point for each, tagged with the query set, index, concurrency, batch size,
+// Pilosa version and run type, and, if records is not nil, a query point for
+// each of its queries. Query points share their benchmark's tags and are
+// spaced a nanosecond apart from its start, so none overwrites another.
+func influxPoints(entries []HistoryEntry, records [][]QueryRecord) []influxPoint {
+	var points []influxPoint
+	for n, e := range entries {
+		br := e.Result
+		tags := [][2]string{
+			{"query", br.Name},
+			{"index", e.Index},
+			{"concurrency", strconv.Itoa(br.Concurrency)},
+			{"batchsize", strconv.Itoa(br.BatchSize)},
+			{"pilosa_version", e.PilosaVersion},
+			{"run_type", e.RunType},
+		}
+		fields := [][2]string{
+			{"qps", influxFloat(br.QPS)},
+			{"seconds", influxFloat(br.Seconds)},
+			{"iterations", strconv.Itoa(br.Iterations) + "i"},
+			{"shard_qps", influxFloat(br.ShardQPS)},
+			{"column_count", strconv.FormatUint(e.ColumnCount, 10) + "i"},
+			{"failed", strconv.FormatBool(br.Error != nil)},
+			{"run_id", influxString(e.RunID)},
+		}
+		if l := br.Latency; l != nil {
+			fields = append(fields,
+				[2]string{"latency_mean", influxFloat(l.Mean)},
+				[2]string{"latency_p50", influxFloat(l.P50)},
+				[2]string{"latency_p95", influxFloat(l.P95)},
+				[2]string{"latency_p99", influxFloat(l.P99)},
+				[2]string{"latency_max", influxFloat(l.Max)})
+		}
+		points = append(points, influxPoint{"benchmark", tags, fields, e.Time})
+		if n >= len(records) {
+			continue
+		}
+		for i, rec := range records[n] {
+			if rec.Seconds == 0 {
+				continue
+			}
+			points = append(points, influxPoint{"query", tags, [][2]string{
+				{"seconds", influxFloat(rec.Seconds)},
+				{"inputs", influxString(fmt.Sprint(rec.Inputs))},
+				{"batch", influxString(rec.Batch)},
+			}, e.Time.Add(time.Duration(i))})
+		}
+	}
+	return points
+}
+
+// Write queues the points of a run's history entries.
+func (in *influx) Write(rs *runStore, run *Run, entries []HistoryEntry) {
+	if in == nil || len(entries) == 0 {
+		return
+	}
+	var records [][]QueryRecord
+	if in.queries {
+		records = rs.recordsBySet(run, len(entries))
+	}
+	var body bytes.Buffer
+	for _, p := range influxPoints(entries, records) {
+		body.WriteString(p.String())
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.queue) >= maxQueuedInfluxWrites {
+		in.dropped++
+		return
+	}
+	in.queue = append(in.queue, body.Bytes())
+}
+
+// StartWriter writes queued points every interval.
+func (in *influx) StartWriter(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			in.Flush()
+		}
+	}()
+}
+
+// Flush writes the queued points in one request, logging a failure rather
+// than retrying.
+func (in *influx) Flush() {
+	if in == nil {
+		return
+	}
+	in.writing.Lock()
+	defer in.writing.Unlock()
+	in.mu.Lock()
+	queue, dropped := in.queue, in.dropped
+	in.queue, in.dropped = nil, 0
+	in.mu.Unlock()
+	if dropped > 0 {
+		logWarn(nil, "dropped the results of %d runs for influx: more than %d queued between writes\n", dropped, maxQueuedInfluxWrites)
+	}
+	if len(queue) == 0 {
+		return
+	}
+	if err := in.write(bytes.Join(queue, nil)); err != nil {
+		logWarn(nil, "writing the results of %d runs to influx: %v\n", len(queue), err)
+	}
+}
+
+func (in *influx) write(body []byte) error {
+	req, err := http.NewRequest("POST", in.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if in.token != "" {
+		req.Header.Set("Authorization", "Token "+in.token)
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
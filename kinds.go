@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// ResultKind is the kind of result a query set's queries return, which
+// determines how responses are decoded.
+type ResultKind string
+
+const (
+	// ResultSum is a Sum's value, the default.
+	ResultSum ResultKind = "sum"
+	// ResultCount is a Count's number of columns.
+	ResultCount ResultKind = "count"
+	// ResultTopN is TopN's rows and their counts, as []TopNItem.
+	ResultTopN ResultKind = "topn"
+)
+
+// parseResultKind parses a result kind, defaulting to sum.
+func parseResultKind(s string) (ResultKind, error) {
+	switch ResultKind(s) {
+	case "", ResultSum:
+		return ResultSum, nil
+	case ResultCount, ResultTopN:
+		return ResultKind(s), nil
+	}
+	return "", fmt.Errorf("unknown result kind %q, want sum, count or topn", s)
+}
+
+// TopNItem is a row of a TopN result.
+type TopNItem struct {
+	ID    uint64 `json:"id"`
+	Count uint64 `json:"count"`
+}
+
+func (t TopNItem) String() string { return fmt.Sprintf("%d:%d", t.ID, t.Count) }
+
+// decode returns the output of a query from its result, with noise added.
+func (k ResultKind) decode(res *pilosa.QueryResult, noise *Noise) interface{} {
+	switch k {
+	case ResultCount:
+		return int(noise.Apply(k, int64(res.Count)))
+	case ResultTopN:
+		items := make([]TopNItem, 0, len(res.CountItems))
+		for _, item := range res.CountItems {
+			items = append(items, TopNItem{ID: item.ID, Count: uint64(noise.Apply(k, int64(item.Count)))})
+		}
+		return items
+	}
+	return int(noise.Apply(k, res.Sum))
+}
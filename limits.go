@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpLimits bounds what clients can make the demo's HTTP server do, so slow
+// or misbehaving clients can't exhaust the host. Zero values disable a limit.
+type httpLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	// WriteTimeout also bounds how long a benchmark request may run.
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+	MaxConns       int
+}
+
+// defaultHTTPLimits are generous enough for long benchmark requests.
+var defaultHTTPLimits = httpLimits{
+	ReadHeaderTimeout: 10 * time.Second,
+	ReadTimeout:       time.Minute,
+	WriteTimeout:      0,
+	IdleTimeout:       2 * time.Minute,
+	MaxHeaderBytes:    1 << 20,
+	MaxBodyBytes:      10 << 20,
+	MaxConns:          0,
+}
+
+// limitBody caps the size of request bodies.
+func limitBody(next http.Handler, max int64) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitListener accepts at most n simultaneous connections; further clients
+// wait in the kernel's accept queue.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func newLimitListener(l net.Listener, n int) net.Listener {
+	if n <= 0 {
+		return l
+	}
+	return &limitListener{l, make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+// limitConn releases its slot in the limitListener once, when closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// httpServer returns the http.Server for the demo with the configured limits,
+// logging requests, recovering from panics, and allowing --cors-origins.
+func (s *Server) httpServer() *http.Server {
+	return &http.Server{
+		Handler:           logRequests(recoverPanics(gzipResponses(cors(limitBody(s.Router, s.limits.MaxBodyBytes), s.corsOrigins)))),
+		ReadHeaderTimeout: s.limits.ReadHeaderTimeout,
+		ReadTimeout:       s.limits.ReadTimeout,
+		WriteTimeout:      s.limits.WriteTimeout,
+		IdleTimeout:       s.limits.IdleTimeout,
+		MaxHeaderBytes:    s.limits.MaxHeaderBytes,
+	}
+}
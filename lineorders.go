@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// existsFrame has a bit set in row 0 for every lineorder column. Loaders
+// maintain it so that counting lineorders doesn't depend on every column
+// having a value in some dimension frame.
+const existsFrame = "exists"
+
+// getLineOrderCount counts the lineorders in index with a single Count of the
+// exists frame, retrying transient failures as --pilosa-retries says. If the exists
+// frame is empty, as it is if the loader didn't maintain it, the p_mfgr rows
+// are summed instead.
+func (s *Server) getLineOrderCount(index *pilosa.Index) (uint64, error) {
+	var count uint64
+	err := s.retry(nil, "counting lineorders in "+index.Name(), func() error {
+		var err error
+		if count, err = s.countLineOrders(index); err != nil {
+			s.rediscover(err)
+		}
+		return err
+	})
+	return count, err
+}
+
+// countLineOrders counts lineorders once.
+func (s *Server) countLineOrders(index *pilosa.Index) (uint64, error) {
+	q := fmt.Sprintf(`Count(Bitmap(frame="%s", rowID=0))`, existsFrame)
+	response, err := s.pilosaClient().Query(index.RawQuery(s.pql(q)), nil)
+	if err != nil {
+		return 0, fmt.Errorf("counting %v frame: %v", existsFrame, err)
+	}
+	if count := response.Result().Count; count > 0 {
+		return count, nil
+	}
+	return s.countByMfgr(index)
+}
+
+// countByMfgr counts lineorders by summing the counts of the p_mfgr rows in
+// one batch, which under-counts lineorders without a mfgr.
+func (s *Server) countByMfgr(index *pilosa.Index) (uint64, error) {
+	raw := ""
+	for n := 0; n < rowMap.Mfgrs; n++ {
+		raw += fmt.Sprintf(`Count(Bitmap(frame="p_mfgr", rowID=%d))`, n)
+	}
+	response, err := s.pilosaClient().Query(index.RawQuery(s.pql(raw)), nil)
+	if err != nil {
+		return 0, fmt.Errorf("counting p_mfgr rows: %v", err)
+	}
+	var count uint64
+	for _, res := range response.Results() {
+		count += res.Count
+	}
+	return count, nil
+}
+
+// lineOrderCount holds the number of lineorders in the index, and when it was
+// last counted. It is safe for concurrent use.
+type lineOrderCount struct {
+	mu      sync.RWMutex
+	count   uint64
+	updated time.Time
+}
+
+// Get returns the count and its age.
+func (c *lineOrderCount) Get() (uint64, time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.count, time.Since(c.updated)
+}
+
+func (c *lineOrderCount) set(count uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count = count
+	c.updated = time.Now()
+}
+
+// NumLineOrders returns the current lineorder count.
+func (s *Server) NumLineOrders() uint64 {
+	count, _ := s.lineOrders.Get()
+	return count
+}
+
+// RefreshLineOrderCount recounts the lineorders in the server's index, and in
+// any others it manages, returning the count and error of its own. It should
+// be called whenever data is loaded. If counting fails the previous count is
+// kept, and its age, reported as ColumnAge, keeps growing.
+func (s *Server) RefreshLineOrderCount() (uint64, error) {
+	for name, di := range s.indexes {
+		if di.index == s.Index {
+			continue
+		}
+		count, err := s.getLineOrderCount(di.index)
+		if err != nil {
+			logWarn(nil, "recounting lineorders in %v: %v\n", name, err)
+			continue
+		}
+		di.lineOrders.set(count)
+	}
+	count, err := s.getLineOrderCount(s.Index)
+	if err != nil {
+		return s.NumLineOrders(), err
+	}
+	s.lineOrders.set(count)
+	return count, nil
+}
+
+// StartLineOrderRefresh recounts the lineorders periodically.
+func (s *Server) StartLineOrderRefresh(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := s.RefreshLineOrderCount(); err != nil {
+				logWarn(nil, "refreshing lineorder count: %v\n", err)
+			}
+		}
+	}()
+}
+
+// HandleLineOrderRefresh recounts the lineorders, for use as a hook by
+// loaders once they finish importing.
+func (s *Server) HandleLineOrderRefresh(w http.ResponseWriter, r *http.Request) {
+	count, err := s.RefreshLineOrderCount()
+	if err != nil {
+		writeError(w, pilosaError(err))
+		return
+	}
+	logf(nil, "lineorder count refreshed: %d\n", count)
+	if err := json.NewEncoder(w).Encode(struct {
+		Count uint64 `json:"count"`
+	}{count}); err != nil {
+		logError(nil, "writing lineorder count: %v\n", err)
+	}
+}
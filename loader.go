@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pilosa "github.com/pilosa/go-pilosa"
+	"github.com/spf13/pflag"
+)
+
+// geoRows are the rowIDs of a customer's or supplier's city, nation and region.
+type geoRows struct{ City, Nation, Region int }
+
+// partRows are the rowIDs of a part's mfgr, category and brand.
+type partRows struct{ Mfgr, Category, Brand int }
+
+// dateRows are the rowIDs of a date's year, month (0 for January) and week.
+type dateRows struct{ Year, Month, Week int }
+
+// dimensionTables holds the dimension rows of each key of the dbgen tables,
+// for denormalizing lineorders.
+type dimensionTables struct {
+	customers map[int]geoRows
+	suppliers map[int]geoRows
+	parts     map[int]partRows
+	dates     map[int]dateRows
+}
+
+// loadDimensionTables reads customer.tbl, supplier.tbl, part.tbl and date.tbl
+// from dir, converting values to rowIDs with m.
+func loadDimensionTables(dir string, m *RowMapping) (*dimensionTables, error) {
+	t := &dimensionTables{
+		customers: make(map[int]geoRows),
+		suppliers: make(map[int]geoRows),
+		parts:     make(map[int]partRows),
+		dates:     make(map[int]dateRows),
+	}
+	geo := func(rows map[int]geoRows) func([]string) error {
+		// key, then city, nation and region in columns 3-5.
+		return func(fields []string) error {
+			if len(fields) < 6 {
+				return fmt.Errorf("expected at least 6 columns, got %d", len(fields))
+			}
+			key, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return fmt.Errorf("invalid key %q", fields[0])
+			}
+			nation := m.Nation(fields[4])
+			if nation < 0 {
+				return fmt.Errorf("unknown nation %q", fields[4])
+			}
+			city := fields[3]
+			if len(city) < 2 {
+				return fmt.Errorf("invalid city %q", city)
+			}
+			digit, err := strconv.Atoi(city[len(city)-1:])
+			if err != nil {
+				return fmt.Errorf("invalid city %q", city)
+			}
+			rows[key] = geoRows{City: m.City(nation, digit), Nation: nation, Region: m.RegionOf(nation)}
+			return nil
+		}
+	}
+	part := func(fields []string) error {
+		// key, then mfgr, category and brand in columns 2-4.
+		if len(fields) < 5 {
+			return fmt.Errorf("expected at least 5 columns, got %d", len(fields))
+		}
+		key, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid key %q", fields[0])
+		}
+		var nm, nc, nb int
+		if _, err := fmt.Sscanf(fields[4], "MFGR#%1d%1d%d", &nm, &nc, &nb); err != nil {
+			return fmt.Errorf("parsing brand %q: %v", fields[4], err)
+		}
+		t.parts[key] = partRows{Mfgr: m.Mfgr(nm), Category: m.Category(nm, nc), Brand: m.Brand(nm, nc, nb)}
+		return nil
+	}
+	date := func(fields []string) error {
+		// datekey, year, month number and week number are columns 0, 4, 10
+		// and 11.
+		if len(fields) < 12 {
+			return fmt.Errorf("expected at least 12 columns, got %d", len(fields))
+		}
+		var values [4]int
+		for n, col := range []int{0, 4, 10, 11} {
+			v, err := strconv.Atoi(fields[col])
+			if err != nil {
+				return fmt.Errorf("invalid column %d: %q", col, fields[col])
+			}
+			values[n] = v
+		}
+		t.dates[values[0]] = dateRows{Year: values[1], Month: values[2] - 1, Week: values[3]}
+		return nil
+	}
+
+	for _, f := range []struct {
+		name  string
+		parse func([]string) error
+	}{
+		{"customer.tbl", geo(t.customers)},
+		{"supplier.tbl", geo(t.suppliers)},
+		{"part.tbl", part},
+		{"date.tbl", date},
+	} {
+		if err := readDimensionFile(filepath.Join(dir, f.name), f.parse); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// chanBitIterator implements pilosa.BitIterator over a channel, so a frame
+// can be imported while lineorders are still being read.
+type chanBitIterator chan pilosa.Bit
+
+func (it chanBitIterator) NextBit() (pilosa.Bit, error) {
+	bit, ok := <-it
+	if !ok {
+		return pilosa.Bit{}, io.EOF
+	}
+	return bit, nil
+}
+
+// chanValueIterator implements pilosa.ValueIterator over a channel.
+type chanValueIterator chan pilosa.FieldValue
+
+func (it chanValueIterator) NextValue() (pilosa.FieldValue, error) {
+	v, ok := <-it
+	if !ok {
+		return pilosa.FieldValue{}, io.EOF
+	}
+	return v, nil
+}
+
+// lineOrderLoader imports denormalized lineorders into every demo frame at
+// once, one import per frame.
+type lineOrderLoader struct {
+	bits   map[string]chanBitIterator
+	values map[string]chanValueIterator
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// newLineOrderLoader starts an import into each frame.
+func (s *Server) newLineOrderLoader(frames map[string]*pilosa.Frame, batchSize uint) *lineOrderLoader {
+	l := &lineOrderLoader{bits: make(map[string]chanBitIterator), values: make(map[string]chanValueIterator)}
+	for name, frame := range frames {
+		l.wg.Add(1)
+		if rangeFrames[name] {
+			it := make(chanValueIterator, int(batchSize))
+			l.values[name] = it
+			go func(name string, frame *pilosa.Frame) {
+				defer l.wg.Done()
+				l.done(name, s.pilosaClient().ImportValueFrame(frame, name, it, batchSize), func() {
+					for range it {
+					}
+				})
+			}(name, frame)
+			continue
+		}
+		it := make(chanBitIterator, int(batchSize))
+		l.bits[name] = it
+		go func(name string, frame *pilosa.Frame) {
+			defer l.wg.Done()
+			l.done(name, s.pilosaClient().ImportFrame(frame, it, batchSize), func() {
+				for range it {
+				}
+			})
+		}(name, frame)
+	}
+	return l
+}
+
+// done records the outcome of a frame's import. After a failure the frame's
+// remaining input is drained, so the other imports can carry on.
+func (l *lineOrderLoader) done(name string, err error, drain func()) {
+	if err == nil {
+		return
+	}
+	l.mu.Lock()
+	l.errs = append(l.errs, fmt.Errorf("importing %v: %v", name, err))
+	l.mu.Unlock()
+	drain()
+}
+
+func (l *lineOrderLoader) setBit(frame string, row int, col uint64) {
+	l.bits[frame] <- pilosa.Bit{RowID: uint64(row), ColumnID: col}
+}
+
+func (l *lineOrderLoader) setValue(frame string, value int, col uint64) {
+	l.values[frame] <- pilosa.FieldValue{ColumnID: col, Value: int64(value)}
+}
+
+// Close waits for the imports to finish and returns the first error.
+func (l *lineOrderLoader) Close() error {
+	for _, it := range l.bits {
+		close(it)
+	}
+	for _, it := range l.values {
+		close(it)
+	}
+	l.wg.Wait()
+	if len(l.errs) > 0 {
+		return l.errs[0]
+	}
+	return nil
+}
+
+// lineOrder holds the columns of a lineorder the demo uses.
+type lineOrder struct {
+	CustKey, PartKey, SuppKey, OrderDate       int
+	Quantity, ExtendedPrice, Discount, Revenue int
+	SupplyCost                                 int
+}
+
+// parseLineOrder parses a line of dbgen's lineorder.tbl.
+func parseLineOrder(fields []string) (lineOrder, error) {
+	var lo lineOrder
+	if len(fields) < 17 {
+		return lo, fmt.Errorf("expected at least 17 columns, got %d", len(fields))
+	}
+	for _, c := range []struct {
+		col   int
+		value *int
+	}{
+		{2, &lo.CustKey}, {3, &lo.PartKey}, {4, &lo.SuppKey}, {5, &lo.OrderDate},
+		{8, &lo.Quantity}, {9, &lo.ExtendedPrice}, {11, &lo.Discount},
+		{12, &lo.Revenue}, {13, &lo.SupplyCost},
+	} {
+		n, err := strconv.Atoi(fields[c.col])
+		if err != nil {
+			return lo, fmt.Errorf("invalid column %d: %q", c.col, fields[c.col])
+		}
+		*c.value = n
+	}
+	return lo, nil
+}
+
+// Load reads SSB dbgen output from dir, joins each lineorder with its
+// customer, supplier, part and order date, and imports it into every demo
+// frame, creating them as needed with InitSchema. Lineorder n becomes column n. It returns
+// the number of lineorders loaded.
+func (s *Server) Load(dir string, m *RowMapping, batchSize uint) (int, error) {
+	tables, err := loadDimensionTables(dir, m)
+	if err != nil {
+		return 0, err
+	}
+	frames, err := s.InitSchema()
+	if err != nil {
+		return 0, err
+	}
+
+	path := filepath.Join(dir, "lineorder.tbl")
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	l := s.newLineOrderLoader(frames, batchSize)
+	start := time.Now()
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for ; scanner.Scan(); n++ {
+		lo, err := parseLineOrder(strings.Split(scanner.Text(), "|"))
+		if err == nil {
+			err = l.add(tables, lo, uint64(n))
+		}
+		if err != nil {
+			l.Close()
+			return n, fmt.Errorf("%v:%d: %v", path, n+1, err)
+		}
+		if (n+1)%1000000 == 0 {
+			logf(nil, "loaded %d lineorders in %v\n", n+1, time.Since(start))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		l.Close()
+		return n, fmt.Errorf("reading %v: %v", path, err)
+	}
+	if err := l.Close(); err != nil {
+		return n, err
+	}
+	logf(nil, "loaded %d lineorders in %v\n", n, time.Since(start))
+	return n, nil
+}
+
+// add imports one lineorder as column col.
+func (l *lineOrderLoader) add(t *dimensionTables, lo lineOrder, col uint64) error {
+	customer, ok := t.customers[lo.CustKey]
+	if !ok {
+		return fmt.Errorf("unknown customer %d", lo.CustKey)
+	}
+	supplier, ok := t.suppliers[lo.SuppKey]
+	if !ok {
+		return fmt.Errorf("unknown supplier %d", lo.SuppKey)
+	}
+	part, ok := t.parts[lo.PartKey]
+	if !ok {
+		return fmt.Errorf("unknown part %d", lo.PartKey)
+	}
+	date, ok := t.dates[lo.OrderDate]
+	if !ok {
+		return fmt.Errorf("unknown order date %d", lo.OrderDate)
+	}
+
+	l.setBit(existsFrame, 0, col)
+	l.setBit("c_city", customer.City, col)
+	l.setBit("c_nation", customer.Nation, col)
+	l.setBit("c_region", customer.Region, col)
+	l.setBit("s_city", supplier.City, col)
+	l.setBit("s_nation", supplier.Nation, col)
+	l.setBit("s_region", supplier.Region, col)
+	l.setBit("p_mfgr", part.Mfgr, col)
+	l.setBit("p_category", part.Category, col)
+	l.setBit("p_brand1", part.Brand, col)
+	l.setBit("lo_year", date.Year, col)
+	l.setBit("lo_month", date.Month, col)
+	l.setBit("lo_weeknum", date.Week, col)
+	l.setBit("lo_quantity_b", lo.Quantity, col)
+	l.setBit("lo_discount_b", lo.Discount, col)
+
+	l.setValue("lo_quantity", lo.Quantity, col)
+	l.setValue("lo_extendedprice", lo.ExtendedPrice, col)
+	l.setValue("lo_discount", lo.Discount, col)
+	l.setValue("lo_revenue", lo.Revenue, col)
+	l.setValue("lo_supplycost", lo.SupplyCost, col)
+	l.setValue("lo_profit", lo.Revenue-lo.SupplyCost, col)
+	l.setValue("lo_revenue_computed", lo.ExtendedPrice*lo.Discount, col)
+	return nil
+}
+
+// runLoad implements the load command, e.g. demo-ssb load /data/ssb
+func (s *Server) runLoad(args []string) error {
+	flags := pflag.NewFlagSet("load", pflag.ContinueOnError)
+	batchSize := flags.Uint("import-batch", 100000, "bits or values per import request")
+	force := flags.Bool("force", false, "load even if the index already has lineorders")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: load [--import-batch N] [--force] DIR")
+	}
+	dir := flags.Arg(0)
+
+	m, err := LoadRowMapping(dir)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(m, rowMap) {
+		logWarn(nil, "%v doesn't fit the default dimension mapping; run the demo with --dimensions %v to query it\n", dir, dir)
+	}
+	if count := s.NumLineOrders(); count > 0 {
+		if !*force {
+			return fmt.Errorf("index %v already has %d lineorders; use --force to load over them", s.Index.Name(), count)
+		}
+		if err := s.beforeDestructive("load " + dir); err != nil {
+			return err
+		}
+	}
+	if _, err := s.Load(dir, m, *batchSize); err != nil {
+		return err
+	}
+	count, err := s.RefreshLineOrderCount()
+	if err != nil {
+		return err
+	}
+	logf(nil, "lineorder count: %d\n", count)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stdLogger is the demo's logger. Entries about a run carry its ID and request
+// ID, so they can be matched with the run's results files and the
+// X-Request-ID and X-Run-ID headers of its response, and its query set,
+// concurrency and batch size.
+var stdLogger = &logrus.Logger{
+	Out:       os.Stdout,
+	Formatter: &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339},
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.InfoLevel,
+}
+
+// configureLogging sets the level, debug, info, warn or error, and the
+// format, text or json, of stdLogger, and sends the standard library's log
+// output through it as errors.
+func configureLogging(level, format string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("unknown log level %q, want debug, info, warn or error", level)
+	}
+	switch format {
+	case "text":
+		stdLogger.Formatter = &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339}
+	case "json":
+		stdLogger.Formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	default:
+		return fmt.Errorf("unknown log format %q, want text or json", format)
+	}
+	stdLogger.SetLevel(l)
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	return nil
+}
+
+// runLogger returns stdLogger with the fields of run, which may be nil.
+func runLogger(run *Run) logrus.FieldLogger {
+	if run == nil {
+		return stdLogger
+	}
+	fields := logrus.Fields{"run": run.ID}
+	if run.RequestID != "" {
+		fields["requestid"] = run.RequestID
+	}
+	if run.Query != "" {
+		fields["query"] = run.Query
+	}
+	if run.Concurrency > 0 {
+		fields["concurrency"] = run.Concurrency
+	}
+	if run.BatchSize > 0 {
+		fields["batchsize"] = run.BatchSize
+	}
+	return stdLogger.WithFields(fields)
+}
+
+// logMessage formats a log message, without the trailing newline callers
+// end it with.
+func logMessage(format string, args ...interface{}) string {
+	return strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+}
+
+// stdLogWriter receives the standard library's log output.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	stdLogger.Error(logMessage("%s", p))
+	return len(p), nil
+}
+
+// logDebug logs detail that is only useful when debugging.
+func logDebug(run *Run, format string, args ...interface{}) {
+	if stdLogger.Level >= logrus.DebugLevel {
+		runLogger(run).Debug(logMessage(format, args...))
+	}
+}
+
+// logf logs what the demo is doing, about run if it isn't nil.
+func logf(run *Run, format string, args ...interface{}) {
+	runLogger(run).Info(logMessage(format, args...))
+}
+
+// logWarn logs a problem the demo works around.
+func logWarn(run *Run, format string, args ...interface{}) {
+	runLogger(run).Warn(logMessage(format, args...))
+}
+
+// logError logs a failure.
+func logError(run *Run, format string, args ...interface{}) {
+	runLogger(run).Error(logMessage(format, args...))
+}
@@ -7,10 +7,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	pilosa "github.com/pilosa/go-pilosa"
+	"github.com/rakyll/statik/fs"
 	// ssb "github.com/pilosa/pdk/ssb"
 	"github.com/spf13/pflag"
 )
@@ -59,143 +67,640 @@ var nations = map[string]int{
 }
 
 func main() {
-	pilosaAddr := pflag.StringP("pilosa", "p", "localhost:10101", "host:port for pilosa")
+	pilosaAddr := pflag.StringP("pilosa", "p", "localhost:10101", "host:port for pilosa, or a comma-separated list of the hosts of a cluster")
 	concurrency := pflag.IntP("concurrency", "c", 32, "number of queries to execute in parallel")
 	batchSize := pflag.IntP("batchsize", "b", 1, "number of queries to combine into a single batch request")
 	index := pflag.StringP("index", "i", "ssb", "pilosa index")
+	listen := pflag.StringP("listen", "l", defaultListen, "address to serve the demo on (default from DEMO_LISTEN if set)")
+	valueScale := pflag.String("value-scale", "", "scale values shown in the results viewer: thousands, millions or billions")
+	currency := pflag.String("currency", "", "currency symbol prefixed to values shown in the results viewer")
+	separator := pflag.String("separator", "", "thousands separator for values shown in the results viewer")
+	decimals := pflag.Int("decimals", 0, "decimal places for scaled values shown in the results viewer")
+	noiseRound := pflag.Int64("noise-round", 0, "round returned sums to the nearest multiple, for public demos")
+	noiseJitter := pflag.Float64("noise-jitter", 0, "jitter returned sums by up to this percentage, for public demos")
+	noiseSecret := pflag.String("noise-secret", "", "secret keying --noise-jitter, so a query's jitter is the same across restarts (default from DEMO_NOISE_SECRET if set, else random)")
+	stallTimeout := pflag.Duration("stall-timeout", 5*time.Minute, "mark runs stalled if no result arrives within this window (0 disables)")
+	cancelStalled := pflag.Bool("cancel-stalled", false, "cancel stalled runs instead of only marking them")
+	lineOrderRefresh := pflag.Duration("lineorder-refresh", 0, "recount lineorders at this interval (0 disables)")
+	snapshotScript := pflag.String("snapshot-script", "", "script that snapshots the index; run before destructive operations")
+	restoreScript := pflag.String("restore-script", "", "script that restores a snapshot of the index")
+	adminToken := pflag.String("admin-token", "", "bearer token required by the /admin endpoints, which are disabled without one (default from DEMO_ADMIN_TOKEN if set)")
+	auditLogPath := pflag.String("audit-log", "audit.log", "file recording snapshots, restores and destructive operations")
+	resultWriter := pflag.String("result-writer", "file", "where per-query results go: "+strings.Join(resultWriters, ", ")+"; discard suits read-only filesystems")
+	resultsDir := pflag.String("results-dir", "results", "directory for results files")
+	resultsGzip := pflag.Bool("results-gzip", false, "gzip results files, as .txt.gz or .jsonl.gz")
+	resultsKeep := pflag.Int("results-keep", 0, "keep only this many of the newest results files of each query set (0 keeps all)")
+	resultsMaxAge := pflag.String("results-max-age", "", "remove results files older than this, e.g. 30d or 12h (default: keep them)")
+	resultsSweep := pflag.Duration("results-sweep", time.Hour, "how often to apply --results-keep and --results-max-age")
+	indexes := pflag.String("indexes", "", "further indexes requests can select with ?index=, comma separated, e.g. ssb-sf10,ssb-sf100")
+	goldenDir := pflag.String("golden-dir", "golden", "directory of golden answers for verify=true, one subdirectory per lineorder count")
+	historyPath := pflag.String("history", "history.db", "BoltDB file recording every benchmark result, served by /results (empty disables)")
+	warmup := pflag.String("warmup", "", "queries to run untimed before each benchmark: a count, e.g. 500, or a duration, e.g. 10s")
+	traceSlowest := pflag.Int("trace-slowest", 0, "re-execute the N slowest queries of each run alone and report their isolated latency")
+	shardWidth := pflag.Uint64("shard-width", defaultShardWidth, "columns per pilosa shard, reported with results")
+	scaleFactor := pflag.Float64("scale-factor", 0, "SSB scale factor for the scorecard (default: estimated from the lineorder count)")
+	useRowAttrs := pflag.Bool("row-attrs", false, "label results from Pilosa row attributes (see the attrs command) instead of local tables")
+	dimensions := pflag.String("dimensions", "", "directory of SSB dimension files (customer.tbl, supplier.tbl, part.tbl) to derive rowID mappings from")
+	queriesDir := pflag.String("queries-dir", "queries", "directory of query set definitions, one JSON file per query set; reloaded on SIGHUP")
+	translationsDir := pflag.String("translations", "translations", "directory of label translations, one LANG.json per language")
+	discover := pflag.String("discover", "", "resolve the pilosa address (and index) from srv:NAME, consul:URL or etcd:URL instead of --pilosa")
+	stateDir := pflag.String("state-dir", "", "directory to persist runs in, reloaded on restart (default: memory only)")
+	resume := pflag.Bool("resume", false, "re-execute runs interrupted by a restart (requires --state-dir)")
+	readHeaderTimeout := pflag.Duration("read-header-timeout", defaultHTTPLimits.ReadHeaderTimeout, "time allowed to read request headers (0 disables)")
+	readTimeout := pflag.Duration("read-timeout", defaultHTTPLimits.ReadTimeout, "time allowed to read a whole request (0 disables)")
+	writeTimeout := pflag.Duration("write-timeout", defaultHTTPLimits.WriteTimeout, "time allowed to handle a request and write the response, including benchmarks (0 disables)")
+	idleTimeout := pflag.Duration("idle-timeout", defaultHTTPLimits.IdleTimeout, "time to keep idle connections open (0 disables)")
+	maxHeaderBytes := pflag.Int("max-header-bytes", defaultHTTPLimits.MaxHeaderBytes, "maximum size of request headers")
+	maxBodyBytes := pflag.Int64("max-body-bytes", defaultHTTPLimits.MaxBodyBytes, "maximum size of request bodies (0 disables)")
+	maxConns := pflag.Int("max-conns", defaultHTTPLimits.MaxConns, "maximum simultaneous client connections (0 disables)")
+	retries := pflag.Int("pilosa-retries", defaultRetry.Attempts, "attempts per pilosa request when it fails with a connection error or 5xx (1 disables retries)")
+	backoff := pflag.Duration("pilosa-backoff", defaultRetry.Backoff, "wait before the first retry of a pilosa request, doubling with each retry")
+	poolSize := pflag.Int("pilosa-pool-size", 0, "connections to keep open to pilosa (default: the concurrency)")
+	var tlsOpts PilosaTLSOptions
+	pflag.BoolVar(&tlsOpts.TLS, "pilosa-tls", false, "connect to pilosa over HTTPS")
+	pflag.BoolVar(&tlsOpts.SkipVerify, "pilosa-tls-skip-verify", false, "accept any pilosa server certificate, e.g. a self-signed one")
+	pflag.StringVar(&tlsOpts.CAFile, "pilosa-ca", "", "PEM file of CA certificates to verify pilosa with")
+	pflag.StringVar(&tlsOpts.CertFile, "pilosa-cert", "", "PEM client certificate to present to pilosa")
+	pflag.StringVar(&tlsOpts.KeyFile, "pilosa-key", "", "PEM key of --pilosa-cert")
+	pflag.StringVar(&tlsOpts.Token, "pilosa-token", "", "token sent to pilosa as Authorization: Bearer (default from PILOSA_TOKEN if set)")
+	pqlSyntax := pflag.String("pql-syntax", "auto", "PQL syntax to emit: frame (Pilosa < 1.0), field (Pilosa 1.x), or auto to detect from the version")
+	initSchema := pflag.Bool("init-schema", false, "create the demo's frames with their cache types and range fields before starting")
+	generate := pflag.String("generate", "", "import a synthetic SSB-like data set into an empty index, e.g. sf=0.01,seed=7, for trying the demo without dbgen")
+	staticDir := pflag.String("static-dir", "", "serve the UI from this directory, e.g. static, instead of the copy built into the binary")
+	corsOrigins := pflag.String("cors-origins", "", "origins allowed to call the API from a browser, comma separated, e.g. https://demo.example.com, or * for any")
+	otlpEndpoint := pflag.String("otlp-endpoint", "", "OTLP/HTTP collector to send benchmark traces to, e.g. http://localhost:4318 (default from OTEL_EXPORTER_OTLP_ENDPOINT if set)")
+	statsdAddr := pflag.String("statsd-addr", "", "StatsD host:port to send batch latencies and benchmark throughput to, for Graphite")
+	statsdPrefix := pflag.String("statsd-prefix", "demo_ssb", "prefix of the names of metrics sent to --statsd-addr")
+	influxURL := pflag.String("influx-url", "", "write endpoint of InfluxDB, or another database taking its line protocol, to record each benchmark result in, e.g. http://localhost:8086/write?db=ssb")
+	influxToken := pflag.String("influx-token", "", "token for --influx-url, for InfluxDB 2.x (default from INFLUX_TOKEN if set)")
+	influxQueries := pflag.Bool("influx-queries", false, "also write each query's latency to --influx-url")
+	notifyURL := pflag.String("notify-url", "", "webhook to POST a JSON summary to when a run finishes or fails, e.g. a Slack incoming webhook")
+	profiling := pflag.Bool("pprof", false, "serve net/http/pprof profiles under /debug/pprof/")
+	logLevel := pflag.String("log-level", "info", "least severe log entries to write: debug, info, warn or error")
+	logFormat := pflag.String("log-format", "text", "log format: text, or json for one object per line")
+	configPath := pflag.String("config", "", "JSON, YAML or TOML file of option values keyed by flag name, plus per-query-set overrides under \"queries\"; flags take precedence")
+	// Flags after a command, e.g. fuzz --rate 50, are the command's own.
+	pflag.CommandLine.SetInterspersed(false)
 	pflag.Parse()
 
+	var config Config
+	if *configPath != "" {
+		var err error
+		config, err = loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("loading config: %v", err)
+		}
+		if err := config.Apply(pflag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := configureLogging(*logLevel, *logFormat); err != nil {
+		log.Fatal(err)
+	}
+	if args := pflag.Args(); len(args) > 0 && (args[0] == "bench" || args[0] == "suite") {
+		// Keep stdout for the results.
+		stdLogger.Out = os.Stderr
+	}
+
+	if env := os.Getenv("PILOSA_TOKEN"); env != "" && !pflag.CommandLine.Changed("pilosa-token") {
+		tlsOpts.Token = env
+	}
+	if err := configurePilosaHTTP(tlsOpts); err != nil {
+		log.Fatalf("configuring pilosa connection: %v", err)
+	}
+
+	if env := os.Getenv("DEMO_LISTEN"); env != "" && !pflag.CommandLine.Changed("listen") {
+		*listen = env
+	}
+	if env := os.Getenv("DEMO_ADMIN_TOKEN"); env != "" && !pflag.CommandLine.Changed("admin-token") {
+		*adminToken = env
+	}
+	if env := os.Getenv("INFLUX_TOKEN"); env != "" && !pflag.CommandLine.Changed("influx-token") {
+		*influxToken = env
+	}
+	if env := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); env != "" && !pflag.CommandLine.Changed("otlp-endpoint") {
+		*otlpEndpoint = env
+	}
+
+	var disc *discovery
+	if *discover != "" {
+		var err error
+		disc, err = newDiscovery(*discover)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addr, idx, err := disc.Resolve()
+		if err != nil {
+			log.Fatalf("discovering pilosa: %v", err)
+		}
+		*pilosaAddr = addr
+		if idx != "" && !pflag.CommandLine.Changed("index") {
+			*index = idx
+		}
+	}
+
+	if *dimensions != "" {
+		m, err := LoadRowMapping(*dimensions)
+		if err != nil {
+			log.Fatalf("loading dimension files: %v", err)
+		}
+		rowMap, hierarchies = m, newHierarchies(m)
+	}
+
+	if err := queries.Load(*queriesDir, rowMap); err != nil {
+		log.Fatalf("loading query sets: %v", err)
+	}
+	queries.ReloadOnHangup(*queriesDir, rowMap)
+
+	valueFormat, err := NewValueFormat(*valueScale, *currency, *separator, *decimals)
+	if err != nil {
+		log.Fatalf("parsing value format: %v", err)
+	}
+
+	if env := os.Getenv("DEMO_NOISE_SECRET"); env != "" && !pflag.CommandLine.Changed("noise-secret") {
+		*noiseSecret = env
+	}
+	if *noiseSecret == "" {
+		*noiseSecret = randomHex(32)
+	}
+	noise, err := NewNoise(*noiseRound, *noiseJitter, *noiseSecret)
+	if err != nil {
+		log.Fatalf("parsing noise options: %v", err)
+	}
+
 	server, err := NewServer(*pilosaAddr, *index)
 	if err != nil {
 		log.Fatalf("getting new server: %v", err)
 	}
+	server.pilosaVersion, err = server.PilosaVersion()
+	if err != nil {
+		logWarn(nil, "fetching pilosa version: %v\n", err)
+	}
+	server.adapter, err = newSchemaAdapter(*pqlSyntax, server.pilosaVersion, server.fieldModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logf(nil, "PQL syntax: %v\n", server.adapter.Name())
+	server.intersectReg = server.probeIntersectReg()
 	server.concurrency = *concurrency
 	server.batchSize = *batchSize
-	fmt.Printf("Pilosa: %s\nIndex: %s\n", *pilosaAddr, *index)
-	fmt.Printf("lineorder count: %d\n", server.NumLineOrders)
-	server.Serve()
+	server.retries.Attempts, server.retries.Backoff = *retries, *backoff
+	if *poolSize == 0 {
+		*poolSize = *concurrency
+	}
+	if err := server.SetPoolSize(*poolSize); err != nil {
+		log.Fatal(err)
+	}
+	server.valueFormat = valueFormat
+	server.noise = noise
+	server.rowAttrs = *useRowAttrs
+	server.scaleFactor = *scaleFactor
+	server.shardWidth = *shardWidth
+	server.queryOverrides = config.Queries
+	server.limits = httpLimits{
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+		MaxBodyBytes:      *maxBodyBytes,
+		MaxConns:          *maxConns,
+	}
+	server.traceSlowest = *traceSlowest
+	if indexOf(resultWriters, *resultWriter) < 0 {
+		log.Fatalf("unknown --result-writer %q, want one of %v", *resultWriter, strings.Join(resultWriters, ", "))
+	}
+	server.resultWriter, server.resultsDir, server.resultsGzip = *resultWriter, *resultsDir, *resultsGzip
+	server.retention.keep = *resultsKeep
+	if server.retention.maxAge, err = parseAge(*resultsMaxAge); err != nil {
+		log.Fatalf("parsing --results-max-age: %v", err)
+	}
+	if *resultsKeep < 0 || *resultsSweep <= 0 {
+		log.Fatalf("--results-keep must not be negative and --results-sweep must be positive")
+	}
+	server.goldenDir = *goldenDir
+	if *staticDir != "" {
+		server.static = http.Dir(*staticDir)
+	}
+	server.profiling = *profiling
+	if *otlpEndpoint != "" {
+		server.tracer = newTracer(*otlpEndpoint)
+		server.tracer.StartExporter(traceExportInterval)
+	}
+	if *statsdAddr != "" {
+		if server.statsd, err = newStatsd(*statsdAddr, *statsdPrefix); err != nil {
+			log.Fatalf("connecting to statsd: %v", err)
+		}
+		server.statsd.StartFlusher(statsdFlushInterval)
+	}
+	if *influxURL != "" {
+		server.influx = newInflux(*influxURL, *influxToken, *influxQueries)
+		server.influx.StartWriter(influxWriteInterval)
+	}
+	if *notifyURL != "" {
+		server.notifier = newNotifier(*notifyURL)
+		server.notifier.StartSender(notifyInterval)
+	}
+	if *corsOrigins != "" {
+		server.corsOrigins = strings.Split(*corsOrigins, ",")
+	}
+	if *indexes != "" {
+		for _, name := range strings.Split(*indexes, ",") {
+			if err := server.AddIndex(name); err != nil {
+				log.Fatalf("adding index %v: %v", name, err)
+			}
+		}
+	}
+	if *historyPath != "" {
+		server.history, err = openHistory(*historyPath)
+		if err != nil {
+			logWarn(nil, "opening history, not recording results: %v\n", err)
+		}
+	}
+	server.warmup, err = parseWarmup(*warmup)
+	if err != nil {
+		log.Fatalf("parsing --warmup: %v", err)
+	}
+	server.snapshots = snapshotter{*snapshotScript, *restoreScript}
+	server.audit = &auditLog{path: *auditLogPath}
+	server.adminToken = *adminToken
+	server.discovery = disc
+	server.translations, err = loadTranslations(*translationsDir)
+	if err != nil {
+		log.Fatalf("loading translations: %v", err)
+	}
+	var interrupted []Run
+	if *stateDir != "" {
+		interrupted, err = server.runs.Load(*stateDir)
+		if err != nil {
+			log.Fatalf("loading state: %v", err)
+		}
+		if err := server.adHoc.Load(filepath.Join(*stateDir, "adhoc")); err != nil {
+			log.Fatalf("loading ad-hoc query sets: %v", err)
+		}
+		for _, run := range interrupted {
+			logWarn(&run, "run %v (%v %v) was interrupted by a restart\n", run.ID, run.Type, run.Query)
+		}
+	}
+	if noise != nil {
+		logf(nil, "Noise: %v\n", noise)
+	}
+	if *stallTimeout > 0 {
+		server.StartWatchdog(*stallTimeout, *cancelStalled)
+	}
+	logf(nil, "Pilosa: %s, index: %s\n", *pilosaAddr, *index)
+	logf(nil, "lineorder count: %d\n", server.NumLineOrders())
+	if *initSchema {
+		if _, err := server.InitSchema(); err != nil {
+			log.Fatalf("initializing schema: %v", err)
+		}
+	}
+	if *generate != "" {
+		opts, err := parseGenerate(*generate)
+		if err != nil {
+			log.Fatalf("parsing --generate: %v", err)
+		}
+		if count := server.NumLineOrders(); count > 0 {
+			logf(nil, "index %v already has %d lineorders, not generating\n", *index, count)
+		} else {
+			if _, err := server.Generate(opts, 100000); err != nil {
+				log.Fatalf("generating data: %v", err)
+			}
+			count, err := server.RefreshLineOrderCount()
+			if err != nil {
+				log.Fatal(err)
+			}
+			logf(nil, "lineorder count: %d\n", count)
+		}
+	}
+	if *lineOrderRefresh > 0 {
+		server.StartLineOrderRefresh(*lineOrderRefresh)
+	}
+
+	if args := pflag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "buckets":
+			err = server.runBuckets(args[1:])
+		case "scenario":
+			err = server.runScenarioFile(args[1:])
+		case "attrs":
+			err = server.runAttrs(args[1:])
+		case "fuzz":
+			err = server.runFuzz(args[1:])
+		case "load":
+			err = server.runLoad(args[1:])
+		case "bench":
+			err = server.runBench(args[1:])
+		case "suite":
+			err = server.runSuiteCommand(args[1:])
+		case "snapshot", "restore":
+			err = server.runSnapshot(args[0], args[1:])
+		default:
+			err = fmt.Errorf("unknown command: %v", args[0])
+		}
+		server.tracer.Flush()
+		server.statsd.Flush()
+		server.influx.Flush()
+		server.notifier.Flush()
+		server.history.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := server.ValidateFrames(); err != nil {
+		logWarn(nil, "%v\nthe query sets needing them are unsupported until the frames are created\n", err)
+	}
+	if server.retention.keep > 0 || server.retention.maxAge > 0 {
+		server.StartResultsSweeper(*resultsSweep)
+	}
+	if *resume {
+		server.ResumeInterrupted(interrupted)
+	}
+	if _, err := server.Serve(*listen); err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(server.Wait())
 }
 
 type Server struct {
-	pilosaAddr    string
-	Router        *mux.Router
-	Client        *pilosa.Client
-	Index         *pilosa.Index
-	Frames        map[string]*pilosa.Frame
-	concurrency   int
-	batchSize     int
-	NumLineOrders uint64
+	conn         atomic.Value
+	Router       *mux.Router
+	Index        *pilosa.Index
+	Frames       map[string]*pilosa.Frame
+	concurrency  int
+	batchSize    int
+	valueFormat  ValueFormat
+	noise        *Noise
+	runs         *runStore
+	inflight     *inflightTracker
+	pipelines    *pipelineTracker
+	tracer       *tracer
+	statsd       *statsd
+	influx       *influx
+	notifier     *notifier
+	lineOrders   lineOrderCount
+	indexes      map[string]*demoIndex
+	rowAttrs     bool
+	labels       *labelCache
+	scaleFactor  float64
+	traceSlowest int
+	warmup       Warmup
+	resultWriter string
+	resultsDir   string
+	resultsGzip  bool
+	retention    resultsRetention
+	goldenDir    string
+	history      *history
+	snapshots    snapshotter
+	audit        *auditLog
+	discovery    *discovery
+	translations translations
+	serveErr     chan error
+	shardWidth   uint64
+	liveFrames   map[string]map[string]interface{}
+	fieldModel   bool
+	intersectReg bool
+	adapter      SchemaAdapter
+	limits       httpLimits
+	adHoc        adHocSets
+	retries      retryPolicy
+	corsOrigins  []string
+	static       http.FileSystem
+	poolSize     int
+	profiling    bool
+	adminToken   string
+
+	queryOverrides map[string]QueryOverride
+	// pilosaVersion is the version of Pilosa at startup.
+	pilosaVersion string
+	versions      versionCache
 }
 
+// NewServer returns a server for the index on the Pilosa cluster at
+// pilosaAddr, a comma-separated list of hosts.
 func NewServer(pilosaAddr, indexName string) (*Server, error) {
 	server := &Server{
 		Frames:      make(map[string]*pilosa.Frame),
+		runs:        newRunStore(),
+		inflight:    newInflightTracker(),
+		pipelines:   newPipelineTracker(),
+		labels:      newLabelCache(),
+		indexes:     make(map[string]*demoIndex),
 		concurrency: 1,
+		shardWidth:  defaultShardWidth,
+		limits:      defaultHTTPLimits,
+		retries:     defaultRetry,
 	}
 
 	router := mux.NewRouter()
 	router.HandleFunc("/version", server.HandleVersion).Methods("GET")
+	router.HandleFunc("/healthz", server.HandleHealth).Methods("GET")
+	router.HandleFunc("/readyz", server.HandleReady).Methods("GET")
+	router.HandleFunc("/debug/stats", server.HandleDebugStats).Methods("GET")
+	router.PathPrefix("/debug/pprof/").HandlerFunc(server.requireAdmin(server.HandlePprof)).Methods("GET", "POST")
+	router.HandleFunc("/openapi.json", server.HandleOpenAPI).Methods("GET")
+	router.HandleFunc("/query", server.HandleAdHocQuery).Methods("POST")
+	router.HandleFunc("/capabilities", server.HandleCapabilities).Methods("GET")
+	router.HandleFunc("/schema", server.HandleSchema).Methods("GET")
+	router.HandleFunc("/multi", server.HandleMulti).Methods("GET")
+	router.HandleFunc("/tenants", server.HandleTenants).Methods("GET")
+	router.HandleFunc("/scenario", server.HandleScenario).Methods("POST")
+	router.HandleFunc("/walkthrough", server.HandleWalkthrough).Methods("GET")
+	router.HandleFunc("/edgecases", server.HandleEdgeCases).Methods("GET")
+	router.HandleFunc("/materialize", server.HandleMaterialize).Methods("GET")
+	router.HandleFunc("/queries", server.HandleQueries).Methods("GET")
+	router.HandleFunc("/results", server.HandleResults).Methods("GET")
+	router.HandleFunc("/results/summary", server.HandleResultsSummary).Methods("GET")
+	router.HandleFunc("/results/files", server.HandleResultsFiles).Methods("GET")
+	router.HandleFunc("/results/{name}", server.HandleResultsFile).Methods("GET")
+	router.HandleFunc("/results/{name}", server.requireAdmin(server.HandleDeleteResults)).Methods("DELETE")
+	router.HandleFunc("/compare", server.HandleCompare).Methods("GET")
+	router.HandleFunc("/ws/jobs/{id}", server.HandleJobProgress).Methods("GET")
+	router.HandleFunc("/queries/diff", server.HandleQueryDiff).Methods("GET")
+	router.HandleFunc("/admin/snapshot", server.requireAdmin(server.HandleSnapshot)).Methods("POST")
+	router.HandleFunc("/admin/restore", server.requireAdmin(server.HandleRestore)).Methods("POST")
+	router.HandleFunc("/admin/audit", server.requireAdmin(server.HandleAudit)).Methods("GET")
+	router.HandleFunc("/builder/frames", server.HandleBuilderFrames).Methods("GET")
+	router.HandleFunc("/builder/validate", server.HandleBuilder).Methods("POST")
+	router.HandleFunc("/builder/estimate", server.HandleBuilder).Methods("POST")
+	router.HandleFunc("/builder/run", server.HandleBuilder).Methods("POST")
+	router.HandleFunc("/scorecard", server.HandleScorecard).Methods("GET")
+	router.HandleFunc("/suite", server.HandleSuite).Methods("GET")
+	router.HandleFunc("/batching", server.HandleBatching).Methods("GET")
+	router.HandleFunc("/hierarchy", server.HandleHierarchies).Methods("GET")
+	router.HandleFunc("/hierarchy/{hierarchy}/{level}/{id}", server.HandleHierarchyNode).Methods("GET")
+	router.HandleFunc("/runs/last/repeat", server.HandleRepeatLast).Methods("POST")
+	router.HandleFunc("/runs/compare", server.HandleCompare).Methods("GET")
+	router.HandleFunc("/runs/{id}", server.HandleRun).Methods("GET")
+	router.HandleFunc("/lineorders/refresh", server.HandleLineOrderRefresh).Methods("POST")
+	router.HandleFunc("/runs/{id}/results", server.HandleRunResults).Methods("GET")
+	router.HandleFunc("/runs/{id}/bundle", server.HandleBundle).Methods("GET")
+	router.HandleFunc("/consumption/{qname}", server.HandleConsumption).Methods("GET")
+	router.PathPrefix("/viewer/").HandlerFunc(server.HandleStatic).Methods("GET")
 	router.HandleFunc("/{qtype}/{qname}", server.HandleQuery).Methods("GET")
+	router.PathPrefix("/").HandlerFunc(server.HandleStatic).Methods("GET")
 
-	pilosaURI, err := pilosa.NewURIFromAddress(pilosaAddr)
-	if err != nil {
+	if err := server.setHosts(pilosaAddr); err != nil {
 		return nil, err
 	}
-	client := pilosa.NewClientWithURI(pilosaURI)
 	index, err := pilosa.NewIndex(indexName, nil)
 	if err != nil {
 		return nil, fmt.Errorf("pilosa.NewIndex: %v", err)
 	}
-	err = client.EnsureIndex(index)
+	err = server.pilosaClient().EnsureIndex(index)
 	if err != nil {
 		return nil, fmt.Errorf("client.EnsureIndex: %v", err)
 	}
 
-	// TODO should be automatic from /schema
-	frames := []string{
-		"lo_quantity", // these frames X each have one field, field_X
-		"lo_quantity_b",
-		"lo_extendedprice",
-		"lo_discount",
-		"lo_discount_b",
-		"lo_revenue",
-		"lo_supplycost",
-		"lo_profit",
-		"lo_revenue_computed",
-		"c_city",
-		"c_nation",
-		"c_region",
-		"s_city",
-		"s_nation",
-		"s_region",
-		"p_mfgr",
-		"p_category",
-		"p_brand1",
-		"lo_year",
-		"lo_month",
-		"lo_weeknum",
-	}
-
-	for _, frameName := range frames {
+	// Discover the frames in the index. The demo's own frames get handles even
+	// if they don't exist yet, e.g. for buckets rebuild to create them.
+	live, fieldModel, err := readPilosaSchema(server.pilosaAddr(), indexName)
+	if err != nil {
+		return nil, fmt.Errorf("fetching pilosa schema: %v", err)
+	}
+	names := append([]string{}, demoFrames...)
+	for name := range live {
+		names = append(names, name)
+	}
+	for _, frameName := range names {
 		frame, err := index.Frame(frameName, nil)
 		if err != nil {
 			return nil, fmt.Errorf("index.Frame %v: %v", frameName, err)
 		}
-		err = client.EnsureFrame(frame)
-		if err != nil {
-			return nil, fmt.Errorf("client.EnsureFrame %v: %v", frameName, err)
-		}
-
 		server.Frames[frameName] = frame
 	}
+	server.liveFrames = live
+	server.fieldModel = fieldModel
+
+	// The UI is served from the copy statik embedded, unless --static-dir
+	// overrides it.
+	server.static, err = fs.New()
+	if err != nil {
+		return nil, fmt.Errorf("opening the embedded UI: %v", err)
+	}
 
 	server.Router = router
-	server.Client = client
 	server.Index = index
-	server.NumLineOrders = server.getLineOrderCount()
+	server.indexes[indexName] = &demoIndex{index: index, lineOrders: &server.lineOrders}
+	if _, err := server.RefreshLineOrderCount(); err != nil {
+		logWarn(nil, "counting lineorders: %v; starting with a count of 0\n", err)
+	}
 	return server, nil
 }
 
-func (s *Server) getLineOrderCount() uint64 {
-	var count uint64 = 0
-	for n := 0; n < 5; n++ {
-		q := s.Index.Count(s.Frames["p_mfgr"].Bitmap(uint64(n)))
-		response, err := s.Client.Query(q, nil)
-		if err != nil {
-			fmt.Printf("in getLineOrderCount: %v\n", err)
-			return 666
-		}
-		count += response.Result().Count
-	}
-	return count
+// VersionInfo is the response of /version.
+type VersionInfo struct {
+	DemoVersion   string `json:"demoversion"`
+	PilosaVersion string `json:"pilosaversion"`
+	PilosaError   string `json:"pilosaerror,omitempty"`
 }
 
+// HandleVersion reports the demo's version and Pilosa's, or "unreachable"
+// and why if Pilosa can't be asked.
 func (s *Server) HandleVersion(w http.ResponseWriter, r *http.Request) {
-	if err := json.NewEncoder(w).Encode(struct {
-		DemoVersion   string `json:"demoversion"`
-		PilosaVersion string `json:"pilosaversion"`
-	}{
+	pilosaVersion, err := s.PilosaVersion()
+	var pilosaError string
+	if err != nil {
+		pilosaVersion, pilosaError = versionUnreachable, err.Error()
+	}
+	if err := json.NewEncoder(w).Encode(VersionInfo{
 		DemoVersion:   Version,
-		PilosaVersion: getPilosaVersion(s.pilosaAddr),
+		PilosaVersion: pilosaVersion,
+		PilosaError:   pilosaError,
 	}); err != nil {
-		log.Printf("write version response error: %s", err)
+		logError(nil, "write version response error: %s", err)
 	}
 }
 
+// versionUnreachable stands in for Pilosa's version when it can't be fetched.
+const versionUnreachable = "unreachable"
+
+// pilosaVersionTTL is how long a fetched Pilosa version is reused, so
+// /version and /capabilities don't ask Pilosa on every request but notice an
+// upgrade.
+const pilosaVersionTTL = time.Minute
+
+// versionCache holds the last Pilosa version fetched.
+type versionCache struct {
+	mu      sync.Mutex
+	version string
+	fetched time.Time
+}
+
+// PilosaVersion returns Pilosa's current version, fetching it if the cached
+// one is older than pilosaVersionTTL. Failures aren't cached. The cache isn't
+// locked during the fetch, so a slow Pilosa only delays the callers that
+// need a fresh version.
+func (s *Server) PilosaVersion() (string, error) {
+	c := &s.versions
+	c.mu.Lock()
+	version, fetched := c.version, c.fetched
+	c.mu.Unlock()
+	if version != "" && time.Since(fetched) < pilosaVersionTTL {
+		return version, nil
+	}
+	version, err := getPilosaVersion(s.pilosaAddr())
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.version, c.fetched = version, time.Now()
+	c.mu.Unlock()
+	return version, nil
+}
+
 type versionResponse struct {
 	Version string `json:"version"`
 }
 
-func getPilosaVersion(host string) string {
-	resp, _ := http.Get("http://" + host + "/version")
+// getPilosaVersion asks the Pilosa at host for its version.
+func getPilosaVersion(host string) (string, error) {
+	resp, err := pilosaHTTP.get(host, "/version")
+	if err != nil {
+		return "", err
+	}
 	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	version := new(versionResponse)
-	json.Unmarshal(body, &version)
-	return version.Version
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %v: %s", resp.Status, body)
+	}
+	var version versionResponse
+	if err := json.Unmarshal(body, &version); err != nil {
+		return "", fmt.Errorf("parsing version: %v", err)
+	}
+	return version.Version, nil
+}
+
+// defaultListen is the address the demo listens on without --listen or
+// DEMO_LISTEN.
+const defaultListen = ":8000"
+
+// Serve listens on addr and serves the demo in the background. It returns the
+// bound address, e.g. 127.0.0.1:41234 for ":0"; Wait returns when serving
+// stops.
+func (s *Server) Serve(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	bound := ln.Addr().String()
+	if host, port, err := net.SplitHostPort(bound); err == nil && net.ParseIP(host).IsUnspecified() {
+		bound = net.JoinHostPort("127.0.0.1", port)
+	}
+	logf(nil, "Demo running at http://%s\n", bound)
+	s.serveErr = make(chan error, 1)
+	go func() {
+		s.serveErr <- s.httpServer().Serve(newLimitListener(ln, s.limits.MaxConns))
+	}()
+	return bound, nil
 }
 
-func (s *Server) Serve() {
-	fmt.Println("Demo running at http://127.0.0.1:8000")
-	log.Fatal(http.ListenAndServe(":8000", s.Router))
+// Wait blocks until the server started by Serve stops, returning the reason.
+func (s *Server) Wait() error {
+	return <-s.serveErr
 }
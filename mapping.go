@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RowMapping owns the conversion between SSB dimension values and the rowIDs
+// the loader assigns them. Children are numbered consecutively under their
+// parent, so e.g. city rowID = nationID*CitiesPerNation + digit, and brand
+// MFGR#mcb has rowID ((m-1)*CategoriesPerMfgr + c-1)*BrandsPerCategory + b-1.
+// Query sets build their argsets through it rather than with literal ranges.
+type RowMapping struct {
+	Regions           []string `json:"regions"`
+	Nations           []string `json:"nations"`
+	NationsPerRegion  int      `json:"nationsperregion"`
+	CitiesPerNation   int      `json:"citiespernation"`
+	Mfgrs             int      `json:"mfgrs"`
+	CategoriesPerMfgr int      `json:"categoriespermfgr"`
+	BrandsPerCategory int      `json:"brandspercategory"`
+}
+
+// rowMap is the mapping used for query generation. It can be replaced by one
+// loaded from dimension files with --dimensions.
+var rowMap = defaultRowMapping()
+
+// defaultRowMapping returns the mapping of the standard SSB data set, with
+// region and nation rowIDs taken from the regions and nations tables.
+func defaultRowMapping() *RowMapping {
+	m := &RowMapping{
+		Regions:           make([]string, len(regions)),
+		Nations:           make([]string, len(nations)),
+		NationsPerRegion:  5,
+		CitiesPerNation:   10,
+		Mfgrs:             5,
+		CategoriesPerMfgr: 5,
+		BrandsPerCategory: 40,
+	}
+	for name, id := range regions {
+		m.Regions[id] = name
+	}
+	for name, id := range nations {
+		m.Nations[id] = name
+	}
+	return m
+}
+
+// indexOf returns the position of name in names, or -1.
+func indexOf(names []string, name string) int {
+	for n, s := range names {
+		if s == name {
+			return n
+		}
+	}
+	return -1
+}
+
+// Region returns the rowID of the named region, or -1 if it is unknown.
+func (m *RowMapping) Region(name string) int { return indexOf(m.Regions, name) }
+
+// Nation returns the rowID of the named nation, or -1 if it is unknown.
+func (m *RowMapping) Nation(name string) int { return indexOf(m.Nations, name) }
+
+// NationsOf returns the nation rowIDs in a region.
+func (m *RowMapping) NationsOf(region int) []int {
+	return arange(region*m.NationsPerRegion, (region+1)*m.NationsPerRegion, 1)
+}
+
+// RegionOf returns the region rowID of a nation.
+func (m *RowMapping) RegionOf(nation int) int { return nation / m.NationsPerRegion }
+
+// City returns the rowID of a nation's city with the given digit, e.g. digit 1
+// of UNITED KINGDOM is "UNITED KI1".
+func (m *RowMapping) City(nation, digit int) int { return nation*m.CitiesPerNation + digit }
+
+// CitiesOf returns the city rowIDs in a nation.
+func (m *RowMapping) CitiesOf(nation int) []int {
+	return arange(m.City(nation, 0), m.City(nation+1, 0), 1)
+}
+
+// NationOf returns the nation rowID of a city.
+func (m *RowMapping) NationOf(city int) int { return city / m.CitiesPerNation }
+
+// CityName returns the SSB name of a city: the nation name truncated or padded
+// to 9 characters, followed by the city digit.
+func (m *RowMapping) CityName(city int) string {
+	return fmt.Sprintf("%-9.9s%d", m.Nations[m.NationOf(city)], city%m.CitiesPerNation)
+}
+
+// Mfgr returns the rowID of MFGR#mfgr.
+func (m *RowMapping) Mfgr(mfgr int) int { return mfgr - 1 }
+
+// Category returns the rowID of category MFGR#{mfgr}{category}.
+func (m *RowMapping) Category(mfgr, category int) int {
+	return m.Mfgr(mfgr)*m.CategoriesPerMfgr + category - 1
+}
+
+// CategoriesOf returns the category rowIDs of MFGR#mfgr.
+func (m *RowMapping) CategoriesOf(mfgr int) []int {
+	return arange(m.Category(mfgr, 1), m.Category(mfgr+1, 1), 1)
+}
+
+// MfgrOf returns the mfgr rowID of a category rowID.
+func (m *RowMapping) MfgrOf(category int) int { return category / m.CategoriesPerMfgr }
+
+// Brand returns the rowID of brand MFGR#{mfgr}{category}{brand}.
+func (m *RowMapping) Brand(mfgr, category, brand int) int {
+	return m.Category(mfgr, category)*m.BrandsPerCategory + brand - 1
+}
+
+// BrandsOf returns the brand rowIDs of category MFGR#{mfgr}{category}.
+func (m *RowMapping) BrandsOf(mfgr, category int) []int {
+	return m.BrandRange(mfgr, category, 1, m.BrandsPerCategory)
+}
+
+// BrandRange returns the rowIDs of brands first through last, inclusive, of
+// category MFGR#{mfgr}{category}.
+func (m *RowMapping) BrandRange(mfgr, category, first, last int) []int {
+	return arange(m.Brand(mfgr, category, first), m.Brand(mfgr, category, last)+1, 1)
+}
+
+// CategoryOf returns the category rowID of a brand rowID.
+func (m *RowMapping) CategoryOf(brand int) int { return brand / m.BrandsPerCategory }
+
+// MfgrName, CategoryName and BrandName return the SSB names of part rowIDs.
+func (m *RowMapping) MfgrName(mfgr int) string { return fmt.Sprintf("MFGR#%d", mfgr+1) }
+
+func (m *RowMapping) CategoryName(category int) string {
+	return fmt.Sprintf("MFGR#%d%d", m.MfgrOf(category)+1, category%m.CategoriesPerMfgr+1)
+}
+
+func (m *RowMapping) BrandName(brand int) string {
+	category := m.CategoryOf(brand)
+	return fmt.Sprintf("%v%d", m.CategoryName(category), brand%m.BrandsPerCategory+1)
+}
+
+// LoadRowMapping reads the SSB dimension files customer.tbl, supplier.tbl and
+// part.tbl from dir, as written by dbgen, and returns a mapping sized to
+// them. Missing files are skipped. Regions and nations must be known and in
+// the region the default mapping assigns them, since their rowIDs can't be
+// derived from the files; anything else that doesn't fit the rowID arithmetic
+// is an error rather than a silently wrong argset.
+func LoadRowMapping(dir string) (*RowMapping, error) {
+	m := defaultRowMapping()
+	cities, categories, brands := 0, 0, 0
+	mfgrs := 0
+
+	geo := func(fields []string) error {
+		// city, nation and region are columns 3-5 of customer and supplier.
+		if len(fields) < 6 {
+			return fmt.Errorf("expected at least 6 columns, got %d", len(fields))
+		}
+		city, nation, region := fields[3], fields[4], fields[5]
+		r := m.Region(region)
+		if r < 0 {
+			return fmt.Errorf("unknown region %q", region)
+		}
+		n := m.Nation(nation)
+		if n < 0 {
+			return fmt.Errorf("unknown nation %q", nation)
+		}
+		if m.RegionOf(n) != r {
+			return fmt.Errorf("nation %v is in region %v, mapping puts it in %v", nation, region, m.Regions[m.RegionOf(n)])
+		}
+		if len(city) < 2 {
+			return fmt.Errorf("invalid city %q", city)
+		}
+		digit, err := strconv.Atoi(city[len(city)-1:])
+		if err != nil || !strings.HasPrefix(nation, strings.TrimRight(city[:len(city)-1], " ")) {
+			return fmt.Errorf("city %q doesn't belong to nation %v", city, nation)
+		}
+		if digit+1 > cities {
+			cities = digit + 1
+		}
+		return nil
+	}
+
+	part := func(fields []string) error {
+		// mfgr, category and brand are columns 2-4 of part.
+		if len(fields) < 5 {
+			return fmt.Errorf("expected at least 5 columns, got %d", len(fields))
+		}
+		mfgr, category, brand := fields[2], fields[3], fields[4]
+		if !strings.HasPrefix(category, mfgr) || !strings.HasPrefix(brand, category) {
+			return fmt.Errorf("brand %v, category %v and mfgr %v don't nest", brand, category, mfgr)
+		}
+		var nm, nc, nb int
+		if _, err := fmt.Sscanf(brand, "MFGR#%1d%1d%d", &nm, &nc, &nb); err != nil {
+			return fmt.Errorf("parsing brand %q: %v", brand, err)
+		}
+		if nm < 1 || nc < 1 || nb < 1 {
+			return fmt.Errorf("brand %q numbers must start at 1", brand)
+		}
+		if nm > mfgrs {
+			mfgrs = nm
+		}
+		if nc > categories {
+			categories = nc
+		}
+		if nb > brands {
+			brands = nb
+		}
+		return nil
+	}
+
+	for _, f := range []struct {
+		name  string
+		parse func([]string) error
+	}{
+		{"customer.tbl", geo},
+		{"supplier.tbl", geo},
+		{"part.tbl", part},
+	} {
+		err := readDimensionFile(filepath.Join(dir, f.name), f.parse)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cities > 0 {
+		m.CitiesPerNation = cities
+	}
+	if mfgrs > 0 {
+		m.Mfgrs, m.CategoriesPerMfgr, m.BrandsPerCategory = mfgrs, categories, brands
+	}
+	return m, nil
+}
+
+// readDimensionFile calls parse with the fields of each line of a
+// '|'-separated dbgen file.
+func readDimensionFile(path string, parse func([]string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := parse(strings.Split(scanner.Text(), "|")); err != nil {
+			return fmt.Errorf("%v:%d: %v", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %v: %v", path, err)
+	}
+	return nil
+}
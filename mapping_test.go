@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRowMappingCity(t *testing.T) {
+	m := defaultRowMapping()
+	for _, test := range []struct {
+		nation, digit int
+		city          int
+		name          string
+	}{
+		{0, 0, 0, "CANADA   0"},
+		{0, 9, 9, "CANADA   9"},
+		{18, 1, 181, "UNITED KI1"},
+		{24, 5, 245, "EGYPT    5"},
+	} {
+		city := m.City(test.nation, test.digit)
+		if city != test.city {
+			t.Errorf("City(%d, %d) = %d, want %d", test.nation, test.digit, city, test.city)
+		}
+		if nation := m.NationOf(city); nation != test.nation {
+			t.Errorf("NationOf(%d) = %d, want %d", city, nation, test.nation)
+		}
+		if name := m.CityName(city); name != test.name {
+			t.Errorf("CityName(%d) = %q, want %q", city, name, test.name)
+		}
+	}
+	if cities := m.CitiesOf(13); !reflect.DeepEqual(cities, arange(130, 140, 1)) {
+		t.Errorf("CitiesOf(13) = %v", cities)
+	}
+}
+
+func TestRowMappingBrand(t *testing.T) {
+	m := defaultRowMapping()
+	for _, test := range []struct {
+		mfgr, category, brand int
+		id                    int
+		name                  string
+	}{
+		{1, 1, 1, 0, "MFGR#111"},
+		{1, 1, 40, 39, "MFGR#1140"},
+		{1, 2, 1, 40, "MFGR#121"},
+		{2, 2, 21, 260, "MFGR#2221"},
+		{5, 5, 40, 999, "MFGR#5540"},
+	} {
+		id := m.Brand(test.mfgr, test.category, test.brand)
+		if id != test.id {
+			t.Errorf("Brand(%d, %d, %d) = %d, want %d", test.mfgr, test.category, test.brand, id, test.id)
+		}
+		if name := m.BrandName(id); name != test.name {
+			t.Errorf("BrandName(%d) = %q, want %q", id, name, test.name)
+		}
+		if category := m.CategoryOf(id); category != m.Category(test.mfgr, test.category) {
+			t.Errorf("CategoryOf(%d) = %d, want %d", id, category, m.Category(test.mfgr, test.category))
+		}
+	}
+	if brands := m.BrandRange(2, 2, 21, 28); !reflect.DeepEqual(brands, arange(260, 268, 1)) {
+		t.Errorf("BrandRange(2, 2, 21, 28) = %v", brands)
+	}
+}
+
+func TestRowMappingCategoriesOf(t *testing.T) {
+	for _, test := range []struct {
+		perMfgr, mfgr int
+		categories    []int
+	}{
+		{5, 1, []int{0, 1, 2, 3, 4}},
+		{5, 2, []int{5, 6, 7, 8, 9}},
+		{5, 5, []int{20, 21, 22, 23, 24}},
+		{3, 2, []int{3, 4, 5}},
+	} {
+		m := defaultRowMapping()
+		m.CategoriesPerMfgr = test.perMfgr
+		categories := m.CategoriesOf(test.mfgr)
+		if !reflect.DeepEqual(categories, test.categories) {
+			t.Errorf("CategoriesOf(%d) with %d per mfgr = %v, want %v", test.mfgr, test.perMfgr, categories, test.categories)
+		}
+		for _, c := range categories {
+			if mfgr := m.MfgrOf(c); mfgr != m.Mfgr(test.mfgr) {
+				t.Errorf("MfgrOf(%d) = %d, want %d", c, mfgr, m.Mfgr(test.mfgr))
+			}
+		}
+	}
+}
+
+func TestLoadRowMapping(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		files   map[string]string
+		want    func(m *RowMapping)
+		wantErr string
+	}{
+		{
+			name:  "no files",
+			files: map[string]string{},
+			want:  func(m *RowMapping) {},
+		},
+		{
+			name: "smaller data set",
+			files: map[string]string{
+				"customer.tbl": "1|Customer#1|addr|UNITED KI1|UNITED KINGDOM|EUROPE|phone|\n" +
+					"2|Customer#2|addr|CANADA   5|CANADA|AMERICA|phone|\n",
+				"supplier.tbl": "1|Supplier#1|addr|CHINA    3|CHINA|ASIA|phone|\n",
+				"part.tbl": "1|lace|MFGR#1|MFGR#11|MFGR#1120|red|\n" +
+					"2|lace|MFGR#3|MFGR#32|MFGR#323|red|\n",
+			},
+			want: func(m *RowMapping) {
+				m.CitiesPerNation = 6
+				m.Mfgrs, m.CategoriesPerMfgr, m.BrandsPerCategory = 3, 2, 20
+			},
+		},
+		{
+			name:    "unknown nation",
+			files:   map[string]string{"customer.tbl": "1|Customer#1|addr|ATLANTIS 1|ATLANTIS|EUROPE|phone|\n"},
+			wantErr: `unknown nation "ATLANTIS"`,
+		},
+		{
+			name:    "nation in the wrong region",
+			files:   map[string]string{"supplier.tbl": "1|Supplier#1|addr|CHINA    3|CHINA|EUROPE|phone|\n"},
+			wantErr: "nation CHINA is in region EUROPE, mapping puts it in ASIA",
+		},
+		{
+			name:    "city of another nation",
+			files:   map[string]string{"customer.tbl": "1|Customer#1|addr|FRANCE   1|GERMANY|EUROPE|phone|\n"},
+			wantErr: `city "FRANCE   1" doesn't belong to nation GERMANY`,
+		},
+		{
+			name:    "brands that don't nest",
+			files:   map[string]string{"part.tbl": "1|lace|MFGR#1|MFGR#21|MFGR#211|red|\n"},
+			wantErr: "don't nest",
+		},
+		{
+			name:    "short line",
+			files:   map[string]string{"part.tbl": "1|lace|MFGR#1|\n"},
+			wantErr: "part.tbl:1: expected at least 5 columns, got 4",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mapping")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range test.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m, err := LoadRowMapping(dir)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := defaultRowMapping()
+			test.want(want)
+			if !reflect.DeepEqual(m, want) {
+				t.Errorf("got %+v, want %+v", m, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// materializedIDBase is the id of the first materialized region×mfgr bitmap.
+// Region r and mfgr m are stored under materializedIDBase + r*Mfgrs + m, so
+// every pair has its own id however many regions and mfgrs the mapping has,
+// above the ids the catalog's register query sets store.
+const materializedIDBase = 1000
+
+// regionMfgrID returns the id the region×mfgr bitmap is stored under.
+func regionMfgrID(region, mfgr int) int {
+	return materializedIDBase + region*rowMap.Mfgrs + mfgr
+}
+
+// MaterializeResult compares a query set computing region×mfgr filters inline
+// with the same queries loading precomputed bitmaps.
+type MaterializeResult struct {
+	Inline       BenchmarkResult `json:"inline"`
+	Materialized BenchmarkResult `json:"materialized"`
+	StoreSeconds float64         `json:"storeseconds"`
+	Speedup      float64         `json:"speedup"`
+	BreakEven    float64         `json:"breakeven"`
+}
+
+// regionMfgrQuerySets returns the inline and materialized variants of a revenue
+// query filtered by supplier region, manufacturer and year. The materialized
+// variant iterates over the ids of the stored region×mfgr bitmaps instead of
+// regions and mfgrs, in the same order.
+func regionMfgrQuerySets() (inline, materialized QuerySet) {
+	regions := arange(0, len(rowMap.Regions), 1)
+	mfgrs := arange(0, rowMap.Mfgrs, 1)
+	years := arange(1992, 1999, 1)
+
+	var ids []int
+	var store, purge []string
+	for _, region := range regions {
+		for _, mfgr := range mfgrs {
+			id := regionMfgrID(region, mfgr)
+			ids = append(ids, id)
+			store = append(store, fmt.Sprintf(`Store(Intersect(Bitmap(frame="s_region", rowID=%d), Bitmap(frame="p_mfgr", rowID=%d)), id=%d)`, region, mfgr, id))
+			purge = append(purge, fmt.Sprintf(`Purge(id=%d)`, id))
+		}
+	}
+
+	inline = NewRegisterQuerySet(
+		"regionmfgr",
+		`Sum(
+	Intersect(
+		Bitmap(frame="s_region", rowID={{region}}),
+		Bitmap(frame="p_mfgr", rowID={{mfgr}}),
+		Bitmap(frame="lo_year", rowID={{lo_year}}),
+	),
+	frame="lo_revenue", field="lo_revenue")`,
+		nil, nil, []string{"region", "mfgr", "lo_year"}, [][]int{regions, mfgrs, years},
+	)
+	materialized = NewRegisterQuerySet(
+		"regionmfgr-m",
+		`Sum(
+	Intersect(
+		Load(id={{regionmfgr}}),
+		Bitmap(frame="lo_year", rowID={{lo_year}}),
+	),
+	frame="lo_revenue", field="lo_revenue")`,
+		store, purge, []string{"regionmfgr", "lo_year"}, [][]int{ids, years},
+	)
+	return inline, materialized
+}
+
+// RunMaterializeExperiment stores every region×mfgr intersection, then runs the
+// inline and materialized query variants and reports the benefit. BreakEven is
+// the number of runs of the query set after which storing pays for itself.
+func (s *Server) RunMaterializeExperiment(concurrency, batchSize int) (MaterializeResult, error) {
+	var res MaterializeResult
+	inline, materialized := regionMfgrQuerySets()
+
+	start := time.Now()
+	if _, err := s.query(s.Index.RawQuery(s.pql(materialized.SetupQuery()))); err != nil {
+		return res, fmt.Errorf("storing bitmaps: %v", err)
+	}
+	res.StoreSeconds = time.Now().Sub(start).Seconds()
+	defer func() {
+		if _, err := s.query(s.Index.RawQuery(s.pql(materialized.TeardownQuery()))); err != nil {
+			logWarn(nil, "purging bitmaps: %v\n", err)
+		}
+	}()
+
+	// The bitmaps are already stored, so run the materialized variant without
+	// its own setup and teardown.
+	loadOnly := materialized
+	loadOnly.setup, loadOnly.teardown = nil, nil
+
+	res.Inline = s.RunSumMultiBatch(inline, concurrency, batchSize)
+	res.Materialized = s.RunSumMultiBatch(loadOnly, concurrency, batchSize)
+	if res.Materialized.Seconds > 0 {
+		res.Speedup = res.Inline.Seconds / res.Materialized.Seconds
+	}
+	if saved := res.Inline.Seconds - res.Materialized.Seconds; saved > 0 {
+		res.BreakEven = res.StoreSeconds / saved
+	}
+	return res, nil
+}
+
+// HandleMaterialize runs the materialization experiment.
+func (s *Server) HandleMaterialize(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.Path)
+	res, err := s.RunMaterializeExperiment(s.concurrency, s.batchSize)
+	if err != nil {
+		logError(nil, "running materialize experiment: %v\n", err)
+		writeError(w, pilosaError(err))
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(res)
+	if err != nil {
+		logError(nil, "writing results: %v to responsewriter: %v", res, err)
+	}
+}
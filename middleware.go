@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// statusRecorder records the status of a response, passing flushes and
+// hijacks through for streamed results and WebSockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response can't be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// recordStatus wraps w in a statusRecorder, unless it already is one.
+func recordStatus(w http.ResponseWriter) *statusRecorder {
+	if rec, ok := w.(*statusRecorder); ok {
+		return rec
+	}
+	return &statusRecorder{ResponseWriter: w}
+}
+
+// logRequests logs the method, path, status and duration of each request,
+// with the request and run IDs of the run it started, if any. Health probes
+// are only logged at debug level, since they arrive every few seconds.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := recordStatus(w)
+		next.ServeHTTP(rec, r)
+		var run *Run
+		if id := rec.Header().Get("X-Request-ID"); id != "" {
+			run = &Run{ID: rec.Header().Get("X-Run-ID"), RequestID: id}
+		}
+		logAt := logf
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			logAt = logDebug
+		}
+		logAt(run, "%v %v %d %v\n", r.Method, r.URL.RequestURI(), rec.status, time.Since(start))
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500, logging the panic and
+// its stack, so one bad request doesn't take the demo down. If the handler
+// had already started its response, the client gets it cut short instead.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := recordStatus(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			logError(nil, "panic handling %v %v: %v\n%s", r.Method, r.URL.RequestURI(), p, debug.Stack())
+			if rec.status == 0 {
+				writeError(rec, newAPIError(http.StatusInternalServerError, errInternal, "internal error: %v", p))
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// gzipWriter compresses a response once its headers show it can be: it has a
+// body of a known type that isn't already compressed. Flushes flush the
+// compressor too, so streamed results still arrive as they are produced.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Type") != "" && h.Get("Content-Type") != "application/gzip" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close finishes the compressed stream, if any.
+func (g *gzipWriter) close() {
+	if g.gz != nil {
+		if err := g.gz.Close(); err != nil {
+			logDebug(nil, "closing gzip response: %v\n", err)
+		}
+	}
+}
+
+// gzipResponses compresses responses for clients that accept gzip, e.g. large
+// detail=inline results. WebSocket upgrades pass through untouched.
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		g := &gzipWriter{ResponseWriter: w}
+		defer g.close()
+		next.ServeHTTP(g, r)
+	})
+}
+
+// acceptsGzip reports whether the client accepts gzip content coding.
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding = strings.TrimSpace(coding)
+		if coding == "gzip" || strings.HasPrefix(coding, "gzip;") && !strings.HasSuffix(strings.Replace(coding, " ", "", -1), "q=0") {
+			return true
+		}
+	}
+	return false
+}
+
+// cors lets pages from the given origins call the API from the browser, e.g.
+// the demo UI served from a CDN or a local dev server. "*" allows any origin.
+// Preflight requests are answered here, since routes only accept their own
+// methods.
+func cors(next http.Handler, origins []string) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || (indexOf(origins, origin) < 0 && indexOf(origins, "*") < 0) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "X-Request-ID, X-Run-ID")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MultiSpec describes one query set taking part in a multi-set run, along with
+// the workers allocated to it.
+type MultiSpec struct {
+	Name        string
+	Concurrency int
+	BatchSize   int
+}
+
+// MultiResult holds the per-set results of a multi-set run, plus totals across
+// all sets.
+type MultiResult struct {
+	Sets        []BenchmarkResult `json:"sets"`
+	Iterations  int               `json:"iterations"`
+	Concurrency int               `json:"concurrency"`
+	Seconds     float64           `json:"seconds"`
+	QPS         float64           `json:"qps"`
+}
+
+// parseMultiSpec parses a spec of the form "name[:concurrency[:batchsize]]",
+// falling back to the given defaults for omitted values. Concurrency and batch
+// size are bounded as for ad-hoc query sets.
+func parseMultiSpec(spec string, concurrency, batchSize int) (MultiSpec, error) {
+	parts := strings.Split(spec, ":")
+	ms := MultiSpec{parts[0], concurrency, batchSize}
+	if len(parts) > 3 || ms.Name == "" {
+		return ms, fmt.Errorf("invalid spec: %q", spec)
+	}
+	var err error
+	if len(parts) > 1 {
+		if ms.Concurrency, err = strconv.Atoi(parts[1]); err != nil || ms.Concurrency < 1 || ms.Concurrency > maxAdHocConcurrency {
+			return ms, fmt.Errorf("invalid concurrency in spec: %q, want 1 to %d", spec, maxAdHocConcurrency)
+		}
+	}
+	if len(parts) > 2 {
+		if ms.BatchSize, err = strconv.Atoi(parts[2]); err != nil || ms.BatchSize < 1 || ms.BatchSize > maxAdHocBatchSize {
+			return ms, fmt.Errorf("invalid batch size in spec: %q, want 1 to %d", spec, maxAdHocBatchSize)
+		}
+	}
+	return ms, nil
+}
+
+// checkMultiSpecs returns an error if specs ask for more than
+// maxMultiConcurrency workers in all.
+func checkMultiSpecs(specs []MultiSpec) error {
+	total := 0
+	for _, ms := range specs {
+		total += ms.Concurrency
+	}
+	if total > maxMultiConcurrency {
+		return fmt.Errorf("%d workers in all, want up to %d", total, maxMultiConcurrency)
+	}
+	return nil
+}
+
+// RunMulti runs several query sets simultaneously, each with its own worker
+// allocation, modeling multiple analysts hitting the cluster at once.
+func (s *Server) RunMulti(specs []MultiSpec) MultiResult {
+	res := MultiResult{Sets: make([]BenchmarkResult, len(specs))}
+
+	start := time.Now()
+	var wg = &sync.WaitGroup{}
+	for n, spec := range specs {
+		wg.Add(1)
+		go func(n int, spec MultiSpec) {
+			defer wg.Done()
+			res.Sets[n] = s.RunSumMultiBatch(getQuerySet(spec.Name), spec.Concurrency, spec.BatchSize)
+		}(n, spec)
+	}
+	wg.Wait()
+	res.Seconds = time.Now().Sub(start).Seconds()
+
+	for _, br := range res.Sets {
+		res.Iterations += br.Iterations
+		res.Concurrency += br.Concurrency
+	}
+	if res.Seconds > 0 {
+		res.QPS = float64(res.Iterations) / res.Seconds
+	}
+	return res
+}
+
+// HandleMulti runs the query sets listed in the "sets" parameter concurrently,
+// e.g. /multi?sets=1.1:8:2,3.1:16:4
+func (s *Server) HandleMulti(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.String())
+	var specs []MultiSpec
+	for _, spec := range strings.Split(r.URL.Query().Get("sets"), ",") {
+		if spec == "" {
+			continue
+		}
+		ms, err := parseMultiSpec(spec, s.concurrency, s.batchSize)
+		if err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "%v", err))
+			return
+		}
+		if getQuerySet(ms.Name).Name == "" {
+			writeError(w, newAPIError(http.StatusBadRequest, errUnknownQuery, "unknown query set %q; see /queries", ms.Name))
+			return
+		}
+		specs = append(specs, ms)
+	}
+	if len(specs) == 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "no query sets given"))
+		return
+	}
+	if err := checkMultiSpecs(specs); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "%v", err))
+		return
+	}
+
+	res := s.RunMulti(specs)
+	enc := json.NewEncoder(w)
+	err := enc.Encode(res)
+	if err != nil {
+		logError(nil, "writing results: %v to responsewriter: %v", res, err)
+	}
+}
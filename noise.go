@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Noise perturbs returned sums for public demos backed by sensitive data, so
+// exact figures are never exposed. Jitter scales each value by a factor within
+// ±Jitter percent, then Round rounds it to the nearest multiple.
+//
+// The factor is derived from an HMAC of the value itself, and its kind, with a
+// secret, not drawn afresh or from the query. Every query with the same answer,
+// asked again or rewritten into an equivalent one, e.g. a Range with wider
+// bounds than the data, returns the same noisy value, so averaging answers
+// can't strip the jitter, and without the secret the factor can't be
+// predicted. Values are rounded before they are jittered as well as after, so
+// the factor isn't applied to the exact value.
+type Noise struct {
+	Round  int64
+	Jitter float64
+
+	secret []byte
+}
+
+// NewNoise returns a Noise, or nil if neither rounding nor jitter is enabled.
+func NewNoise(round int64, jitter float64, secret string) (*Noise, error) {
+	if round < 0 {
+		return nil, fmt.Errorf("invalid rounding: %d", round)
+	}
+	if jitter < 0 || jitter >= 100 {
+		return nil, fmt.Errorf("invalid jitter percentage: %v", jitter)
+	}
+	if round <= 1 && jitter == 0 {
+		return nil, nil
+	}
+	if jitter > 0 && secret == "" {
+		return nil, fmt.Errorf("jitter needs a secret")
+	}
+	return &Noise{Round: round, Jitter: jitter, secret: []byte(secret)}, nil
+}
+
+// Apply returns v, an answer of the given kind, with noise added. A nil Noise
+// returns v unchanged.
+func (n *Noise) Apply(kind ResultKind, v int64) int64 {
+	if n == nil {
+		return v
+	}
+	v = n.round(v)
+	if n.Jitter > 0 {
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], uint64(v))
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write([]byte(kind))
+		mac.Write(key[:])
+		u := float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11) / (1 << 53)
+		f := 1 + n.Jitter/100*(2*u-1)
+		v = n.round(int64(math.Round(float64(v) * f)))
+	}
+	return v
+}
+
+// round rounds v to the nearest multiple of n.Round.
+func (n *Noise) round(v int64) int64 {
+	if n.Round <= 1 {
+		return v
+	}
+	return int64(math.Round(float64(v)/float64(n.Round))) * n.Round
+}
+
+// String labels the noise applied to results, or returns "" if none is.
+func (n *Noise) String() string {
+	if n == nil {
+		return ""
+	}
+	var parts []string
+	if n.Jitter > 0 {
+		parts = append(parts, fmt.Sprintf("jittered by up to ±%v%%", n.Jitter))
+	}
+	if n.Round > 1 {
+		parts = append(parts, fmt.Sprintf("rounded to the nearest %d", n.Round))
+	}
+	return "values are approximate: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestNoiseEquivalentQueries(t *testing.T) {
+	n, err := NewNoise(1, 5, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Quantities run from 1 to 50, so every one of these selects the same
+	// columns and Pilosa returns the same sum for each.
+	const sum = 1234567890
+	answers := map[string]int64{
+		`Sum(Intersect(Bitmap(frame="lo_year", rowID=1993), Range(frame="lo_quantity", lo_quantity >< [1,50])), frame="lo_revenue", field="lo_revenue")`: sum,
+		`Sum(Intersect(Bitmap(frame="lo_year", rowID=1993), Range(frame="lo_quantity", lo_quantity >< [0,51])), frame="lo_revenue", field="lo_revenue")`: sum,
+		`Sum(Intersect(Range(frame="lo_quantity", lo_quantity >< [1,60]), Bitmap(frame="lo_year", rowID=1993)), frame="lo_revenue", field="lo_revenue")`: sum,
+	}
+	want := n.Apply(ResultSum, sum)
+	for pql, v := range answers {
+		if got := n.Apply(ResultSum, v); got != want {
+			t.Errorf("%v: got %d, want %d like every equivalent query", pql, got, want)
+		}
+	}
+	if want < sum*95/100 || want > sum*105/100 {
+		t.Errorf("jittered %d to %d, want within 5%%", int64(sum), want)
+	}
+	if n.Apply(ResultSum, sum+1) == want && n.Apply(ResultSum, sum+2) == want {
+		t.Errorf("neighbouring sums all got %d, want independent jitter", want)
+	}
+}
+
+func TestNoiseRound(t *testing.T) {
+	n, err := NewNoise(1000, 10, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values within the same rounding step are jittered alike.
+	if a, b := n.Apply(ResultCount, 1000200), n.Apply(ResultCount, 999900); a != b {
+		t.Errorf("values rounding to 1000000 got %d and %d", a, b)
+	}
+	if v := n.Apply(ResultCount, 123456); v%1000 != 0 {
+		t.Errorf("got %d, want a multiple of 1000", v)
+	}
+}
+
+func TestNoiseNil(t *testing.T) {
+	var n *Noise
+	if got := n.Apply(ResultSum, 123); got != 123 {
+		t.Errorf("nil Noise returned %d, want 123", got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// notifyInterval is how often queued notifications are sent, and
+// maxQueuedNotifications how many may wait between sends before new ones are
+// dropped.
+const (
+	notifyInterval         = time.Second
+	maxQueuedNotifications = 100
+)
+
+// Notification is the JSON posted to --notify-url when a run finishes, or a
+// benchmark request fails before its run starts. Text summarizes it in a line,
+// which is all a Slack incoming webhook shows. Status is done, failed if any
+// query set failed, cancelled, or rejected if the run never started, with
+// Error saying why.
+type Notification struct {
+	Text      string               `json:"text"`
+	Run       string               `json:"run"`
+	RequestID string               `json:"requestid"`
+	Type      string               `json:"type"`
+	Query     string               `json:"query"`
+	Index     string               `json:"index,omitempty"`
+	Status    string               `json:"status"`
+	Started   time.Time            `json:"started"`
+	Finished  time.Time            `json:"finished"`
+	Seconds   float64              `json:"seconds"`
+	Results   []NotificationResult `json:"results"`
+	Failed    []string             `json:"failed,omitempty"`
+	Error     *APIError            `json:"error,omitempty"`
+}
+
+// NotificationResult is the gist of one BenchmarkResult of a Notification.
+type NotificationResult struct {
+	Name        string    `json:"name"`
+	Concurrency int       `json:"concurrency"`
+	BatchSize   int       `json:"batchsize"`
+	Seconds     float64   `json:"seconds"`
+	QPS         float64   `json:"qps"`
+	Error       *APIError `json:"error,omitempty"`
+}
+
+// newNotification summarizes a finished run.
+func newNotification(run Run) Notification {
+	n := Notification{
+		Run:       run.ID,
+		RequestID: run.RequestID,
+		Type:      run.Type,
+		Query:     run.Query,
+		Index:     run.Index,
+		Status:    run.Status,
+		Started:   run.Started.UTC(),
+		Results:   []NotificationResult{},
+	}
+	if run.Finished != nil {
+		n.Finished = run.Finished.UTC()
+		n.Seconds = run.Finished.Sub(run.Started).Seconds()
+	}
+	for _, br := range run.Results {
+		n.Results = append(n.Results, NotificationResult{br.Name, br.Concurrency, br.BatchSize, br.Seconds, br.QPS, br.Error})
+		if br.Error != nil {
+			n.Failed = append(n.Failed, br.Name)
+		}
+	}
+	if len(n.Failed) > 0 && n.Status == "done" {
+		n.Status = "failed"
+	}
+	n.Text = fmt.Sprintf("%v run %v of %v %v after %.1fs", run.Type, run.ID, run.Query, n.Status, n.Seconds)
+	switch {
+	case len(n.Failed) > 0:
+		n.Text += fmt.Sprintf(": %d of %d failed, %v", len(n.Failed), len(n.Results), n.Failed)
+	case len(n.Results) == 1:
+		n.Text += fmt.Sprintf(": %.1f qps", n.Results[0].QPS)
+	case len(n.Results) > 1:
+		n.Text += fmt.Sprintf(": %d results", len(n.Results))
+	}
+	return n
+}
+
+// newRejection describes a benchmark request of the given type and query set
+// that failed with err before its run started.
+func newRejection(typ, query, requestID string, err *APIError) Notification {
+	now := time.Now().UTC()
+	return Notification{
+		Text:      fmt.Sprintf("%v run of %v rejected: %v", typ, query, err.Message),
+		RequestID: requestID,
+		Type:      typ,
+		Query:     query,
+		Status:    "rejected",
+		Started:   now,
+		Finished:  now,
+		Results:   []NotificationResult{},
+		Error:     err,
+	}
+}
+
+// notifier posts a Notification to a webhook, e.g. Slack's or a CI system's,
+// when a run finishes or fails. Notifications are queued and posted in the
+// background, so a slow webhook never holds up a response. A nil notifier
+// posts nothing.
+type notifier struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []Notification
+	dropped int
+
+	// sending is held while queued notifications are posted, so Flush
+	// returns only once they're all out.
+	sending sync.Mutex
+}
+
+func newNotifier(url string) *notifier {
+	return &notifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify queues the Notification of a finished run.
+func (nt *notifier) Notify(run Run) {
+	nt.enqueue(newNotification(run))
+}
+
+// Reject queues the Notification of a benchmark request that failed with err
+// before its run started.
+func (nt *notifier) Reject(typ, query, requestID string, err *APIError) {
+	nt.enqueue(newRejection(typ, query, requestID, err))
+}
+
+func (nt *notifier) enqueue(n Notification) {
+	if nt == nil {
+		return
+	}
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	if len(nt.queue) >= maxQueuedNotifications {
+		nt.dropped++
+		return
+	}
+	nt.queue = append(nt.queue, n)
+}
+
+// StartSender posts queued notifications every interval.
+func (nt *notifier) StartSender(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			nt.Flush()
+		}
+	}()
+}
+
+// Flush posts the queued notifications, logging failures rather than
+// retrying.
+func (nt *notifier) Flush() {
+	if nt == nil {
+		return
+	}
+	nt.sending.Lock()
+	defer nt.sending.Unlock()
+	nt.mu.Lock()
+	queue, dropped := nt.queue, nt.dropped
+	nt.queue, nt.dropped = nil, 0
+	nt.mu.Unlock()
+	if dropped > 0 {
+		logWarn(nil, "dropped %d notifications: more than %d queued between sends\n", dropped, maxQueuedNotifications)
+	}
+	for _, n := range queue {
+		if err := nt.post(n); err != nil {
+			logWarn(nil, "notifying %v of %v: %v\n", nt.url, n.Text, err)
+		}
+	}
+}
+
+func (nt *notifier) post(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := nt.client.Post(nt.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
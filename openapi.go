@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openAPISchemas builds OpenAPI schemas for Go types from their JSON
+// encoding, so the document can't drift from what the handlers send. Named
+// struct types become components, referenced by name.
+type openAPISchemas map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of t, adding components for its struct types.
+func (c openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return c.schema(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": c.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": c.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return c.object(t)
+		}
+		if _, ok := c[t.Name()]; !ok {
+			c[t.Name()] = nil // placeholder, for recursive types
+			c[t.Name()] = c.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// interface{}: any value.
+	return map[string]interface{}{}
+}
+
+// object returns the schema of a struct, with its JSON fields as properties.
+// Fields without omitempty are required, and embedded structs' fields are
+// inlined, as encoding/json does.
+func (c openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for n := 0; n < t.NumField(); n++ {
+			f := t.Field(n)
+			tag := f.Tag.Get("json")
+			if f.Anonymous && tag == "" {
+				add(f.Type)
+				continue
+			}
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+			name, opts := f.Name, ""
+			if tag != "" {
+				parts := strings.SplitN(tag, ",", 2)
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				if len(parts) > 1 {
+					opts = parts[1]
+				}
+			}
+			properties[name] = c.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	add(t)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIParam describes a query or path parameter.
+type openAPIParam struct {
+	Name        string
+	In          string
+	Description string
+	Enum        []string
+	Integer     bool
+}
+
+func (p openAPIParam) spec() map[string]interface{} {
+	schema := map[string]interface{}{"type": "string"}
+	if p.Integer {
+		schema["type"] = "integer"
+	}
+	if p.Enum != nil {
+		schema["enum"] = p.Enum
+	}
+	return map[string]interface{}{"name": p.Name, "in": p.In, "required": p.In == "path", "description": p.Description, "schema": schema}
+}
+
+// benchmarkParams are the query parameters of the benchmark endpoints.
+var benchmarkParams = []openAPIParam{
+	{Name: "qname", In: "path", Description: "query set name; see /queries"},
+	{Name: "detail", In: "query", Description: "summary returns results, inline embeds per-query records, full streams them as NDJSON", Enum: []string{"summary", "inline", "full"}},
+	{Name: "warmup", In: "query", Description: "queries to run untimed first: a count, e.g. 500, or a duration, e.g. 10s"},
+	{Name: "repeats", In: "query", Description: "run each configuration this many times, returning RepeatedResults", Integer: true},
+	{Name: "sort", In: "query", Description: "order of written results, e.g. outputs"},
+	{Name: "verify", In: "query", Description: "check sums against golden answers, or record them", Enum: []string{"false", "true", "record"}},
+	{Name: "index", In: "query", Description: "one of the indexes given with --indexes"},
+	{Name: "sample", In: "query", Description: "run this many of the query set's queries, chosen at random", Integer: true},
+	{Name: "shuffle", In: "query", Description: "run the queries in a random order rather than cartesian order", Enum: []string{"false", "true"}},
+	{Name: "seed", In: "query", Description: "seed for sample and shuffle, to choose the same queries and order again", Integer: true},
+}
+
+// openAPIDocument returns the OpenAPI 3 document describing the demo's API.
+func openAPIDocument() map[string]interface{} {
+	schemas := make(openAPISchemas)
+	response := func(description string, t reflect.Type) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schema(t)}},
+		}
+	}
+	errorResponse := response("error", reflect.TypeOf(struct {
+		Error APIError `json:"error"`
+	}{}))
+	params := make([]interface{}, len(benchmarkParams))
+	for n, p := range benchmarkParams {
+		params[n] = p.spec()
+	}
+	results := map[string]interface{}{
+		"description": "results of the benchmark: BenchmarkResults, DetailedResults with detail=inline, or RepeatedResults with repeats",
+		"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"oneOf": []interface{}{
+			schemas.schema(reflect.TypeOf([]BenchmarkResult{})),
+			schemas.schema(reflect.TypeOf([]DetailedResult{})),
+			schemas.schema(reflect.TypeOf([]RepeatedResult{})),
+		}}}},
+	}
+	benchmark := func(summary string, ok map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"get": map[string]interface{}{
+			"summary":    summary,
+			"parameters": params,
+			"responses":  map[string]interface{}{"200": ok, "400": errorResponse, "404": errorResponse, "501": errorResponse, "502": errorResponse, "504": errorResponse},
+		}}
+	}
+	paths := map[string]interface{}{
+		"/version": map[string]interface{}{"get": map[string]interface{}{
+			"summary":   "versions of the demo and of Pilosa",
+			"responses": map[string]interface{}{"200": response("versions", reflect.TypeOf(VersionInfo{}))},
+		}},
+		"/query/{qname}":   benchmark("run a query set once for each argset combination", results),
+		"/grid/{qname}":    benchmark("run a query set over the grid of concurrencies and batch sizes", results),
+		"/grouped/{qname}": benchmark("run a query set, returning its outputs as a GROUP BY table", response("grouped results", reflect.TypeOf([]GroupedResult{}))),
+	}
+	return map[string]interface{}{
+		"openapi":    "3.0.0",
+		"info":       map[string]interface{}{"title": "SSB demo", "version": Version},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// HandleOpenAPI serves the OpenAPI document.
+func (s *Server) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(openAPIDocument()); err != nil {
+		logError(nil, "writing openapi document: %v\n", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// pilosaHTTPTimeout bounds the demo's own requests to Pilosa, for its schema,
+// version and the like, so a Pilosa that accepts connections but never
+// answers can't hang the requests waiting on them.
+const pilosaHTTPTimeout = 10 * time.Second
+
+// PilosaTLSOptions configures how the demo connects to a secured Pilosa.
+type PilosaTLSOptions struct {
+	// TLS connects over HTTPS.
+	TLS bool
+	// SkipVerify accepts any server certificate, for self-signed test
+	// deployments.
+	SkipVerify bool
+	// CAFile is a PEM file of CA certificates to verify the server with,
+	// instead of the system's.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key.
+	CertFile, KeyFile string
+	// Token is sent as a bearer token in the Authorization header.
+	Token string
+}
+
+// pilosaConn is how the demo reaches Pilosa: the scheme and TLS configuration
+// for the go-pilosa client and the demo's own HTTP requests, and the token
+// those requests carry.
+type pilosaConn struct {
+	scheme string
+	tls    *tls.Config
+	token  string
+	client *http.Client
+}
+
+// pilosaHTTP is the connection to Pilosa, plain HTTP unless
+// configurePilosaHTTP is called.
+var pilosaHTTP = pilosaConn{scheme: "http", client: &http.Client{Timeout: pilosaHTTPTimeout}}
+
+// configurePilosaHTTP sets up pilosaHTTP from opts. It must be called before
+// the server is created.
+func configurePilosaHTTP(opts PilosaTLSOptions) error {
+	conn := pilosaConn{scheme: "http", token: opts.Token, client: &http.Client{Timeout: pilosaHTTPTimeout}}
+	if opts.SkipVerify || opts.CAFile != "" || opts.CertFile != "" || opts.KeyFile != "" {
+		opts.TLS = true
+	}
+	if opts.TLS {
+		config := &tls.Config{InsecureSkipVerify: opts.SkipVerify}
+		if opts.CAFile != "" {
+			pem, err := ioutil.ReadFile(opts.CAFile)
+			if err != nil {
+				return fmt.Errorf("reading CA file: %v", err)
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates in CA file %v", opts.CAFile)
+			}
+		}
+		if opts.CertFile != "" || opts.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+			if err != nil {
+				return fmt.Errorf("loading client certificate: %v", err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		conn.scheme, conn.tls = "https", config
+		conn.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config,
+			},
+			Timeout: pilosaHTTPTimeout,
+		}
+	}
+	pilosaHTTP = conn
+	return nil
+}
+
+// authenticates reports whether the demo identifies itself to Pilosa, with a
+// token or a client certificate.
+func (c pilosaConn) authenticates() bool {
+	return c.token != "" || c.tls != nil && len(c.tls.Certificates) > 0
+}
+
+// url returns the URL of path on a Pilosa host.
+func (c pilosaConn) url(host, path string) string {
+	return c.scheme + "://" + host + path
+}
+
+// do sends a request to Pilosa with the token, if any.
+func (c pilosaConn) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.client.Do(req)
+}
+
+// get fetches path from a Pilosa host.
+func (c pilosaConn) get(host, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.url(host, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// post sends body to path on a Pilosa host.
+func (c pilosaConn) post(host, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", c.url(host, path), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.do(req)
+}
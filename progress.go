@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// progressInterval is how often progress events are pushed.
+const progressInterval = time.Second
+
+// Progress reports how far a run has got. Total is 0 if the number of queries
+// the run will execute isn't known in advance. QPS is the rate of completion
+// since the previous event.
+type Progress struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	Query     string           `json:"query"`
+	Status    string           `json:"status"`
+	Completed int              `json:"completed"`
+	Total     int              `json:"total,omitempty"`
+	QPS       float64          `json:"qps"`
+	Last      *BenchmarkResult `json:"last,omitempty"`
+}
+
+// expectedQueries returns the number of queries a run will execute, or 0 if
+// its type doesn't say.
+func expectedQueries(run *Run) int {
+	qs := sampleFor(run, getQuerySet(run.Query))
+	switch run.Type {
+	case "query", "register", "grouped":
+		return qs.iterations * repeatsFor(run)
+	case "grid":
+		return len(gridConcurrency) * len(gridBatchSizes) * qs.iterations * repeatsFor(run)
+	case "suite":
+		return suiteQueries()
+	}
+	return 0
+}
+
+// SetTotal records how many queries a run will execute.
+func (rs *runStore) SetTotal(run *Run, total int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	run.total = total
+}
+
+// SetLast records a run's most recent BenchmarkResult.
+func (rs *runStore) SetLast(run *Run, br BenchmarkResult) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	run.last = &br
+}
+
+// progress returns a run's progress, with QPS measured against the previous
+// report, and whether the run is over.
+func (rs *runStore) progress(id string, prev *Progress, since time.Duration) (Progress, bool, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	run, ok := rs.runs[id]
+	if !ok {
+		return Progress{}, false, false
+	}
+	p := Progress{
+		ID:        run.ID,
+		Type:      run.Type,
+		Query:     run.Query,
+		Status:    run.Status,
+		Completed: run.completed,
+		Total:     run.total,
+		Last:      run.last,
+	}
+	if prev != nil && since > 0 {
+		p.QPS = float64(p.Completed-prev.Completed) / since.Seconds()
+	}
+	return p, run.Finished != nil, true
+}
+
+// HandleJobProgress pushes a run's Progress over a WebSocket every second
+// until the run finishes, e.g. to drive a progress bar:
+//
+//	new WebSocket("ws://localhost:8000/ws/jobs/" + runID).onmessage = ...
+//
+// The last event has status done (or cancelled), after which the server
+// closes the connection.
+func (s *Server) HandleJobProgress(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := s.runs.Get(id); !ok {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "run %v not found", id))
+		return
+	}
+	ws, err := s.upgradeWebSocket(w, r)
+	if err != nil {
+		logWarn(nil, "upgrading websocket for run %v: %v\n", id, err)
+		return
+	}
+	defer ws.Close()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var prev *Progress
+	last := time.Now()
+	for {
+		p, finished, ok := s.runs.progress(id, prev, time.Since(last))
+		if !ok {
+			ws.CloseWith(websocket.CloseNormalClosure, "run expired")
+			return
+		}
+		last = time.Now()
+		if err := ws.WriteJSON(p); err != nil {
+			return
+		}
+		if finished {
+			ws.CloseWith(websocket.CloseNormalClosure, p.Status)
+			return
+		}
+		prev = &p
+		select {
+		case <-ticker.C:
+		case <-ws.closed:
+			return
+		}
+	}
+}
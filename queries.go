@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// QueryInfo describes a query set in the catalog for clients discovering
+// what benchmarks exist.
+type QueryInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Format      string `json:"format"`
+	Kind        string `json:"kind"`
+	// Dimensions is the number of values in each argset; Iterations is
+	// their product.
+	Dimensions []int    `json:"dimensions"`
+	ArgNames   []string `json:"argnames,omitempty"`
+	Iterations int      `json:"iterations"`
+	// Unsupported explains why the connected Pilosa can't run the query set.
+	Unsupported string `json:"unsupported,omitempty"`
+}
+
+// QueryInfos lists every query set in the catalog, sorted by name.
+func (s *Server) QueryInfos() []QueryInfo {
+	names := queries.Names()
+	infos := make([]QueryInfo, 0, len(names))
+	for _, name := range names {
+		qs := getQuerySet(name)
+		if qs.Name == "" {
+			// removed by a reload since Names.
+			continue
+		}
+		info := QueryInfo{
+			Name:        qs.Name,
+			Description: queries.Description(name),
+			Format:      qs.Format,
+			Kind:        string(qs.Kind),
+			Dimensions:  qs.lengths,
+			ArgNames:    qs.argNames,
+			Iterations:  qs.iterations,
+		}
+		if err := s.Supported(qs); err != nil {
+			info.Unsupported = err.Error()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// HandleQueries lists the available query sets, e.g. curl localhost:8000/queries
+func (s *Server) HandleQueries(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(s.QueryInfos()); err != nil {
+		logError(nil, "writing query sets: %v\n", err)
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	pilosa "github.com/pilosa/go-pilosa"
 	"net/http"
-	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -45,12 +50,165 @@ func UnravelIndex(index1 int, dim []int) []int {
 
 type BenchmarkResult struct {
 	Name        string  `json:"name"`
+	Index       string  `json:"index,omitempty"`
 	Iterations  int     `json:"iterations"`
 	Concurrency int     `json:"concurrency"`
 	BatchSize   int     `json:"batchsize"`
 	Seconds     float64 `json:"seconds"`
 	ColumnCount uint64  `json:"columncount"`
-	Timestamp   int32   `json:"timestamp"`
+	ColumnAge   float64 `json:"columnage"` // seconds since ColumnCount was counted
+	Shards      uint64  `json:"shards"`
+	ShardWidth  uint64  `json:"shardwidth"`
+	ShardQPS    float64 `json:"shardqps"` // iterations × shards / seconds
+	RequestID   string  `json:"requestid,omitempty"`
+	Noise       string  `json:"noise,omitempty"`
+
+	// Error is set if the benchmark failed, in which case the timings are
+	// zero.
+	Error *APIError `json:"error,omitempty"`
+
+	// Seconds is measured on the monotonic clock, so it is unaffected by
+	// wall-clock adjustments; Started and Finished are wall-clock UTC times.
+	// Timestamp is Started in Unix seconds, kept for existing clients.
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Timestamp int64     `json:"timestamp"`
+
+	// Sparkline is the batch latency in seconds over the run, in arrival
+	// order, averaged down to at most 100 points.
+	Sparkline []float64 `json:"sparkline,omitempty"`
+
+	// Slowest holds the slowest queries re-executed alone, with --trace-slowest.
+	Slowest []SlowQuery `json:"slowest,omitempty"`
+
+	// ByDimension breaks down latency by the value of each argset, for query
+	// sets with more than one.
+	ByDimension []DimensionLatency `json:"bydimension,omitempty"`
+
+	// SetupSeconds and TeardownSeconds time a register query set's Store
+	// and Purge statements, which Seconds excludes.
+	SetupSeconds    float64 `json:"setupseconds,omitempty"`
+	TeardownSeconds float64 `json:"teardownseconds,omitempty"`
+
+	// Warmup is the warmup setting, and WarmupCount the number of queries
+	// it ran untimed before the benchmark.
+	Warmup      string `json:"warmup,omitempty"`
+	WarmupCount int    `json:"warmupcount,omitempty"`
+
+	// SampleOf is the number of queries in the query set when Iterations of
+	// them were sampled at random with Seed, and Shuffled is set if they ran
+	// in an order given by Seed.
+	SampleOf int   `json:"sampleof,omitempty"`
+	Shuffled bool  `json:"shuffled,omitempty"`
+	Seed     int64 `json:"seed,omitempty"`
+
+	// QPS is the throughput, Iterations over Seconds, and Latency the
+	// distribution of per-query round trip times.
+	QPS     float64       `json:"qps"`
+	Latency *LatencyStats `json:"latency,omitempty"`
+
+	// Queue reports how long batches waited for a free worker, separately
+	// from their execution latency.
+	Queue *QueueStats `json:"queue,omitempty"`
+
+	// Split compares the time spent generating queries with the time spent
+	// executing them.
+	Split *TimeSplit `json:"split,omitempty"`
+
+	// Verification compares the sums with golden answers, with verify=true.
+	Verification *Verification `json:"verification,omitempty"`
+
+	// latencies holds the round trip time of batches in seconds, up to
+	// maxLatencySamples of them, used to test the significance of comparisons.
+	// They are saved with the run, so runs reloaded after a restart can still
+	// be compared.
+	latencies []float64
+}
+
+// QueueStats describes the client-side wait between a batch being generated
+// and a worker picking it up. MeanDepth is the mean number of batches waiting
+// over the run (total wait over run time). Waits that are long relative to
+// latency mean the workers are saturated, so more concurrency may help if
+// Pilosa isn't; waits near zero mean it won't.
+type QueueStats struct {
+	MeanWaitSeconds    float64 `json:"meanwaitseconds"`
+	MaxWaitSeconds     float64 `json:"maxwaitseconds"`
+	MeanLatencySeconds float64 `json:"meanlatencyseconds"`
+	MeanDepth          float64 `json:"meandepth"`
+}
+
+// newQueueStats summarizes per-batch waits and latencies, in seconds, over a
+// run of the given length.
+func newQueueStats(waits, latencies []float64, seconds float64) *QueueStats {
+	if len(waits) == 0 {
+		return nil
+	}
+	qs := &QueueStats{}
+	total := 0.0
+	for _, w := range waits {
+		total += w
+		if w > qs.MaxWaitSeconds {
+			qs.MaxWaitSeconds = w
+		}
+	}
+	qs.MeanWaitSeconds = total / float64(len(waits))
+	qs.MeanLatencySeconds, _ = mean(latencies)
+	if seconds > 0 {
+		qs.MeanDepth = total / seconds
+	}
+	return qs
+}
+
+// LatencyStats summarizes per-query round trip times in seconds. A query's
+// round trip is that of the batch it was sent in, so larger batches trade
+// higher per-query latency for fewer requests.
+type LatencyStats struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+}
+
+// newLatencyStats summarizes per-query latencies in seconds.
+func newLatencyStats(latencies []float64) *LatencyStats {
+	if len(latencies) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), latencies...)
+	sort.Float64s(sorted)
+	ls := &LatencyStats{
+		Min: sorted[0],
+		Max: sorted[len(sorted)-1],
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+	}
+	ls.Mean, _ = mean(sorted)
+	return ls
+}
+
+// TimeSplit divides a run's time between client-side query generation
+// (formatting queries and joining them into batches) and execution (batch round
+// trips to Pilosa), both summed over all goroutines. GenerationShare is the
+// generation time's share of the total; near zero means the load generator's
+// overhead is negligible.
+type TimeSplit struct {
+	GenerationSeconds float64 `json:"generationseconds"`
+	ExecutionSeconds  float64 `json:"executionseconds"`
+	GenerationShare   float64 `json:"generationshare"`
+}
+
+func newTimeSplit(generation time.Duration, latencies []float64) *TimeSplit {
+	ts := &TimeSplit{GenerationSeconds: generation.Seconds()}
+	for _, l := range latencies {
+		ts.ExecutionSeconds += l
+	}
+	if total := ts.GenerationSeconds + ts.ExecutionSeconds; total > 0 {
+		ts.GenerationShare = ts.GenerationSeconds / total
+	}
+	return ts
 }
 
 // QuerySet encapsulates a small amount of information necessary for
@@ -59,16 +217,28 @@ type QuerySet struct {
 	Name       string
 	Format     string
 	ArgSets    [][]int
-	setup      string
-	teardown   string
+	Kind       ResultKind
+	argNames   []string
+	setup      []string
+	teardown   []string
 	dim        int
 	iterations int
 	lengths    []int
 
-	// need to maintain this stuff for sorting on both input and output fields
-	// Results    []QueryResult
-	// sortfunc
-	// resultfunc
+	// index is the index to query, or nil for the server's.
+	index *pilosa.Index
+
+	// order sorts results before they are written, e.g. to match the ORDER
+	// BY clause of the SSB query; nil leaves them in completion order.
+	order []SortKey
+
+	// sample, if set, lists the queries of the full set that are run, in the
+	// order they are run, chosen with seed out of sampleOf and shuffled if
+	// shuffled is set (see Sample and Shuffle).
+	sample   []int
+	sampleOf int
+	shuffled bool
+	seed     int64
 }
 
 type QueryResult struct {
@@ -76,6 +246,15 @@ type QueryResult struct {
 	inputs  []interface{}
 	outputs []interface{}
 	err     error
+	batch   string // ID of the batch the query was sent in
+	// latency is the round trip time of the batch; first is set on the
+	// batch's first result only, so each batch is counted once.
+	latency time.Duration
+	first   bool
+	// queued is when the batch was ready to send, and wait how long it then
+	// waited for a worker.
+	queued time.Time
+	wait   time.Duration
 }
 
 func NewQuerySet(name, fmt string, argsets [][]int) QuerySet {
@@ -83,6 +262,7 @@ func NewQuerySet(name, fmt string, argsets [][]int) QuerySet {
 	qs.Name = name
 	qs.Format = fmt
 	qs.ArgSets = argsets
+	qs.Kind = ResultSum
 	qs.dim = len(argsets)
 
 	iterations := 1
@@ -98,37 +278,214 @@ func NewQuerySet(name, fmt string, argsets [][]int) QuerySet {
 	return qs
 }
 
-func NewRegisterQuerySet(name, fmt, setup, teardown string, argsets [][]int) QuerySet {
+// NewRegisterQuerySet creates a QuerySet with ordered lists of setup and teardown
+// queries, e.g. Store and Purge statements for register queries. argnames names
+// each argset; a named argset is referenced as {{name}} rather than %d in the
+// format, wherever it appears, and setup/teardown statements referencing
+// {{name}} are repeated for each of its values. Unnamed argsets ("") are
+// positional as usual, filling the format's %d in order.
+func NewRegisterQuerySet(name, fmt string, setup, teardown, argnames []string, argsets [][]int) QuerySet {
 	qs := NewQuerySet(name, fmt, argsets)
+	qs.argNames = argnames
 	qs.setup = setup
 	qs.teardown = teardown
 	return qs
 }
 
+// verb matches each fmt verb in a format, and %% escapes; argRef matches each
+// {{name}} reference.
+var (
+	verb   = regexp.MustCompile(`%[-+# 0-9.*\[\]]*[a-zA-Z%]`)
+	argRef = regexp.MustCompile(`\{\{(\w+)\}\}`)
+)
+
+// namedRef returns the placeholder referencing the named argset.
+func namedRef(name string) string {
+	return "{{" + name + "}}"
+}
+
+// Validate checks that every query of the set will expand to well-formed PQL:
+// each argset has values, the format has one %d per positional argset and no
+// other verbs, and every {{name}} in the format, setup and teardown is a named
+// argset used by the format. Whether the frames exist depends on the index;
+// see CheckFrames.
+func (s *QuerySet) Validate() error {
+	if len(s.argNames) > len(s.ArgSets) {
+		return fmt.Errorf("%d argnames for %d argsets", len(s.argNames), len(s.ArgSets))
+	}
+	named := make(map[string]bool)
+	positional := 0
+	for k, argset := range s.ArgSets {
+		if len(argset) == 0 {
+			return fmt.Errorf("argset %d is empty", k)
+		}
+		name := s.argName(k)
+		if name == "" {
+			positional++
+			continue
+		}
+		if named[name] {
+			return fmt.Errorf("argset name %v is used twice", name)
+		}
+		named[name] = true
+	}
+
+	placeholders := 0
+	for _, v := range verb.FindAllString(s.Format, -1) {
+		switch v {
+		case "%%":
+		case "%d":
+			placeholders++
+		default:
+			return fmt.Errorf("format has %v; only %%d placeholders are supported", v)
+		}
+	}
+	if placeholders != positional {
+		return fmt.Errorf("format has %d placeholders for %d positional argsets", placeholders, positional)
+	}
+	for _, stmt := range append(append([]string{s.Format}, s.setup...), s.teardown...) {
+		for _, m := range argRef.FindAllStringSubmatch(stmt, -1) {
+			if !named[m[1]] {
+				return fmt.Errorf("%v is not a named argset", m[0])
+			}
+		}
+	}
+	for name := range named {
+		if !strings.Contains(s.Format, namedRef(name)) {
+			return fmt.Errorf("named argset %v is not used in the format", name)
+		}
+	}
+	return nil
+}
+
+// CheckFrames checks that every frame the set references is in live, the
+// frames of the index's schema. A nil live, an unknown schema, passes.
+func (s *QuerySet) CheckFrames(live map[string]map[string]interface{}) error {
+	if live == nil {
+		return nil
+	}
+	for _, f := range s.RequiredFrames() {
+		if _, ok := live[f]; !ok {
+			return fmt.Errorf("unknown frame %v", f)
+		}
+	}
+	return nil
+}
+
+// argName returns the name of the kth argset, or "" if it is positional.
+func (s *QuerySet) argName(k int) string {
+	if k < len(s.argNames) {
+		return s.argNames[k]
+	}
+	return ""
+}
+
+// expand formats the query for one set of argset values, substituting
+// positional values via %d and named values via {{name}}.
+func (s *QuerySet) expand(format string, values []interface{}) string {
+	positional := make([]interface{}, 0, len(values))
+	for k, v := range values {
+		if s.argName(k) == "" {
+			positional = append(positional, v)
+		}
+	}
+	raw := fmt.Sprintf(format, positional...)
+	for k, v := range values {
+		if name := s.argName(k); name != "" {
+			raw = strings.Replace(raw, namedRef(name), fmt.Sprint(v), -1)
+		}
+	}
+	return raw
+}
+
+// expandStatements expands {{name}} references in setup or teardown statements,
+// repeating each statement for every combination of the argsets it references.
+func (s *QuerySet) expandStatements(stmts []string) []string {
+	var expanded []string
+	for _, stmt := range stmts {
+		var refs []int
+		for k := range s.ArgSets {
+			if name := s.argName(k); name != "" && strings.Contains(stmt, namedRef(name)) {
+				refs = append(refs, k)
+			}
+		}
+		lens := make([]int, len(refs))
+		count := 1
+		for i, k := range refs {
+			lens[i] = len(s.ArgSets[k])
+			count *= lens[i]
+		}
+		for n := 0; n < count; n++ {
+			inds := UnravelIndex(n, lens)
+			raw := stmt
+			for i, k := range refs {
+				raw = strings.Replace(raw, namedRef(s.argNames[k]), fmt.Sprint(s.ArgSets[k][inds[i]]), -1)
+			}
+			expanded = append(expanded, raw)
+		}
+	}
+	return expanded
+}
+
+// SetupQuery returns the expanded setup statements as a single raw batch query.
+func (s *QuerySet) SetupQuery() string {
+	return strings.Join(s.expandStatements(s.setup), "\n")
+}
+
+// TeardownQuery returns the expanded teardown statements as a single raw batch query.
+func (s *QuerySet) TeardownQuery() string {
+	return strings.Join(s.expandStatements(s.teardown), "\n")
+}
+
+// placeholder matches each positional %d in a format, capturing the frame
+// when it is a Bitmap rowID.
+var placeholder = regexp.MustCompile(`(?:frame="?(\w+)"?,\s*rowID=)?%d`)
+
+// Dimensions names each argset: named argsets by their name, positional ones
+// by the frame whose rowID they fill in, or "argN" otherwise.
+func (s *QuerySet) Dimensions() []string {
+	matches := placeholder.FindAllStringSubmatch(s.Format, -1)
+	dims := make([]string, s.dim)
+	p := 0
+	for k := range dims {
+		if name := s.argName(k); name != "" {
+			dims[k] = name
+			continue
+		}
+		if p < len(matches) && matches[p][1] != "" {
+			dims[k] = matches[p][1]
+		} else {
+			dims[k] = fmt.Sprintf("arg%d", k)
+		}
+		p++
+	}
+	return dims
+}
+
 func (s *QuerySet) String() string {
 	return fmt.Sprintf("%d queries of form:\n%s", s.iterations, s.Format)
 }
 
 // QueryN generates the Nth query of a QuerySet, as a raw query string
 func (s *QuerySet) QueryN(n int) string {
-	inds := UnravelIndex(n, s.lengths)
+	inds := UnravelIndex(s.queryIndex(n), s.lengths)
 	args := make([]interface{}, s.dim)
 	for k := 0; k < s.dim; k++ {
 		args[k] = s.ArgSets[k][inds[k]]
 	}
-	return fmt.Sprintf(s.Format+"\n", args...)
+	return s.expand(s.Format+"\n", args)
 }
 
 // QueryResultN generates the Nth query of a QuerySet, as a QueryResult
 func (s *QuerySet) QueryResultN(n int) QueryResult {
 	qr := QueryResult{}
-	inds := UnravelIndex(n, s.lengths)
+	inds := UnravelIndex(s.queryIndex(n), s.lengths)
 	qr.inputs = make([]interface{}, s.dim)
 	qr.outputs = make([]interface{}, 1)
 	for k := 0; k < s.dim; k++ {
 		qr.inputs[k] = s.ArgSets[k][inds[k]]
 	}
-	qr.raw = fmt.Sprintf(s.Format+"\n", qr.inputs...)
+	qr.raw = s.expand(s.Format+"\n", qr.inputs)
 	return qr
 }
 
@@ -138,21 +495,132 @@ func (s *QuerySet) QueryResultN(n int) QueryResult {
 // concurrency=N, batchSize=1                 -> equivalent to RunSumConcurrent(N)
 // concurrency=N, batchSize=10                -> sends concurrent batches of 10 queries
 func (s *Server) RunSumMultiBatch(qs QuerySet, concurrency, batchSize int) BenchmarkResult {
-	batches := make(chan []QueryResult)
-	results := make(chan QueryResult)
+	return s.runRecorded(nil, qs, concurrency, batchSize)
+}
 
+// RunSumMultiBatchRegister runs a register query set, one with setup
+// statements that Store bitmaps its queries Load, and teardown statements that
+// Purge them, with RunSumMultiBatch. Setup and teardown are reported in
+// SetupSeconds and TeardownSeconds rather than Seconds, and teardown runs even
+// if the benchmark fails.
+func (s *Server) RunSumMultiBatchRegister(run *Run, qs QuerySet, concurrency, batchSize int) BenchmarkResult {
+	if !qs.IsRegister() {
+		return failedResult(qs.Name, time.Now(), newAPIError(http.StatusBadRequest, errUnknownType, "query set %v has no setup statements; run it as a plain query", qs.Name))
+	}
+	return s.runRecorded(run, qs, concurrency, batchSize)
+}
+
+// IsRegister reports whether the query set stores bitmaps in setup.
+func (s *QuerySet) IsRegister() bool { return len(s.setup) > 0 }
+
+// runRecorded is RunSumMultiBatch, additionally recording per-query results in
+// run unless it is nil, and streaming them if the run has a stream.
+func (s *Server) runRecorded(run *Run, qs QuerySet, concurrency, batchSize int) (br BenchmarkResult) {
+	if run != nil {
+		defer func() {
+			s.runs.SetLast(run, br)
+			if run.stream != nil {
+				run.stream.Result(br)
+			}
+		}()
+	}
+	now := time.Now()
+	if err := s.Supported(qs); err != nil {
+		logf(run, "%v\n", err)
+		return failedResult(qs.Name, now, newAPIError(http.StatusNotImplemented, errUnsupported, "%v", err))
+	}
 	// Create results file.
-	timestamp := int32(time.Now().Unix())
-	fname := fmt.Sprintf("results/%v-%v.txt", qs.Name, timestamp)
-	err := os.MkdirAll("results", 0700)
-	if err != nil {
-		fmt.Printf("creating results directory: %v\n", err)
-		return BenchmarkResult{qs.Name, 0, 0, 0, -1, 0, timestamp}
+	tag := ""
+	writer := s.resultWriter
+	if run != nil {
+		tag = run.RequestID
+		switch run.Type {
+		case "grouped":
+			// the table in the response replaces the results file.
+			writer = "discard"
+		case "batching":
+			// each query set runs unbatched and batched.
+			tag += fmt.Sprintf("-b%d", batchSize)
+		}
 	}
-	f, err := os.Create(fname)
+	if qs.index != nil {
+		// query sets on other indexes, such as tenants running side by side,
+		// get files of their own.
+		tag = strings.TrimPrefix(tag+"-"+qs.index.Name(), "-")
+	}
+	rw, err := newResultWriter(writer, s.resultsDir, qs.Name, now.Unix(), tag, s.resultsGzip)
 	if err != nil {
-		fmt.Printf("creating results file: %v\n", err)
-		return BenchmarkResult{qs.Name, 0, 0, 0, -1, 0, timestamp}
+		logf(run, "%v\n", err)
+		return failedResult(qs.Name, now, newAPIError(http.StatusInternalServerError, errInternal, "%v", err))
+	}
+	sink := rw
+	if run != nil {
+		sink = teeSink{rw, s.runs.Recorder(run, qs)}
+	}
+	order := qs.order
+	if run != nil && run.Sort != "" {
+		if order, err = qs.ParseOrder(run.Sort); err != nil {
+			logf(run, "%v\n", err)
+			sink.Close()
+			return failedResult(qs.Name, now, newAPIError(http.StatusBadRequest, errBadRequest, "%v", err))
+		}
+	}
+	if order != nil {
+		sink = &sortingSink{sink: sink, order: order}
+	}
+	if run != nil && run.stream != nil {
+		sink = teeSink{sink, run.stream.Sink(run.sets-1, qs.Name)}
+	}
+	br = s.runSumMultiBatch(run, qs, concurrency, batchSize, sink)
+	br.RequestID = tag
+	return br
+}
+
+// runSumMultiBatch implements RunSumMultiBatch, handing each result to sink as
+// it arrives. The sink is closed before returning. If run is not nil, it is
+// sent a heartbeat for every result, and the benchmark stops early if the run
+// is cancelled. The benchmark is traced as a span, with a child for each batch.
+func (s *Server) runSumMultiBatch(run *Run, qs QuerySet, concurrency, batchSize int, sink ResultWriter) (br BenchmarkResult) {
+	defer sink.Close()
+	bench := s.tracer.Start(nil, "benchmark "+qs.Name, spanInternal)
+	bench.Set("demo.queryset", qs.Name)
+	bench.Set("demo.iterations", qs.iterations)
+	bench.Set("demo.concurrency", concurrency)
+	bench.Set("demo.batchsize", batchSize)
+	if run != nil {
+		bench.Set("demo.run", run.ID)
+		bench.Set("demo.requestid", run.RequestID)
+	}
+	batches := make(chan []QueryResult)
+	results := make(chan QueryResult)
+	pipe := s.pipelines.Add(run, qs.Name, concurrency)
+	defer s.pipelines.Remove(pipe)
+
+	// generation accumulates nanoseconds spent generating queries, in the
+	// generator and in the workers.
+	var generation int64
+	defer func() {
+		bench.Set("demo.generation_seconds", time.Duration(atomic.LoadInt64(&generation)).Seconds())
+		bench.Set("demo.qps", br.QPS)
+		var err error
+		if br.Error != nil {
+			err = br.Error
+		} else {
+			metric := metricName(qs.Name)
+			s.statsd.Gauge(metric+".qps", br.QPS)
+			s.statsd.Timing(metric+".benchmark", time.Duration(br.Seconds*float64(time.Second)))
+		}
+		bench.End(err)
+	}()
+
+	// done stops the generator and workers if we return early.
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(done) }) }
+	defer stop()
+	var cancel <-chan struct{}
+	if run != nil {
+		cancel = run.cancel
 	}
 
 	// Add queries to channel
@@ -161,635 +629,561 @@ func (s *Server) RunSumMultiBatch(qs QuerySet, concurrency, batchSize int) Bench
 		qBatch := make([]QueryResult, 0, batchSize)
 		batchCount := 0
 		for n := 0; n < qs.iterations; n++ {
+			genStart := time.Now()
 			qq := qs.QueryResultN(n)
 			qBatch = append(qBatch, qq)
+			atomic.AddInt64(&generation, int64(time.Since(genStart)))
 
 			batchCount++
 			if batchCount == batchSize {
-				batches <- qBatch
+				qBatch[0].queued = time.Now()
+				atomic.AddInt64(&pipe.batchesWaiting, 1)
+				select {
+				case batches <- qBatch:
+				case <-done:
+					atomic.AddInt64(&pipe.batchesWaiting, -1)
+					close(batches)
+					return
+				}
+				atomic.AddInt64(&pipe.batchesWaiting, -1)
 				batchCount = 0
 				qBatch = make([]QueryResult, 0, batchSize)
 			}
 		}
 		if batchCount > 0 {
-			batches <- qBatch
+			qBatch[0].queued = time.Now()
+			atomic.AddInt64(&pipe.batchesWaiting, 1)
+			select {
+			case batches <- qBatch:
+			case <-done:
+			}
+			atomic.AddInt64(&pipe.batchesWaiting, -1)
 		}
 		close(batches)
 	}()
 
-	start := time.Now()
-	// Run setup query.
-	if qs.setup != "" {
-		_, err := s.Client.Query(s.Index.RawQuery(qs.setup), nil)
+	index := s.Index
+	if qs.index != nil {
+		index = qs.index
+	}
+
+	warmup := s.warmupFor(run)
+	warmed, err := s.warm(run, index, qs, warmup, batchSize)
+	if err != nil {
+		logf(run, "%v\n", err)
+		return failedResult(qs.Name, time.Now(), pilosaError(err))
+	}
+
+	// Run setup queries as a single batch, timed separately from the
+	// benchmark.
+	var setupSeconds float64
+	if setup := qs.SetupQuery(); setup != "" {
+		setupStart := time.Now()
+		_, err := s.query(index.RawQuery(s.pql(setup)))
+		setupSeconds = time.Since(setupStart).Seconds()
 		if err != nil {
-			fmt.Printf("error in setup: %v\n", err)
-			return BenchmarkResult{qs.Name, 0, 0, 0, -1, 0, timestamp}
+			logError(run, "error in setup: %v\n", err)
+			return failedResult(qs.Name, setupStart, pilosaError(fmt.Errorf("setup: %v", err)))
 		}
 	}
 
+	// Run teardown queries as a single batch on the way out, once the
+	// workers have stopped, even if the benchmark fails, so nothing setup
+	// stored is left behind.
+	if teardown := qs.TeardownQuery(); teardown != "" {
+		defer func() {
+			stop()
+			teardownStart := time.Now()
+			_, err := s.query(index.RawQuery(s.pql(teardown)))
+			br.TeardownSeconds = time.Since(teardownStart).Seconds()
+			if err != nil {
+				logError(run, "error in teardown: %v\n", err)
+				if br.Error == nil {
+					br.Error = pilosaError(fmt.Errorf("teardown: %v", err))
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+
 	// Start workers.
 	var wg = &sync.WaitGroup{}
 	for n := 0; n < concurrency; n++ {
 		wg.Add(1)
 		go func() {
-			s.runRawSumBatchQuery(batches, results, wg)
+			s.runRawSumBatchQuery(run, index, qs.Name, qs.Kind, batches, results, done, wg, &generation, pipe, bench)
 		}()
 	}
 	go func() {
 		wg.Wait()
 		close(results)
 	}()
-	// TODO sort
 
-	// Write results to file.
-	defer f.Close()
-	nn := 0
-	for res := range results {
+	// Consume results.
+	var latencies, waits, queryLatencies []float64
+	slow := slowestQueries{n: s.traceSlowest}
+	dims := newDimensionStats(qs)
+	for {
+		var res QueryResult
+		var ok bool
+		select {
+		case res, ok = <-results:
+		case <-cancel:
+			logf(run, "run %v cancelled\n", run.ID)
+			return failedResult(qs.Name, start, newAPIError(http.StatusGatewayTimeout, errTimeout, "run %v stalled and was cancelled", run.ID))
+		}
+		if !ok {
+			break
+		}
+		s.runs.Completed(run)
 		if res.err != nil {
-			fmt.Printf("running query: %v\n", res.err)
-			return BenchmarkResult{qs.Name, 0, 0, 0, -1, 0, timestamp}
+			logError(run, "running query: %v\n", res.err)
+			return failedResult(qs.Name, start, pilosaError(res.err))
 		}
-		n, err := f.WriteString(fmt.Sprintf("%v %v\n", res.outputs[0], res.inputs))
-		nn += n
-		if err != nil {
-			fmt.Printf("writing results file: %v\n", err)
+		if res.first {
+			latencies = append(latencies, res.latency.Seconds())
+			waits = append(waits, res.wait.Seconds())
+		}
+		queryLatencies = append(queryLatencies, res.latency.Seconds())
+		slow.add(res)
+		dims.add(res)
+		if err := sink.Write(res); err != nil {
+			logf(run, "%v\n", err)
 			break
 		}
 	}
 
-	// Run teardown query.
-	if qs.teardown != "" {
-		_, err := s.Client.Query(s.Index.RawQuery(qs.teardown), nil)
-		if err != nil {
-			fmt.Printf("error in teardown: %v\n", err)
-			return BenchmarkResult{qs.Name, 0, 0, 0, -1, 0, timestamp}
-		}
-	}
+	// Re-run the slowest queries alone, before teardown removes anything they
+	// Load.
+	slowest := s.TraceSlowest(run, index.Name(), slow.queries)
 
-	seconds := time.Now().Sub(start).Seconds()
-	fmt.Printf("wrote %d bytes to %v\n", nn, fname)
+	seconds := time.Since(start).Seconds()
+	columnCount, columnAge := s.lineOrdersIn(index)
+	shards, err := getShardCount(s.pilosaAddr(), index.Name())
+	if err != nil {
+		logWarn(run, "getting shard count: %v\n", err)
+	}
 
 	// Return result object.
 	return BenchmarkResult{
-		qs.Name,
-		qs.iterations,
-		concurrency,
-		batchSize,
-		seconds,
-		s.NumLineOrders,
-		timestamp,
+		Name:         qs.Name,
+		Index:        index.Name(),
+		Iterations:   qs.iterations,
+		Concurrency:  concurrency,
+		BatchSize:    batchSize,
+		Seconds:      seconds,
+		ColumnCount:  columnCount,
+		ColumnAge:    columnAge.Seconds(),
+		Shards:       shards,
+		ShardWidth:   s.shardWidth,
+		ShardQPS:     shardQPS(qs.iterations, shards, seconds),
+		QPS:          float64(qs.iterations) / seconds,
+		SetupSeconds: setupSeconds,
+		Warmup:       warmup.String(),
+		WarmupCount:  warmed,
+		SampleOf:     qs.sampleOf,
+		Shuffled:     qs.shuffled,
+		Seed:         qs.seed,
+		Latency:      newLatencyStats(queryLatencies),
+		Noise:        s.noise.String(),
+		Started:      start.UTC(),
+		Finished:     time.Now().UTC(),
+		Timestamp:    start.Unix(),
+		Sparkline:    downsample(latencies, sparklinePoints),
+		Slowest:      slowest,
+		ByDimension:  dims.Latencies(),
+		Queue:        newQueueStats(waits, latencies, seconds),
+		Split:        newTimeSplit(time.Duration(atomic.LoadInt64(&generation)), latencies),
+		latencies:    subsample(latencies, maxLatencySamples),
+	}
+}
+
+// failedResult returns the BenchmarkResult reported when a run fails.
+func failedResult(name string, started time.Time, err *APIError) BenchmarkResult {
+	return BenchmarkResult{
+		Name:      name,
+		Error:     err,
+		Started:   started.UTC(),
+		Finished:  time.Now().UTC(),
+		Timestamp: started.Unix(),
 	}
 }
 
-// runRawSumBatchQuery sends RawQueries to the cluster, then sends the Sum from each result to a result channel.
-func (s *Server) runRawSumBatchQuery(batches <-chan []QueryResult, results chan<- QueryResult, wg *sync.WaitGroup) {
+// runRawSumBatchQuery sends RawQueries to the cluster, then sends the result of each query, decoded according
+// to kind, to a result channel.
+// It returns once batches is closed, or done is closed. Time spent joining
+// queries into batches is added to generation, in nanoseconds, and pipe counts
+// the results waiting to be collected. Each batch is traced as a child span of
+// bench, with a child for each request to Pilosa, and its latency sent to
+// statsd.
+func (s *Server) runRawSumBatchQuery(run *Run, index *pilosa.Index, name string, kind ResultKind, batches <-chan []QueryResult, results chan<- QueryResult, done <-chan struct{}, wg *sync.WaitGroup, generation *int64, pipe *pipeline, bench *span) {
 	// Receives batches of queries as []QueryResult. Each slice is compiled into a
 	// a raw batch query, a single request is sent, and the results are collated
 	// with the input []QueryResult, then sent back on the results channel one at a time.
 	defer wg.Done()
+	metric := metricName(name)
 	for batch := range batches {
+		wait := time.Since(batch[0].queued)
+		genStart := time.Now()
 		raw := ""
 		for _, q := range batch {
 			raw += q.raw
 		}
-		response, err := s.Client.Query(s.Index.RawQuery(raw), nil)
+		raw = s.pql(raw)
+		joined := time.Since(genStart)
+		atomic.AddInt64(generation, int64(joined))
+		batchID := newUUID()
+		sp := s.tracer.Start(bench, "batch", spanInternal)
+		sp.Set("demo.batch", batchID)
+		sp.Set("demo.queries", len(batch))
+		sp.Set("demo.wait_seconds", wait.Seconds())
+		sp.Set("demo.generation_seconds", joined.Seconds())
+		id := s.inflight.Add(name, batchID, len(batch), raw)
+		// Only the last attempt is timed, so a retried batch doesn't count
+		// its failed attempts and backoff as latency.
+		var response *pilosa.QueryResponse
+		var latency time.Duration
+		attempt := 0
+		err := s.retry(run, "batch "+batchID, func() error {
+			attempt++
+			req := s.tracer.Start(sp, "pilosa query", spanClient)
+			req.Set("demo.batch", batchID)
+			req.Set("demo.attempt", attempt)
+			sent := time.Now()
+			var err error
+			response, err = s.pilosaClient().Query(index.RawQuery(raw), nil)
+			latency = time.Since(sent)
+			req.End(err)
+			return err
+		})
+		s.inflight.Remove(id)
+		s.statsd.Timing(metric+".batch.wait", wait)
 
 		if err != nil {
-			fmt.Printf("in runRawSumBatchQuery: %vfailed with: %v\n", raw, err)
-			results <- QueryResult{raw, []interface{}{}, []interface{}{}, err}
+			s.statsd.Count(metric+".errors", 1)
+			logError(run, "in runRawSumBatchQuery: batch %v: %vfailed with: %v\n", batchID, raw, err)
+			s.rediscover(err)
+			err = fmt.Errorf("batch %v: %v", batchID, err)
+			sp.End(err)
+			if !sendResult(results, QueryResult{raw: raw, inputs: []interface{}{}, outputs: []interface{}{}, err: err, batch: batchID}, done, pipe) {
+				return
+			}
+			continue
 		}
+		s.statsd.Timing(metric+".batch.latency", latency)
+		s.statsd.Count(metric+".queries", len(batch))
+		sent := true
 		for n, res := range response.Results() {
-			batch[n].outputs = []interface{}{int(res.Sum)}
-			results <- batch[n]
+			batch[n].outputs = []interface{}{kind.decode(res, s.noise)}
+			batch[n].batch = batchID
+			batch[n].latency = latency
+			batch[n].first = n == 0
+			batch[n].wait = wait
+			if sent = sendResult(results, batch[n], done, pipe); !sent {
+				break
+			}
+		}
+		sp.End(nil)
+		if !sent {
+			return
+		}
+	}
+}
+
+// sendResult sends res on results, counting it in pipe while it waits. It
+// returns false if done was closed first.
+func sendResult(results chan<- QueryResult, res QueryResult, done <-chan struct{}, pipe *pipeline) bool {
+	atomic.AddInt64(&pipe.resultsWaiting, 1)
+	defer atomic.AddInt64(&pipe.resultsWaiting, -1)
+	select {
+	case results <- res:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// gridConcurrency and gridBatchSizes are the settings RunGrid runs every
+// combination of.
+var (
+	gridConcurrency = []int{8, 16, 32}
+	gridBatchSizes  = []int{2, 4, 8}
+)
+
+// RunGrid runs a QuerySet over a grid of concurrency and batch size settings,
+// recording per-query results in run unless it is nil. Each setting is
+// repeated as many times as the run asks, consecutively.
+func (s *Server) RunGrid(run *Run, qs QuerySet) []BenchmarkResult {
+	var results []BenchmarkResult
+	for _, c := range gridConcurrency {
+		for _, b := range gridBatchSizes {
+			results = append(results, s.runRepeated(run, qs, c, b, repeatsFor(run))...)
 		}
 	}
+	return results
+}
+
+// maxDetailRecords bounds the per-query records embedded in a detail=inline
+// response; the rest are available from /runs/{id}/results.
+const maxDetailRecords = 10000
+
+// DetailedResult is a BenchmarkResult with its per-query records inline.
+// Truncated is set when records were left out to respect maxDetailRecords.
+type DetailedResult struct {
+	BenchmarkResult
+	Queries   []QueryRecord `json:"queries"`
+	Truncated bool          `json:"truncated,omitempty"`
 }
 
+// detailedResults attaches a run's per-query records to its results.
+func detailedResults(run Run) []DetailedResult {
+	detailed := make([]DetailedResult, len(run.Results))
+	for n := range detailed {
+		detailed[n] = DetailedResult{BenchmarkResult: run.Results[n], Queries: []QueryRecord{}}
+	}
+	included := 0
+	for _, rec := range run.records {
+		if rec.Set >= len(detailed) {
+			continue
+		}
+		if included >= maxDetailRecords {
+			detailed[rec.Set].Truncated = true
+			continue
+		}
+		detailed[rec.Set].Queries = append(detailed[rec.Set].Queries, rec)
+		included++
+	}
+	return detailed
+}
+
+// HandleQuery runs a query set once, over a grid, as a register query set, or
+// grouped into a table (see GroupedResult). With detail=full the
+// response streams per-query results and each BenchmarkResult as
+// newline-delimited JSON while the benchmark runs (see queryStream); with
+// detail=inline it embeds per-query results in the BenchmarkResults, up to
+// maxDetailRecords; the default, detail=summary, returns only the
+// BenchmarkResults. warmup overrides --warmup for the run, repeats=N runs
+// each configuration N times, returning RepeatedResults, sort overrides
+// the query set's order for written results (see ParseOrder), and verify
+// checks sums against golden answers (see verifyRun). index selects one of
+// the indexes given with --indexes rather than the server's. sample=N runs
+// N of the query set's queries chosen at random, reproducibly given seed
+// (see Sample), and shuffle=true runs them in a random order (see Shuffle).
+// dryrun=true returns the query set's PQL instead of running
+// it (see DryRun).
 func (s *Server) HandleQuery(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("handling %v\n", r.URL.Path)
+	requestID := requestIDFor(w, r)
 	vars := mux.Vars(r)
 	qname, qtype := vars["qname"], vars["qtype"]
-
+	detail := r.URL.Query().Get("detail")
+	if detail != "" && detail != "summary" && detail != "full" && detail != "inline" {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "invalid detail %q, want summary, full or inline", detail))
+		return
+	}
+	if qtype != "query" && qtype != "grid" && qtype != "register" && qtype != "grouped" {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errUnknownType, "unknown type %q, want query, grid, register or grouped", qtype))
+		return
+	}
 	qs := getQuerySet(qname)
-	var results []BenchmarkResult
-	if qtype == "query" {
-		results = []BenchmarkResult{
-			s.RunSumMultiBatch(qs, s.concurrency, s.batchSize),
-		}
-	} else if qtype == "grid" {
-		concurrency := []int{8, 16, 32}
-		batchSize := []int{2, 4, 8}
-		for _, c := range concurrency {
-			for _, b := range batchSize {
-				results = append(results, s.RunSumMultiBatch(qs, c, b))
-			}
+	if qs.Name == "" {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errUnknownQuery, "unknown query set %q; see /queries", qname))
+		return
+	}
+	if qtype == "register" && !qs.IsRegister() {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errUnknownType, "query set %v has no setup statements; run it as /query/%v", qname, qname))
+		return
+	}
+	if r.URL.Query().Get("dryrun") == "true" {
+		s.writeDryRun(w, qs)
+		return
+	}
+	if err := s.Supported(qs); err != nil {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusNotImplemented, errUnsupported, "%v", err))
+		return
+	}
+	warmup := r.URL.Query().Get("warmup")
+	if _, err := parseWarmup(warmup); err != nil {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "%v", err))
+		return
+	}
+
+	sortOrder := r.URL.Query().Get("sort")
+	if _, err := qs.ParseOrder(sortOrder); err != nil {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "%v", err))
+		return
+	}
+	repeats := 1
+	if v := r.URL.Query().Get("repeats"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRepeats {
+			s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "invalid repeats %q, want 1 to %d", v, maxRepeats))
+			return
 		}
-		//	} else if qtype == "register" {
-		//		results = []BenchmarkResult{
-		//			s.RunSumMultiBatchRegister(qs, s.concurrency, s.batchSize),
-		//		}
+		repeats = n
+	}
+
+	index, apiErr := s.indexParam(r)
+	if apiErr != nil {
+		s.reject(w, qtype, qname, requestID, apiErr)
+		return
+	}
+	verify := r.URL.Query().Get("verify")
+	if err := s.checkVerify(verify, qname, index); err != nil {
+		s.reject(w, qtype, qname, requestID, err)
+		return
+	}
+	if verify == "false" {
+		verify = ""
+	}
+	sample, shuffle, seed, apiErr := parseSample(r)
+	if apiErr != nil {
+		s.reject(w, qtype, qname, requestID, apiErr)
+		return
+	}
+	if sample > 0 && verify != "" {
+		s.reject(w, qtype, qname, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "verify needs every query, so it can't be combined with sample"))
+		return
 	}
 
+	concurrency, batchSize := s.querySettings(qname)
+	run := s.runs.Start(qtype, qname, requestID, concurrency, batchSize)
+	defer s.failOnPanic(run)
+	run.Warmup, run.Repeats, run.Sort, run.Verify = warmup, repeats, sortOrder, verify
+	run.Index, run.Sample, run.Shuffle, run.Seed = index, sample, shuffle, seed
+	logf(run, "handling %v\n", r.URL.Path)
+	w.Header().Set("X-Run-ID", run.ID)
+	if detail == "full" {
+		// Errors are reported in the streamed results, since the status
+		// has been sent by the time they happen.
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		run.stream = newQueryStream(w)
+	}
+	results, response := s.execute(run)
+	s.finish(run, results)
+	if run.stream != nil {
+		run.stream.Done(run.ID, response)
+		return
+	}
+	if err := resultsError(results); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if detail == "inline" {
+		finished, _ := s.runs.Get(run.ID)
+		response = detailedResults(finished)
+	}
 	enc := json.NewEncoder(w)
-	err := enc.Encode(results)
+	err := enc.Encode(response)
 	if err != nil {
-		fmt.Printf("writing results: %v to responsewriter: %v", results, err)
+		logError(run, "writing results: %v to responsewriter: %v", results, err)
 	}
 }
 
-func getQuerySet(qname string) QuerySet {
-	var qs QuerySet
-	switch qname {
-	case "1.1":
-		years := []int{1993}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_year", rowID=%d),
-		Range(frame="lo_discount", lo_discount >= 1),
-		Range(frame="lo_discount", lo_discount <= 3),
-		Range(frame="lo_quantity", lo_quantity < 25)
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.2":
-		years := []int{1994}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_month", rowID=0),
-		Bitmap(frame="lo_year", rowID=%d),
-		Range(frame="lo_discount", lo_discount >= 4),
-		Range(frame="lo_discount", lo_discount <= 6),
-		Range(frame="lo_quantity", lo_quantity >= 26),
-		Range(frame="lo_quantity", lo_quantity <= 35)
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.3":
-		years := []int{1994}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_weeknum", rowID=6),
-		Bitmap(frame="lo_year", rowID=%d),
-		Range(frame="lo_discount", lo_discount >= 5),
-		Range(frame="lo_discount", lo_discount <= 7),
-		Range(frame="lo_quantity", lo_quantity >= 26),
-		Range(frame="lo_quantity", lo_quantity <= 35)
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.1b":
-		years := []int{1993}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_year", rowID=%d),
-		Union(
-			Bitmap(frame=lo_discount_b, rowID=1),
-			Bitmap(frame=lo_discount_b, rowID=2),
-			Bitmap(frame=lo_discount_b, rowID=3)),
-		Union(
-			Bitmap(frame=lo_quantity_b, rowID=1),
-			Bitmap(frame=lo_quantity_b, rowID=2),
-			Bitmap(frame=lo_quantity_b, rowID=3),
-			Bitmap(frame=lo_quantity_b, rowID=4),
-			Bitmap(frame=lo_quantity_b, rowID=5),
-			Bitmap(frame=lo_quantity_b, rowID=6),
-			Bitmap(frame=lo_quantity_b, rowID=7),
-			Bitmap(frame=lo_quantity_b, rowID=8),
-			Bitmap(frame=lo_quantity_b, rowID=9),
-			Bitmap(frame=lo_quantity_b, rowID=10),
-			Bitmap(frame=lo_quantity_b, rowID=11),
-			Bitmap(frame=lo_quantity_b, rowID=12),
-			Bitmap(frame=lo_quantity_b, rowID=13),
-			Bitmap(frame=lo_quantity_b, rowID=14),
-			Bitmap(frame=lo_quantity_b, rowID=15),
-			Bitmap(frame=lo_quantity_b, rowID=16),
-			Bitmap(frame=lo_quantity_b, rowID=17),
-			Bitmap(frame=lo_quantity_b, rowID=18),
-			Bitmap(frame=lo_quantity_b, rowID=19),
-			Bitmap(frame=lo_quantity_b, rowID=20),
-			Bitmap(frame=lo_quantity_b, rowID=21),
-			Bitmap(frame=lo_quantity_b, rowID=22),
-			Bitmap(frame=lo_quantity_b, rowID=23),
-			Bitmap(frame=lo_quantity_b, rowID=24))
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.2b":
-		years := []int{1994}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_month", rowID=0),
-		Bitmap(frame="lo_year", rowID=%d),
-		Union(
-			Bitmap(frame=lo_discount_b, rowID=4),
-			Bitmap(frame=lo_discount_b, rowID=5),
-			Bitmap(frame=lo_discount_b, rowID=6)),
-		Union(
-			Bitmap(frame=lo_quantity_b, rowID=26),
-			Bitmap(frame=lo_quantity_b, rowID=27),
-			Bitmap(frame=lo_quantity_b, rowID=28),
-			Bitmap(frame=lo_quantity_b, rowID=29),
-			Bitmap(frame=lo_quantity_b, rowID=30),
-			Bitmap(frame=lo_quantity_b, rowID=31),
-			Bitmap(frame=lo_quantity_b, rowID=32),
-			Bitmap(frame=lo_quantity_b, rowID=33),
-			Bitmap(frame=lo_quantity_b, rowID=34),
-			Bitmap(frame=lo_quantity_b, rowID=35),
-			Bitmap(frame=lo_quantity_b, rowID=36))
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.3b":
-		years := []int{1994}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_weeknum", rowID=6),
-		Bitmap(frame="lo_year", rowID=%d),
-		Union(
-			Bitmap(frame=lo_discount_b, rowID=5),
-			Bitmap(frame=lo_discount_b, rowID=6),
-			Bitmap(frame=lo_discount_b, rowID=7)),
-		Union(
-			Bitmap(frame=lo_quantity_b, rowID=26),
-			Bitmap(frame=lo_quantity_b, rowID=27),
-			Bitmap(frame=lo_quantity_b, rowID=28),
-			Bitmap(frame=lo_quantity_b, rowID=29),
-			Bitmap(frame=lo_quantity_b, rowID=30),
-			Bitmap(frame=lo_quantity_b, rowID=31),
-			Bitmap(frame=lo_quantity_b, rowID=32),
-			Bitmap(frame=lo_quantity_b, rowID=33),
-			Bitmap(frame=lo_quantity_b, rowID=34),
-			Bitmap(frame=lo_quantity_b, rowID=35),
-			Bitmap(frame=lo_quantity_b, rowID=36))
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.1c":
-		years := []int{1993}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_year", rowID=%d),
-		Range(frame="lo_discount", lo_discount >< [1,3]),
-		Range(frame="lo_quantity", lo_quantity < 25)
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.2c":
-		years := []int{1994}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_month", rowID=0),
-		Bitmap(frame="lo_year", rowID=%d),
-		Range(frame="lo_discount", lo_discount >< [4,6]),
-		Range(frame="lo_quantity", lo_quantity >< [26,35]),
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "1.3c":
-		years := []int{1994}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_weeknum", rowID=6),
-		Bitmap(frame="lo_year", rowID=%d),
-		Range(frame="lo_discount", lo_discount >< [5,7]),
-		Range(frame="lo_quantity", lo_quantity >< [26,35]),
-	),
-frame="lo_revenue_computed", field="lo_revenue_computed")`,
-			[][]int{years},
-		)
-
-	case "2.1":
-		years := arange(1992, 1999, 1) // all years
-		brands := arange(40, 80, 1)    // brands for the second manufacturer, "MFGR#12"
-		// regionID := 0  // America
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_brand1", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-		Bitmap(frame="s_region", rowID=0),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{brands, years},
-		)
-
-	case "2.1r":
-		years := arange(1992, 1999, 1) // all years
-		brands := arange(40, 80, 1)    // brands for the second manufacturer, "MFGR#12"
-		// regionID := 0  // America
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_brand1", rowID=%d),
-		IntersectReg(
-			Bitmap(frame="lo_year", rowID=%d),
-			Bitmap(frame="s_region", rowID=0),
-		),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{brands, years},
-		)
-
-	case "2.2":
-		years := arange(1992, 1999, 1) // all years
-		brands := arange(260, 268, 1)  // brands between MFGR#2221 and MFGR#2228 - 7th manufacturer, brands 20-27, 40*(7-1) + [20..27]
-		// regionID := 2  // Asia
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_brand1", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-		Bitmap(frame="s_region", rowID=2),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{brands, years},
-		)
-
-	case "2.3":
-		years := arange(1992, 1999, 1) // all years
-		// brands := 260               // MFGR#2221
-		// regionID := 3               // Europe
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_year", rowID=%d),
-		Bitmap(frame="p_brand1", rowID=260),
-		Bitmap(frame="s_region", rowID=3),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{years},
-		)
-
-	case "3.1":
-		years := arange(1992, 1998, 1)
-		nations := arange(10, 15, 1) // asia nations
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_nation", rowID=%d),
-		Bitmap(frame="s_nation", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{nations, nations, years},
-		)
-
-	case "3.1r":
-		years := arange(1992, 1998, 1)
-		nations := arange(10, 15, 1) // asia nations
-
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_year", rowID=%d),
-		IntersectReg(
-			Bitmap(frame="c_nation", rowID=%d),
-			Bitmap(frame="s_nation", rowID=%d),
-		),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{years, nations, nations},
-		)
-
-	case "3.2":
-		years := arange(1992, 1998, 1)
-		nationID := nations["UNITED STATES"]
-		cities := arange(nationID*10, nationID*10+10, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_city", rowID=%d),
-		Bitmap(frame="s_city", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{cities, cities, years},
-		)
-
-	case "3.2r":
-		years := arange(1992, 1998, 1)
-		nationID := nations["UNITED STATES"]
-		cities := arange(nationID*10, nationID*10+10, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="lo_year", rowID=%d),
-		IntersectReg(
-			Bitmap(frame="c_city", rowID=%d),
-			Bitmap(frame="s_city", rowID=%d),
-		),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{years, cities, cities},
-		)
-
-	case "3.3":
-		years := arange(1992, 1998, 1)
-		cities := []int{181, 185}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_city", rowID=%d),
-		Bitmap(frame="s_city", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{cities, cities, years},
-		)
-
-	case "3.4":
-		cities := []int{181, 185}
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_city", rowID=%d),
-		Bitmap(frame="s_city", rowID=%d),
-		Bitmap(frame="lo_month", rowID=11),
-		Bitmap(frame="lo_year", rowID=1997),
-	),
-	frame="lo_revenue", field="lo_revenue")`,
-			[][]int{cities, cities},
-		)
-
-	case "4.1":
-		years := arange(1992, 1999, 1)
-		nations := arange(0, 5, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_nation", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-		Bitmap(frame="s_region", rowID=0),
-		Union(
-			Bitmap(frame="p_mfgr", rowID=1),
-			Bitmap(frame="p_mfgr", rowID=2),
-		)
-	),
-	frame="lo_profit", field="lo_profit")`,
-			[][]int{nations, years},
-		)
-
-	case "4.1r":
-		years := arange(1992, 1999, 1)
-		nations := arange(0, 5, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_nation", rowID=%d),
-		IntersectReg(
-			Bitmap(frame="lo_year", rowID=%d),
-			Bitmap(frame="s_region", rowID=0),
-			Union(
-				Bitmap(frame="p_mfgr", rowID=1),
-				Bitmap(frame="p_mfgr", rowID=2),
-			)
-		)
-	),
-	frame="lo_profit", field="lo_profit")`,
-			[][]int{nations, years},
-		)
-
-	case "4.1rb":
-		years := arange(1992, 1999, 1)
-		nations := arange(0, 5, 1)
-		qs = NewRegisterQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="c_nation", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-		Load(id=123)),
-	frame=lo_profit, field=lo_profit)`,
-			`Store(
-	Intersect(
-		Bitmap(frame="s_region", rowID=0),
-		Union(
-			Bitmap(frame="p_mfgr", rowID=1),
-			Bitmap(frame="p_mfgr", rowID=2),
-		)), id=41)`,
-			`Purge(id=41)`,
-			[][]int{nations, years},
-		)
-
-	case "4.2":
-		years := []int{1997, 1998}
-		nations := arange(0, 5, 1)
-		categories := arange(0, 10, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_category", rowID=%d),
-		Bitmap(frame="s_nation", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-		Bitmap(frame="c_region", rowID=0),
-	),
-frame="lo_profit", field="lo_profit")`,
-			[][]int{categories, nations, years},
-		)
-
-	case "4.2r":
-		years := []int{1997, 1998}
-		nations := arange(0, 5, 1)
-		categories := arange(0, 10, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_category", rowID=%d),
-		IntersectReg(
-			Bitmap(frame="s_nation", rowID=%d),
-			Bitmap(frame="lo_year", rowID=%d),
-			Bitmap(frame="c_region", rowID=0),
-		),
-	),
-frame="lo_profit", field="lo_profit")`,
-			[][]int{categories, nations, years},
-		)
-
-	case "4.3":
-		years := []int{1997, 1998}
-		cities := arange(30, 40, 1)
-		brands := arange(120, 160, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_brand1", rowID=%d),
-		Bitmap(frame="s_city", rowID=%d),
-		Bitmap(frame="lo_year", rowID=%d),
-		Bitmap(frame="c_region", rowID=0),
-	),
-frame="lo_profit", field="lo_profit")`,
-			[][]int{brands, cities, years},
-		)
-
-	case "4.3r":
-		years := []int{1997, 1998}
-		cities := arange(30, 40, 1)
-		brands := arange(120, 160, 1)
-		qs = NewQuerySet(
-			qname,
-			`Sum(
-	Intersect(
-		Bitmap(frame="p_brand1", rowID=%d),
-		IntersectReg(
-			Bitmap(frame="lo_year", rowID=%d),
-			Bitmap(frame="s_city", rowID=%d),
-			Bitmap(frame="c_region", rowID=0),
-		),
-	),
-frame="lo_profit", field="lo_profit")`,
-			[][]int{brands, years, cities},
-		)
+// execute runs the benchmark a run describes, recording results in it: a query
+// set once, over a grid or grouped, a register query set, an ad-hoc query set,
+// the SSB suite, every query set, or a batching experiment. It returns the
+// results, and the response for the run's type, which for the SSB suite is
+// its Scorecard, for every query set its SuiteReport, for the batching
+// experiment its BatchingReport, for grouped runs their GroupedResults, and
+// for repeated runs their RepeatedResults.
+func (s *Server) execute(run *Run) ([]BenchmarkResult, interface{}) {
+	var results []BenchmarkResult
+	s.runs.SetTotal(run, expectedQueries(run))
+	switch run.Type {
+	case "query":
+		results = s.runRepeated(run, sampleFor(run, s.onIndex(run, getQuerySet(run.Query))), run.Concurrency, run.BatchSize, repeatsFor(run))
+	case "grouped":
+		qs := sampleFor(run, s.onIndex(run, getQuerySet(run.Query)))
+		results = s.runRepeated(run, qs, run.Concurrency, run.BatchSize, repeatsFor(run))
+		s.verifyRun(run, results)
+		return results, s.groupedResults(run, qs, results)
+	case "grid":
+		results = s.RunGrid(run, sampleFor(run, s.onIndex(run, getQuerySet(run.Query))))
+	case "adhoc":
+		qs, ok := s.adHocQuerySet(run)
+		if !ok {
+			logError(run, "unknown ad-hoc query set %v\n", run.Query)
+			return nil, nil
+		}
+		results = s.runRepeated(run, sampleFor(run, s.onIndex(run, qs)), run.Concurrency, run.BatchSize, repeatsFor(run))
+	case "register":
+		qs := sampleFor(run, s.onIndex(run, getQuerySet(run.Query)))
+		for n := 0; n < repeatsFor(run); n++ {
+			results = append(results, s.RunSumMultiBatchRegister(run, qs, run.Concurrency, run.BatchSize))
+		}
+	case "scorecard":
+		sc, err := s.RunSuite(run, strings.TrimPrefix(run.Query, "ssb/"), run.Concurrency, run.BatchSize)
+		if err != nil {
+			logf(run, "%v\n", err)
+			return nil, nil
+		}
+		return sc.Queries, sc
+	case "batching":
+		report, results := s.RunBatching(run, batchingQueries(run.Query), run.Concurrency, run.BatchSize)
+		return results, report
+	case "suite":
+		report := s.RunAll(run)
+		return report.QuerySets, report
+	}
+	s.verifyRun(run, results)
+	if repeats := repeatsFor(run); repeats > 1 {
+		return results, repeatedResults(results, repeats)
+	}
+	return results, results
+}
 
+// HandleRepeatLast re-executes the most recent run with the same
+// configuration as a new run. The concurrency and batchsize parameters
+// override the original's, e.g. POST /runs/last/repeat?concurrency=64, and
+// are bounded as for ad-hoc query sets.
+func (s *Server) HandleRepeatLast(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(w, r)
+	last, ok := s.runs.Last()
+	if !ok {
+		s.reject(w, "repeat", "last", requestID, newAPIError(http.StatusNotFound, errNotFound, "no runs yet"))
+		return
+	}
+	concurrency, batchSize := last.Concurrency, last.BatchSize
+	for key, limit := range map[string]struct {
+		value *int
+		max   int
+	}{"concurrency": {&concurrency, maxAdHocConcurrency}, "batchsize": {&batchSize, maxAdHocBatchSize}} {
+		v := r.URL.Query().Get(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > limit.max {
+			s.reject(w, last.Type, last.Query, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "invalid %v: %q, want 1 to %d", key, v, limit.max))
+			return
+		}
+		*limit.value = n
 	}
 
-	return qs
+	run := s.runs.Start(last.Type, last.Query, requestID, concurrency, batchSize)
+	defer s.failOnPanic(run)
+	run.Warmup, run.Repeats, run.Sort, run.Verify = last.Warmup, last.Repeats, last.Sort, last.Verify
+	run.Index, run.Sample, run.Shuffle, run.Seed = last.Index, last.Sample, last.Shuffle, last.Seed
+	run.adHoc = last.adHoc
+	logf(run, "repeating run %v as %v\n", last.ID, run.ID)
+	w.Header().Set("X-Run-ID", run.ID)
+	results, response := s.execute(run)
+	s.finish(run, results)
+	if err := resultsError(results); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logError(run, "writing results: %v\n", err)
+	}
+}
+
+// getQuerySet returns the named query set from the catalog, or a zero
+// QuerySet if there is none.
+func getQuerySet(qname string) QuerySet {
+	return queries.Get(qname)
 }
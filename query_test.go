@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuerySetValidate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		qs      QuerySet
+		wantErr string
+	}{
+		{
+			name: "positional",
+			qs:   NewQuerySet("ok", `Count(Intersect(Bitmap(frame="a", rowID=%d), Bitmap(frame="b", rowID=%d)))`, [][]int{{1}, {2, 3}}),
+		},
+		{
+			name: "named",
+			qs: NewRegisterQuerySet("ok", `Count(Intersect(Bitmap(frame="a", rowID=%d), Register(id={{brand}})))`,
+				[]string{`Store(Bitmap(frame="b", rowID={{brand}}), id={{brand}})`}, []string{`Purge(id={{brand}})`},
+				[]string{"", "brand"}, [][]int{{1}, {2, 3}}),
+		},
+		{
+			name: "escaped percent",
+			qs:   NewQuerySet("ok", `Count(Bitmap(frame="a%%", rowID=%d))`, [][]int{{1}}),
+		},
+		{
+			name:    "empty argset",
+			qs:      NewQuerySet("bad", `Count(Bitmap(frame="a", rowID=%d))`, [][]int{{}}),
+			wantErr: "argset 0 is empty",
+		},
+		{
+			name:    "other verb",
+			qs:      NewQuerySet("bad", `Count(Bitmap(frame="%s", rowID=%d))`, [][]int{{1}}),
+			wantErr: "format has %s; only %d placeholders are supported",
+		},
+		{
+			name:    "too few placeholders",
+			qs:      NewQuerySet("bad", `Count(Bitmap(frame="a", rowID=%d))`, [][]int{{1}, {2}}),
+			wantErr: "format has 1 placeholders for 2 positional argsets",
+		},
+		{
+			name: "unknown reference",
+			qs: NewRegisterQuerySet("bad", `Count(Register(id={{brand}}))`,
+				[]string{`Store(Bitmap(frame="b", rowID={{nation}}), id={{brand}})`}, nil,
+				[]string{"brand"}, [][]int{{1}}),
+			wantErr: "{{nation}} is not a named argset",
+		},
+		{
+			name: "unused name",
+			qs: NewRegisterQuerySet("bad", `Count(Bitmap(frame="a", rowID=%d))`,
+				[]string{`Store(Bitmap(frame="b", rowID={{brand}}), id={{brand}})`}, nil,
+				[]string{"", "brand"}, [][]int{{1}, {2}}),
+			wantErr: "named argset brand is not used in the format",
+		},
+		{
+			name: "name used twice",
+			qs: NewRegisterQuerySet("bad", `Count(Intersect(Register(id={{brand}}), Register(id={{brand}})))`, nil, nil,
+				[]string{"brand", "brand"}, [][]int{{1}, {2}}),
+			wantErr: "argset name brand is used twice",
+		},
+		{
+			name: "too many names",
+			qs: NewRegisterQuerySet("bad", `Count(Register(id={{brand}}))`, nil, nil,
+				[]string{"brand", "nation"}, [][]int{{1}}),
+			wantErr: "2 argnames for 1 argsets",
+		},
+	} {
+		err := test.qs.Validate()
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestAdHocQuerySet(t *testing.T) {
+	const count = `Count(Bitmap(frame="lo_year", rowID={{lo_year}}))`
+	for _, test := range []struct {
+		name    string
+		a       AdHocQuerySet
+		wantErr string
+	}{
+		{
+			name: "read-only",
+			a: AdHocQuerySet{Name: "ok", Format: `Sum(Intersect(Bitmap(frame="lo_year", rowID={{lo_year}}), {{unionRows "lo_quantity_b" 1 24}}), frame="lo_revenue", field="lo_revenue")`,
+				ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+		},
+		{
+			name: "positional",
+			a:    AdHocQuerySet{Name: "ok", Format: `Count(Difference(Bitmap(frame="lo_year", rowID=%d), Range(frame="lo_quantity", lo_quantity < 25)))`, ArgSets: [][]int{{1993}}},
+		},
+		{
+			name:    "write call",
+			a:       AdHocQuerySet{Name: "bad", Format: `SetBit(frame="lo_year", rowID={{lo_year}}, columnID=1)`, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "SetBit is not allowed",
+		},
+		{
+			name:    "write call nested",
+			a:       AdHocQuerySet{Name: "bad", Format: `Count(Store(Bitmap(frame="lo_year", rowID={{lo_year}}), id=1))`, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "Store is not allowed",
+		},
+		{
+			name:    "write call with space",
+			a:       AdHocQuerySet{Name: "bad", Format: "ClearBit (frame=\"lo_year\", rowID={{lo_year}}, columnID=1)", ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "ClearBit is not allowed",
+		},
+		{
+			name:    "write call with newline",
+			a:       AdHocQuerySet{Name: "bad", Format: "Purge\n(id={{lo_year}})", ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "Purge is not allowed",
+		},
+		{
+			name:    "write call in a string",
+			a:       AdHocQuerySet{Name: "bad", Format: `Count(Bitmap(frame="x) SetBit(frame=lo_year", rowID={{lo_year}}))`, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "SetBit is not allowed",
+		},
+		{
+			name:    "write call in a template argument",
+			a:       AdHocQuerySet{Name: "bad", Format: `Count(Intersect(Bitmap(frame="lo_year", rowID={{lo_year}}), {{unionRows "SetBit(frame=lo_year" 1 2}}))`, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "not a bucket frame",
+		},
+		{
+			name:    "other verb",
+			a:       AdHocQuerySet{Name: "bad", Format: `Count(Bitmap(frame="lo_year", rowID=%c))`, ArgSets: [][]int{{1993}}},
+			wantErr: "format has %c",
+		},
+		{
+			name:    "name with ..",
+			a:       AdHocQuerySet{Name: "a..b", Format: count, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "invalid name",
+		},
+		{
+			name:    "name with /",
+			a:       AdHocQuerySet{Name: "a/b", Format: count, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}},
+			wantErr: "invalid name",
+		},
+		{
+			name:    "concurrency",
+			a:       AdHocQuerySet{Name: "bad", Format: count, ArgNames: []string{"lo_year"}, ArgSets: [][]int{{1993}}, Concurrency: maxAdHocConcurrency + 1},
+			wantErr: "concurrency must be",
+		},
+	} {
+		_, err := test.a.QuerySet()
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestQuerySetCheckFrames(t *testing.T) {
+	qs := NewQuerySet("test", `Count(Intersect(Bitmap(frame="lo_year", rowID=%d), Bitmap(frame=p_brand1, rowID=%d)))`, [][]int{{1}, {2}})
+	live := map[string]map[string]interface{}{"lo_year": {}, "p_brand1": {}}
+	if err := qs.CheckFrames(live); err != nil {
+		t.Errorf("with every frame: %v", err)
+	}
+	if err := qs.CheckFrames(nil); err != nil {
+		t.Errorf("without a schema: %v", err)
+	}
+	delete(live, "p_brand1")
+	if err := qs.CheckFrames(live); err == nil || err.Error() != "unknown frame p_brand1" {
+		t.Errorf("without p_brand1: got error %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import "math"
+
+// maxRepeats bounds the repeats parameter.
+const maxRepeats = 100
+
+// RepeatedResult aggregates repeated runs of one configuration of a query
+// set, so configurations can be compared despite run-to-run noise. The
+// statistics are over the runs that succeeded; StdDevSeconds is the sample
+// standard deviation.
+type RepeatedResult struct {
+	Name          string            `json:"name"`
+	Concurrency   int               `json:"concurrency"`
+	BatchSize     int               `json:"batchsize"`
+	Repeats       int               `json:"repeats"`
+	Failed        int               `json:"failed,omitempty"`
+	MeanSeconds   float64           `json:"meanseconds"`
+	StdDevSeconds float64           `json:"stddevseconds"`
+	BestSeconds   float64           `json:"bestseconds"`
+	WorstSeconds  float64           `json:"worstseconds"`
+	MeanQPS       float64           `json:"meanqps"`
+	Runs          []BenchmarkResult `json:"runs"`
+}
+
+// repeatsFor returns how many times run repeats each configuration.
+func repeatsFor(run *Run) int {
+	if run == nil || run.Repeats < 1 {
+		return 1
+	}
+	return run.Repeats
+}
+
+// runRepeated runs qs the given number of times, recording each in run
+// unless it is nil.
+func (s *Server) runRepeated(run *Run, qs QuerySet, concurrency, batchSize, repeats int) []BenchmarkResult {
+	results := make([]BenchmarkResult, 0, repeats)
+	for n := 0; n < repeats; n++ {
+		results = append(results, s.runRecorded(run, qs, concurrency, batchSize))
+	}
+	return results
+}
+
+// summarizeRepeats aggregates the repeated runs of one configuration.
+func summarizeRepeats(runs []BenchmarkResult) RepeatedResult {
+	rr := RepeatedResult{Repeats: len(runs), Runs: runs}
+	if len(runs) > 0 {
+		rr.Name, rr.Concurrency, rr.BatchSize = runs[0].Name, runs[0].Concurrency, runs[0].BatchSize
+	}
+	var seconds, qps []float64
+	for _, br := range runs {
+		if br.Error != nil {
+			rr.Failed++
+			continue
+		}
+		seconds = append(seconds, br.Seconds)
+		qps = append(qps, br.QPS)
+	}
+	if len(seconds) == 0 {
+		return rr
+	}
+	var variance float64
+	rr.MeanSeconds, variance = mean(seconds)
+	rr.StdDevSeconds = math.Sqrt(variance)
+	rr.MeanQPS, _ = mean(qps)
+	rr.BestSeconds, rr.WorstSeconds = seconds[0], seconds[0]
+	for _, sec := range seconds {
+		rr.BestSeconds = math.Min(rr.BestSeconds, sec)
+		rr.WorstSeconds = math.Max(rr.WorstSeconds, sec)
+	}
+	return rr
+}
+
+// repeatedResults groups results, each configuration repeated the given
+// number of times in a row, and aggregates each group.
+func repeatedResults(results []BenchmarkResult, repeats int) []RepeatedResult {
+	var grouped []RepeatedResult
+	for start := 0; start < len(results); start += repeats {
+		end := start + repeats
+		if end > len(results) {
+			end = len(results)
+		}
+		grouped = append(grouped, summarizeRepeats(results[start:end]))
+	}
+	return grouped
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ResultsFile describes a per-query results file in the results directory.
+// URL is where to download it.
+type ResultsFile struct {
+	Name     string    `json:"name"`
+	Query    string    `json:"query"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	URL      string    `json:"url"`
+}
+
+// ResultsFiles lists the results files in the results directory, newest
+// first, of the named query set or, if query is empty, of all of them.
+func (s *Server) ResultsFiles(query string) ([]ResultsFile, error) {
+	infos, err := ioutil.ReadDir(s.resultsDir)
+	if os.IsNotExist(err) {
+		return []ResultsFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files := []ResultsFile{}
+	for _, fi := range infos {
+		m := resultsFileName.FindStringSubmatch(fi.Name())
+		if m == nil || !fi.Mode().IsRegular() || query != "" && m[1] != query {
+			continue
+		}
+		files = append(files, ResultsFile{
+			Name:     fi.Name(),
+			Query:    m[1],
+			Size:     fi.Size(),
+			Modified: fi.ModTime().UTC(),
+			URL:      "/results/" + fi.Name(),
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	return files, nil
+}
+
+// HandleResultsFiles lists the results files, optionally of one query set,
+// e.g. /results/files?query=2.1
+func (s *Server) HandleResultsFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := s.ResultsFiles(r.URL.Query().Get("query"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "listing results files: %v", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		logError(nil, "writing results files: %v\n", err)
+	}
+}
+
+// HandleResultsFile serves a results file for download, e.g.
+// curl -O localhost:8000/results/2.1-1514764800.txt
+// Range requests are supported, so large files can be fetched in parts.
+func (s *Server) HandleResultsFile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	path, apiErr := s.resultsFilePath(name)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "no results file %v", name))
+		return
+	} else if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "opening %v: %v", name, err))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "opening %v: %v", name, err))
+		return
+	}
+	contentType := "text/plain; charset=utf-8"
+	switch filepath.Ext(name) {
+	case ".jsonl":
+		contentType = "application/x-ndjson"
+	case ".gz":
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
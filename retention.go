@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// resultsFileName matches the name of a results file, as created by
+// createResultsFile, capturing the query set: NAME-TIMESTAMP[-TAG].EXT[.gz].
+var resultsFileName = regexp.MustCompile(`^(.+?)-\d{9,}(?:-.*)?\.(?:txt|jsonl)(?:\.gz)?$`)
+
+// resultsRetention bounds the results files kept in the results directory:
+// the newest keep of each query set, and none older than maxAge. Zero values
+// don't limit.
+type resultsRetention struct {
+	keep   int
+	maxAge time.Duration
+}
+
+// parseAge parses a duration, allowing days, e.g. "30d", as well as
+// time.ParseDuration's units.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var d time.Duration
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid age %q, want e.g. 30d or 12h", s)
+		}
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative age %q", s)
+	}
+	return d, nil
+}
+
+// expired returns the results files in dir the retention policy drops.
+func (rr resultsRetention) expired(dir string, now time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	byQuery := make(map[string][]os.FileInfo)
+	for _, fi := range infos {
+		if m := resultsFileName.FindStringSubmatch(fi.Name()); m != nil && fi.Mode().IsRegular() {
+			byQuery[m[1]] = append(byQuery[m[1]], fi)
+		}
+	}
+	var expired []string
+	for _, files := range byQuery {
+		sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+		for n, fi := range files {
+			if rr.keep > 0 && n >= rr.keep || rr.maxAge > 0 && now.Sub(fi.ModTime()) > rr.maxAge {
+				expired = append(expired, filepath.Join(dir, fi.Name()))
+			}
+		}
+	}
+	sort.Strings(expired)
+	return expired, nil
+}
+
+// SweepResults removes the results files the retention policy drops, returning
+// how many it removed.
+func (s *Server) SweepResults() (int, error) {
+	expired, err := s.retention.expired(s.resultsDir, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("listing results: %v", err)
+	}
+	removed := 0
+	for _, path := range expired {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// StartResultsSweeper sweeps the results directory now and then periodically.
+func (s *Server) StartResultsSweeper(interval time.Duration) {
+	sweep := func() {
+		removed, err := s.SweepResults()
+		if err != nil {
+			logWarn(nil, "sweeping results: %v\n", err)
+		} else if removed > 0 {
+			logf(nil, "removed %d expired results files from %v\n", removed, s.resultsDir)
+		}
+	}
+	sweep()
+	go func() {
+		for range time.Tick(interval) {
+			sweep()
+		}
+	}()
+}
+
+// resultsFilePath returns the path of the named results file in the results
+// directory, or an error if name isn't that of a results file.
+func (s *Server) resultsFilePath(name string) (string, *APIError) {
+	if filepath.Base(name) != name || !resultsFileName.MatchString(name) {
+		return "", newAPIError(http.StatusBadRequest, errBadRequest, "%q is not a results file name", name)
+	}
+	return filepath.Join(s.resultsDir, name), nil
+}
+
+// HandleDeleteResults removes a results file from the results directory, e.g.
+// curl -X DELETE -H "Authorization: Bearer $TOKEN" localhost:8000/results/2.1-1514764800.txt
+// It is registered behind requireAdmin.
+func (s *Server) HandleDeleteResults(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	path, apiErr := s.resultsFilePath(name)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "no results file %v", name))
+		return
+	} else if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "removing %v: %v", name, err))
+		return
+	}
+	logf(nil, "removed results file %v\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
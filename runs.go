@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxRuns is the number of runs kept in memory; older runs are dropped.
+const maxRuns = 1000
+
+// Run records one benchmark request and its results.
+type Run struct {
+	ID          string            `json:"id"`
+	RequestID   string            `json:"requestid"`
+	Type        string            `json:"type"`
+	Query       string            `json:"query"`
+	Concurrency int               `json:"concurrency"`
+	BatchSize   int               `json:"batchsize"`
+	Warmup      string            `json:"warmup,omitempty"`
+	Repeats     int               `json:"repeats,omitempty"`
+	Sort        string            `json:"sort,omitempty"`
+	Verify      string            `json:"verify,omitempty"`
+	Index       string            `json:"index,omitempty"`
+	Sample      int               `json:"sample,omitempty"`
+	Shuffle     bool              `json:"shuffle,omitempty"`
+	Seed        int64             `json:"seed,omitempty"`
+	Status      string            `json:"status"`
+	Started     time.Time         `json:"started"`
+	Finished    *time.Time        `json:"finished,omitempty"`
+	Heartbeat   *time.Time        `json:"heartbeat,omitempty"`
+	Dimensions  []string          `json:"dimensions,omitempty"`
+	Results     []BenchmarkResult `json:"results"`
+	Diagnostic  *Diagnostic       `json:"diagnostic,omitempty"`
+
+	// cancel is closed by the watchdog to stop a stalled run.
+	cancel chan struct{}
+
+	// stream, if set, receives results as they arrive, for detail=full.
+	stream *queryStream
+
+	// adHoc is the query set an adhoc run executes. Runs repeated or resumed
+	// without it look the set up by name among the saved ad-hoc query sets.
+	adHoc *QuerySet
+
+	// completed counts the queries completed so far, of total expected (0 if
+	// unknown), and last is the most recent BenchmarkResult, for progress
+	// reports.
+	completed int
+	total     int
+	last      *BenchmarkResult
+
+	// records holds per-query results, served separately by /runs/{id}/results.
+	records []QueryRecord
+	sets    int
+	// setDimensions names the inputs of each set's records, since the query
+	// sets of e.g. a suite run have different dimensions. Dimensions holds
+	// only the last set's.
+	setDimensions [][]string
+
+	// body and etag cache the serialized run once it is done.
+	body []byte
+	etag string
+}
+
+// QueryRecord is the result of one query in a run. Set is the index of the
+// BenchmarkResult it belongs to, for runs such as grids that hold several,
+// Batch is the ID of the batch request the query was sent in, and Seconds
+// that batch's latency.
+type QueryRecord struct {
+	Set     int           `json:"set"`
+	Batch   string        `json:"batch"`
+	Seconds float64       `json:"seconds,omitempty"`
+	Inputs  []interface{} `json:"inputs"`
+	Labels  []string      `json:"labels,omitempty"`
+	Output  interface{}   `json:"output"`
+	Display string        `json:"display,omitempty"`
+}
+
+// recordSink appends results to a run's per-query records.
+type recordSink struct {
+	rs  *runStore
+	run *Run
+	set int
+}
+
+func (rk recordSink) Write(res QueryResult) error {
+	rk.rs.mu.Lock()
+	defer rk.rs.mu.Unlock()
+	rk.run.records = append(rk.run.records, QueryRecord{Set: rk.set, Batch: res.batch, Seconds: res.latency.Seconds(), Inputs: res.inputs, Output: res.outputs[0]})
+	return nil
+}
+
+func (rk recordSink) Close() error { return nil }
+
+// runStore holds recent runs in memory.
+type runStore struct {
+	mu    sync.Mutex
+	seq   int
+	runs  map[string]*Run
+	order []string
+	// dir is the state directory runs are saved in, or "" to keep them in
+	// memory only.
+	dir string
+}
+
+func newRunStore() *runStore {
+	return &runStore{runs: make(map[string]*Run)}
+}
+
+// Start registers a new running Run and returns it. Without a request ID, the
+// run gets a fresh one, so every run's logs and results files can be found.
+func (rs *runStore) Start(rtype, query, requestID string, concurrency, batchSize int) *Run {
+	if requestID == "" {
+		requestID = newUUID()
+	}
+	rs.mu.Lock()
+	rs.seq++
+	now := time.Now()
+	run := &Run{
+		ID:          fmt.Sprintf("%d-%d", now.Unix(), rs.seq),
+		RequestID:   requestID,
+		Type:        rtype,
+		Query:       query,
+		Concurrency: concurrency,
+		BatchSize:   batchSize,
+		Status:      "running",
+		Started:     now,
+		cancel:      make(chan struct{}),
+	}
+	rs.runs[run.ID] = run
+	rs.order = append(rs.order, run.ID)
+	var trimmed []string
+	if len(rs.order) > maxRuns {
+		trimmed = append(trimmed, rs.order[0])
+		delete(rs.runs, rs.order[0])
+		rs.order = rs.order[1:]
+	}
+	body := rs.encode(run)
+	rs.mu.Unlock()
+
+	rs.save(run, body)
+	rs.remove(trimmed...)
+	return run
+}
+
+// Recorder returns a sink recording the results of one execution of qs in run.
+func (rs *runStore) Recorder(run *Run, qs QuerySet) ResultWriter {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	run.Dimensions = qs.Dimensions()
+	run.setDimensions = append(run.setDimensions, run.Dimensions)
+	run.sets++
+	return recordSink{rs, run, run.sets - 1}
+}
+
+// dimensionsOf returns the dimensions of the records of a run's set. Runs
+// saved without per-set dimensions fall back to Dimensions.
+func (run *Run) dimensionsOf(set int) []string {
+	if set >= 0 && set < len(run.setDimensions) {
+		return run.setDimensions[set]
+	}
+	return run.Dimensions
+}
+
+// hasDimension reports whether any of a run's sets has the dimension selected
+// by a filter key.
+func (run *Run) hasDimension(key string) bool {
+	if dimensionIndex(run.Dimensions, key) >= 0 {
+		return true
+	}
+	for _, dims := range run.setDimensions {
+		if dimensionIndex(dims, key) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dimensionIndex returns the index of the dimension selected by a filter key
+// in dims, or -1 if there is none.
+func dimensionIndex(dims []string, key string) int {
+	for k, name := range dims {
+		if matchesDimension(name, key) {
+			return k
+		}
+	}
+	return -1
+}
+
+// recordsBySet returns the per-query records of a run's first n results.
+func (rs *runStore) recordsBySet(run *Run, n int) [][]QueryRecord {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	bySet := make([][]QueryRecord, n)
+	for _, rec := range run.records {
+		if rec.Set < n {
+			bySet[rec.Set] = append(bySet[rec.Set], rec)
+		}
+	}
+	return bySet
+}
+
+// Beat records that a run made progress. A stalled run that makes progress is
+// running again. Beat on a nil run does nothing.
+func (rs *runStore) Beat(run *Run) {
+	if run == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	run.Heartbeat = &now
+	if run.Status == "stalled" {
+		run.Status = "running"
+	}
+}
+
+// Completed records that a query of the run completed, and beats.
+func (rs *runStore) Completed(run *Run) {
+	if run == nil {
+		return
+	}
+	rs.Beat(run)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	run.completed++
+}
+
+// Finish marks a run done with its results, and caches its serialized form.
+func (rs *runStore) Finish(run *Run, results []BenchmarkResult) {
+	rs.mu.Lock()
+	now := time.Now()
+	if run.Status != "cancelled" {
+		run.Status = "done"
+	}
+	run.Finished = &now
+	run.Results = results
+	rs.cache(run)
+	body := rs.encode(run)
+	rs.mu.Unlock()
+
+	rs.save(run, body)
+}
+
+// cache caches the serialized form of a finished run. The caller must hold
+// rs.mu.
+func (rs *runStore) cache(run *Run) {
+	body, err := json.Marshal(run)
+	if err != nil {
+		logError(run, "serializing run %v: %v\n", run.ID, err)
+		return
+	}
+	run.body = body
+	run.etag = fmt.Sprintf(`"%x"`, sha1.Sum(body))
+}
+
+// Last returns a copy of the most recently started run.
+func (rs *runStore) Last() (run Run, ok bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.order) == 0 {
+		return Run{}, false
+	}
+	return *rs.runs[rs.order[len(rs.order)-1]], true
+}
+
+// Get returns a copy of the run with the given ID.
+func (rs *runStore) Get(id string) (run Run, ok bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r, ok := rs.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return *r, true
+}
+
+// HandleRun serves a stored run. Completed runs carry an ETag and
+// Last-Modified header and honor conditional requests, so polling clients
+// get 304 Not Modified rather than the full payload.
+func (s *Server) HandleRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.runs.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "run not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if run.body == nil {
+		// Still running, so the representation changes between requests.
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := json.NewEncoder(w).Encode(run); err != nil {
+			logError(&run, "writing run %v: %v\n", run.ID, err)
+		}
+		return
+	}
+	w.Header().Set("ETag", run.etag)
+	http.ServeContent(w, r, "", *run.Finished, bytes.NewReader(run.body))
+}
+
+// maxPageSize bounds the number of records returned by one results request.
+const maxPageSize = 1000
+
+// ResultsPage is one page of a run's per-query records.
+type ResultsPage struct {
+	Total      int           `json:"total"`
+	Offset     int           `json:"offset"`
+	Limit      int           `json:"limit"`
+	Dimensions []string      `json:"dimensions"`
+	Records    []QueryRecord `json:"records"`
+}
+
+// matchesDimension reports whether a dimension named name is selected by a
+// filter key, which may omit the "lo_" prefix, e.g. year for lo_year.
+func matchesDimension(name, key string) bool {
+	return name == key || strings.TrimPrefix(name, "lo_") == key
+}
+
+// HandleRunResults serves a run's per-query records, paginated with limit and
+// offset, and filtered by set and by dimension values, e.g.
+// /runs/{id}/results?year=1994&limit=100&offset=200
+// With labels=true each record also carries the names of its inputs, translated
+// per lang or Accept-Language.
+func (s *Server) HandleRunResults(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.runs.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, errNotFound, "run not found"))
+		return
+	}
+
+	page := ResultsPage{Limit: 100, Dimensions: run.Dimensions, Records: []QueryRecord{}}
+	set := -1
+	labels := false
+	filters := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		var err error
+		switch key {
+		case "limit":
+			page.Limit, err = strconv.Atoi(values[0])
+			if page.Limit < 1 || page.Limit > maxPageSize {
+				err = fmt.Errorf("must be between 1 and %d", maxPageSize)
+			}
+		case "offset":
+			page.Offset, err = strconv.Atoi(values[0])
+			if page.Offset < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "set":
+			set, err = strconv.Atoi(values[0])
+		case "labels":
+			labels, err = strconv.ParseBool(values[0])
+		default:
+			if !run.hasDimension(key) {
+				err = fmt.Errorf("unknown dimension")
+			}
+			filters[key] = values[0]
+		}
+		if err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "invalid %v: %v", key, err))
+			return
+		}
+	}
+
+	for _, rec := range run.records {
+		if set >= 0 && rec.Set != set {
+			continue
+		}
+		// Filters match by name in each record's own set, which may lack the
+		// dimension or have it at another index.
+		dims := run.dimensionsOf(rec.Set)
+		matched := true
+		for key, value := range filters {
+			k := dimensionIndex(dims, key)
+			if k < 0 || k >= len(rec.Inputs) || fmt.Sprint(rec.Inputs[k]) != value {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if page.Total >= page.Offset && len(page.Records) < page.Limit {
+			if !s.valueFormat.IsRaw() {
+				rec.Display = s.valueFormat.Format(rec.Output)
+			}
+			page.Records = append(page.Records, rec)
+		}
+		page.Total++
+	}
+
+	if labels {
+		lang := s.translations.Lang(r)
+		if lang != "" {
+			w.Header().Set("Content-Language", lang)
+		}
+		for n, rec := range page.Records {
+			dims := run.dimensionsOf(rec.Set)
+			rec.Labels = make([]string, len(rec.Inputs))
+			for k, input := range rec.Inputs {
+				id, ok := input.(int)
+				if !ok || k >= len(dims) {
+					rec.Labels[k] = fmt.Sprint(input)
+					continue
+				}
+				rec.Labels[k] = s.translations.Translate(lang, s.Label(dims[k], id))
+			}
+			page.Records[n] = rec
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		logError(&run, "writing run %v results: %v\n", run.ID, err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Sample returns a copy of the query set that runs n of its queries, chosen
+// at random with seed from the full cartesian product of its argsets and run
+// in their usual order. The same seed picks the same queries. If n covers
+// every query the query set is returned as it is.
+func (s QuerySet) Sample(n int, seed int64) QuerySet {
+	total := s.iterations
+	if n <= 0 || n >= total {
+		return s
+	}
+	// Floyd's algorithm picks n distinct queries without enumerating them
+	// all.
+	rng := rand.New(rand.NewSource(seed))
+	picked := make(map[int]bool, n)
+	for j := total - n; j < total; j++ {
+		k := rng.Intn(j + 1)
+		if picked[k] {
+			k = j
+		}
+		picked[k] = true
+	}
+	s.sample = make([]int, 0, n)
+	for k := range picked {
+		s.sample = append(s.sample, k)
+	}
+	sort.Ints(s.sample)
+	s.sampleOf, s.seed = total, seed
+	s.iterations = n
+	return s
+}
+
+// Shuffle returns a copy of the query set that runs its queries in a random
+// order, given by seed, rather than in cartesian order, so queries sharing rows
+// don't reach Pilosa back to back and flatter its caches. A sampled query set
+// stays sampled.
+func (s QuerySet) Shuffle(seed int64) QuerySet {
+	rng := rand.New(rand.NewSource(seed))
+	shuffled := make([]int, s.iterations)
+	for n, k := range rng.Perm(s.iterations) {
+		shuffled[n] = s.queryIndex(k)
+	}
+	s.sample, s.shuffled, s.seed = shuffled, true, seed
+	return s
+}
+
+// queryIndex returns the position in the full query set of the nth query
+// run.
+func (s *QuerySet) queryIndex(n int) int {
+	if s.sample != nil {
+		return s.sample[n]
+	}
+	return n
+}
+
+// sampleFor samples and shuffles a query set as the run asks.
+func sampleFor(run *Run, qs QuerySet) QuerySet {
+	if run == nil {
+		return qs
+	}
+	if run.Sample > 0 {
+		qs = qs.Sample(run.Sample, run.Seed)
+	}
+	if run.Shuffle {
+		qs = qs.Shuffle(run.Seed)
+	}
+	return qs
+}
+
+// parseSample parses the sample, shuffle and seed query parameters. Without a
+// seed, one is chosen from the clock, and returned so the run records it.
+func parseSample(r *http.Request) (int, bool, int64, *APIError) {
+	q := r.URL.Query()
+	sample := 0
+	if v := q.Get("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, false, 0, newAPIError(http.StatusBadRequest, errBadRequest, "invalid sample %q, want a positive query count", v)
+		}
+		sample = n
+	}
+	shuffle := q.Get("shuffle")
+	if shuffle != "" && shuffle != "false" && shuffle != "true" {
+		return 0, false, 0, newAPIError(http.StatusBadRequest, errBadRequest, "invalid shuffle %q, want true or false", shuffle)
+	}
+	if sample == 0 && shuffle != "true" {
+		if q.Get("seed") != "" {
+			return 0, false, 0, newAPIError(http.StatusBadRequest, errBadRequest, "seed needs sample or shuffle")
+		}
+		return 0, false, 0, nil
+	}
+	seed := time.Now().UnixNano()
+	if v := q.Get("seed"); v != "" {
+		var err error
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, false, 0, newAPIError(http.StatusBadRequest, errBadRequest, "invalid seed %q", v)
+		}
+	}
+	return sample, shuffle == "true", seed, nil
+}
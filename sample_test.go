@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	qs := NewQuerySet("test", `Count(Intersect(Bitmap(frame="a", rowID=%d), Bitmap(frame="b", rowID=%d)))`, [][]int{arange(0, 10, 1), arange(0, 7, 1)})
+	for _, test := range []struct {
+		n    int
+		seed int64
+	}{
+		{1, 1},
+		{5, 1},
+		{5, 2},
+		{35, 42},
+		{69, 7},
+	} {
+		s := qs.Sample(test.n, test.seed)
+		if s.iterations != test.n || len(s.sample) != test.n {
+			t.Errorf("Sample(%d, %d) runs %d queries of %v, want %d", test.n, test.seed, s.iterations, s.sample, test.n)
+			continue
+		}
+		if !sort.IntsAreSorted(s.sample) {
+			t.Errorf("Sample(%d, %d) = %v, want them in order", test.n, test.seed, s.sample)
+		}
+		for n, k := range s.sample {
+			if k < 0 || k >= qs.iterations {
+				t.Errorf("Sample(%d, %d) picked query %d of %d", test.n, test.seed, k, qs.iterations)
+			}
+			if n > 0 && s.sample[n-1] == k {
+				t.Errorf("Sample(%d, %d) picked query %d twice", test.n, test.seed, k)
+			}
+		}
+		if again := qs.Sample(test.n, test.seed); !reflect.DeepEqual(again.sample, s.sample) {
+			t.Errorf("Sample(%d, %d) = %v, then %v", test.n, test.seed, s.sample, again.sample)
+		}
+		if s.sampleOf != qs.iterations || s.seed != test.seed {
+			t.Errorf("Sample(%d, %d) records sampleOf %d and seed %d", test.n, test.seed, s.sampleOf, s.seed)
+		}
+	}
+
+	if s := qs.Sample(5, 1); reflect.DeepEqual(s.sample, qs.Sample(5, 2).sample) {
+		t.Errorf("seeds 1 and 2 both picked %v", s.sample)
+	}
+	for _, n := range []int{0, -1, 70, 100} {
+		if s := qs.Sample(n, 1); s.sample != nil || s.iterations != qs.iterations {
+			t.Errorf("Sample(%d, 1) = %v, want the whole query set", n, s.sample)
+		}
+	}
+}
+
+func TestSampleCoversEveryQuery(t *testing.T) {
+	// Floyd's algorithm picks each query with equal probability, so over many
+	// seeds every query should be picked.
+	qs := NewQuerySet("test", `Count(Bitmap(frame="a", rowID=%d))`, [][]int{arange(0, 20, 1)})
+	counts := make([]int, qs.iterations)
+	for seed := int64(0); seed < 1000; seed++ {
+		for _, k := range qs.Sample(3, seed).sample {
+			counts[k]++
+		}
+	}
+	for k, count := range counts {
+		// Each query is expected 150 times.
+		if count < 75 || count > 225 {
+			t.Errorf("query %d picked %d times in 1000 samples of 3 of 20", k, count)
+		}
+	}
+}
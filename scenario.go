@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scenario is a scripted sequence of demo steps, loaded from a JSON file.
+//
+// Example:
+//
+//	{"name": "intro", "steps": [
+//		{"action": "note", "text": "Flight 1 filters on discount and quantity."},
+//		{"action": "query", "query": "1.1"},
+//		{"action": "query", "query": "1.1b"},
+//		{"action": "compare", "compare": [1, 2]},
+//		{"action": "breakdown", "query": "3.1", "by": "c_nation"},
+//		{"action": "grid", "query": "3.1"}
+//	]}
+type Scenario struct {
+	Name  string         `json:"name"`
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep is one action in a Scenario. Supported actions are note, count,
+// query, breakdown, grid, multi, edgecases, schema and compare. Concurrency and
+// BatchSize override the server defaults for query steps; By names the
+// dimension a breakdown totals by, its query set's first by default; Compare
+// holds the indexes of two earlier steps whose first results are compared.
+type ScenarioStep struct {
+	Title       string `json:"title,omitempty"`
+	Action      string `json:"action"`
+	Query       string `json:"query,omitempty"`
+	Sets        string `json:"sets,omitempty"`
+	By          string `json:"by,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	BatchSize   int    `json:"batchsize,omitempty"`
+	Compare     []int  `json:"compare,omitempty"`
+	Text        string `json:"text,omitempty"`
+}
+
+// StepReport is the narrated outcome of one ScenarioStep.
+type StepReport struct {
+	Title     string      `json:"title"`
+	Action    string      `json:"action"`
+	Narrative string      `json:"narrative"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+
+	first *BenchmarkResult
+}
+
+// ScenarioReport is the single narrative report produced by running a Scenario.
+type ScenarioReport struct {
+	Name    string       `json:"name"`
+	Steps   []StepReport `json:"steps"`
+	Seconds float64      `json:"seconds"`
+}
+
+// ReadScenario decodes a Scenario and checks its steps: their query sets must
+// be in the catalog, and their concurrency and batch sizes are bounded as for
+// ad-hoc query sets.
+func ReadScenario(r io.Reader) (Scenario, error) {
+	var sc Scenario
+	if err := json.NewDecoder(r).Decode(&sc); err != nil {
+		return sc, fmt.Errorf("decoding scenario: %v", err)
+	}
+	for n, step := range sc.Steps {
+		if step.Concurrency < 0 || step.Concurrency > maxAdHocConcurrency {
+			return sc, fmt.Errorf("step %d: concurrency must be 0 to %d", n, maxAdHocConcurrency)
+		}
+		if step.BatchSize < 0 || step.BatchSize > maxAdHocBatchSize {
+			return sc, fmt.Errorf("step %d: batchsize must be 0 to %d", n, maxAdHocBatchSize)
+		}
+		switch step.Action {
+		case "note", "count", "edgecases", "schema":
+		case "query", "breakdown", "grid":
+			if step.Query == "" {
+				return sc, fmt.Errorf("step %d: %v action needs a query", n, step.Action)
+			}
+			if getQuerySet(step.Query).Name == "" {
+				return sc, fmt.Errorf("step %d: unknown query set %q; see /queries", n, step.Query)
+			}
+		case "multi":
+			if step.Sets == "" {
+				return sc, fmt.Errorf("step %d: multi action needs sets", n)
+			}
+			var specs []MultiSpec
+			for _, spec := range strings.Split(step.Sets, ",") {
+				ms, err := parseMultiSpec(spec, 1, 1)
+				if err != nil {
+					return sc, fmt.Errorf("step %d: %v", n, err)
+				}
+				if getQuerySet(ms.Name).Name == "" {
+					return sc, fmt.Errorf("step %d: unknown query set %q; see /queries", n, ms.Name)
+				}
+				specs = append(specs, ms)
+			}
+			if err := checkMultiSpecs(specs); err != nil {
+				return sc, fmt.Errorf("step %d: %v", n, err)
+			}
+		case "compare":
+			if len(step.Compare) != 2 || step.Compare[0] >= n || step.Compare[1] >= n ||
+				step.Compare[0] < 0 || step.Compare[1] < 0 {
+				return sc, fmt.Errorf("step %d: compare needs two earlier steps", n)
+			}
+		default:
+			return sc, fmt.Errorf("step %d: unknown action %q", n, step.Action)
+		}
+	}
+	return sc, nil
+}
+
+// narrate describes a BenchmarkResult in one sentence.
+func narrate(br BenchmarkResult) string {
+	qps := 0.0
+	if br.Seconds > 0 {
+		qps = float64(br.Iterations) / br.Seconds
+	}
+	return fmt.Sprintf("Ran %v: %d queries in %.3fs (%.1f queries/s) with concurrency %d, batch size %d.",
+		br.Name, br.Iterations, br.Seconds, qps, br.Concurrency, br.BatchSize)
+}
+
+// Breakdown totals the outputs of a query set by the values of one of its
+// dimensions, like a GROUP BY of that dimension alone, largest first.
+type Breakdown struct {
+	Query     string          `json:"query"`
+	Dimension string          `json:"dimension"`
+	Rows      []BreakdownRow  `json:"rows"`
+	Total     float64         `json:"total"`
+	Result    BenchmarkResult `json:"result"`
+}
+
+// BreakdownRow is the total of one value of a Breakdown's dimension, and its
+// share of the whole.
+type BreakdownRow struct {
+	Label string  `json:"label"`
+	Total float64 `json:"total"`
+	Share float64 `json:"share"`
+}
+
+// breakdown runs a query set as a grouped run and totals its outputs by the
+// dimension by, or its first if by is "".
+func (s *Server) breakdown(query, by string, concurrency, batchSize int) (Breakdown, error) {
+	qs := getQuerySet(query)
+	if qs.Name == "" {
+		return Breakdown{}, fmt.Errorf("unknown query set %q", query)
+	}
+	dims := qs.Dimensions()
+	if by == "" && len(dims) > 0 {
+		by = dims[0]
+	}
+	k := indexOf(dims, by)
+	if k < 0 {
+		return Breakdown{}, fmt.Errorf("query set %v has no dimension %q, want one of %v", query, by, strings.Join(dims, ", "))
+	}
+	if err := s.Supported(qs); err != nil {
+		return Breakdown{}, err
+	}
+
+	run := s.runs.Start("grouped", query, "", concurrency, batchSize)
+	results, _ := s.execute(run)
+	s.finish(run, results)
+	if len(results) == 0 {
+		return Breakdown{}, fmt.Errorf("%v returned no results", query)
+	}
+	if results[0].Error != nil {
+		return Breakdown{}, results[0].Error
+	}
+
+	b := Breakdown{Query: query, Dimension: by, Rows: []BreakdownRow{}, Result: results[0]}
+	totals := make(map[string]float64)
+	var labels []string
+	for _, rec := range s.runs.recordsBySet(run, 1)[0] {
+		if k >= len(rec.Inputs) {
+			continue
+		}
+		label := s.Label(by, int(sortValue(rec.Inputs[k])))
+		if _, ok := totals[label]; !ok {
+			labels = append(labels, label)
+		}
+		totals[label] += sortValue(rec.Output)
+		b.Total += sortValue(rec.Output)
+	}
+	for _, label := range labels {
+		row := BreakdownRow{Label: label, Total: totals[label]}
+		if b.Total != 0 {
+			row.Share = row.Total / b.Total
+		}
+		b.Rows = append(b.Rows, row)
+	}
+	sort.SliceStable(b.Rows, func(i, j int) bool { return b.Rows[i].Total > b.Rows[j].Total })
+	return b, nil
+}
+
+// RunScenario executes every step of a Scenario in order.
+func (s *Server) RunScenario(sc Scenario) ScenarioReport {
+	report := ScenarioReport{Name: sc.Name}
+	start := time.Now()
+	for _, step := range sc.Steps {
+		concurrency, batchSize := s.concurrency, s.batchSize
+		if step.Concurrency > 0 {
+			concurrency = step.Concurrency
+		}
+		if step.BatchSize > 0 {
+			batchSize = step.BatchSize
+		}
+
+		sr := StepReport{Title: step.Title, Action: step.Action}
+		switch step.Action {
+		case "note":
+			sr.Narrative = step.Text
+		case "count":
+			count, err := s.RefreshLineOrderCount()
+			if err != nil {
+				sr.Error = err.Error()
+				break
+			}
+			sr.Result = count
+			sr.Narrative = fmt.Sprintf("The index holds %d lineorders.", count)
+		case "query":
+			br := s.RunSumMultiBatch(getQuerySet(step.Query), concurrency, batchSize)
+			sr.Result, sr.first = br, &br
+			sr.Narrative = narrate(br)
+		case "breakdown":
+			b, err := s.breakdown(step.Query, step.By, concurrency, batchSize)
+			if err != nil {
+				sr.Error = err.Error()
+				break
+			}
+			sr.Result, sr.first = b, &b.Result
+			sr.Narrative = fmt.Sprintf("Broke %v down by %v: %d values totalling %.0f.", step.Query, b.Dimension, len(b.Rows), b.Total)
+			if len(b.Rows) > 0 {
+				top := b.Rows[0]
+				sr.Narrative += fmt.Sprintf(" %v leads with %.0f, %.1f%% of the total.", top.Label, top.Total, 100*top.Share)
+			}
+		case "grid":
+			results := s.RunGrid(nil, getQuerySet(step.Query))
+			sr.Result = results
+			var best *BenchmarkResult
+			for n := range results {
+				if results[n].Error == nil && (best == nil || results[n].Seconds < best.Seconds) {
+					best = &results[n]
+				}
+			}
+			if best == nil {
+				sr.Error = "no successful runs"
+				break
+			}
+			sr.first = best
+			sr.Narrative = fmt.Sprintf("Swept %d concurrency/batch size settings for %v; the fastest was concurrency %d, batch size %d at %.3fs.",
+				len(results), step.Query, best.Concurrency, best.BatchSize, best.Seconds)
+		case "multi":
+			var specs []MultiSpec
+			for _, spec := range strings.Split(step.Sets, ",") {
+				ms, err := parseMultiSpec(spec, concurrency, batchSize)
+				if err != nil {
+					sr.Error = err.Error()
+					break
+				}
+				specs = append(specs, ms)
+			}
+			if sr.Error == "" {
+				if err := checkMultiSpecs(specs); err != nil {
+					sr.Error = err.Error()
+					break
+				}
+				mr := s.RunMulti(specs)
+				sr.Result = mr
+				sr.Narrative = fmt.Sprintf("Ran %d query sets at once: %d queries in %.3fs (%.1f queries/s).",
+					len(specs), mr.Iterations, mr.Seconds, mr.QPS)
+			}
+		case "edgecases":
+			results := s.RunEdgeCases()
+			failed := 0
+			for _, res := range results {
+				if !res.OK {
+					failed++
+				}
+			}
+			sr.Result = results
+			sr.Narrative = fmt.Sprintf("Ran %d edge-case queries; %d failed.", len(results), failed)
+		case "schema":
+			schema, err := s.GetSchema()
+			if err != nil {
+				sr.Error = err.Error()
+				break
+			}
+			sr.Result = schema
+			sr.Narrative = fmt.Sprintf("Index %v has %d frames.", schema.Index, len(schema.Frames))
+		case "compare":
+			a, b := report.Steps[step.Compare[0]].first, report.Steps[step.Compare[1]].first
+			if a == nil || b == nil || a.Seconds <= 0 || b.Seconds <= 0 {
+				sr.Error = "compared steps have no successful results"
+				break
+			}
+			c := CompareResults(*a, *b)
+			sr.Result = c
+			sr.Narrative = fmt.Sprintf("%v took %.3fs and %v took %.3fs: %v is %.2fx faster, %v.",
+				a.Name, a.Seconds, b.Name, b.Seconds, fasterName(*a, *b), ratio(a.Seconds, b.Seconds), c.Verdict)
+		}
+		if step.Text != "" && step.Action != "note" {
+			sr.Narrative = step.Text + " " + sr.Narrative
+		}
+		if sr.Error != "" {
+			logWarn(nil, "scenario %v step %q: %v\n", sc.Name, step.Action, sr.Error)
+		}
+		report.Steps = append(report.Steps, sr)
+	}
+	report.Seconds = time.Now().Sub(start).Seconds()
+	return report
+}
+
+func fasterName(a, b BenchmarkResult) string {
+	if a.Seconds <= b.Seconds {
+		return a.Name
+	}
+	return b.Name
+}
+
+// ratio returns the larger of a and b divided by the smaller.
+func ratio(a, b float64) float64 {
+	if a > b {
+		return a / b
+	}
+	return b / a
+}
+
+// walkthrough is the guided tour served by /walkthrough for first-time users.
+var walkthrough = Scenario{
+	Name: "walkthrough",
+	Steps: []ScenarioStep{
+		{
+			Title:  "How much data?",
+			Action: "count",
+			Text:   "Every lineorder is a column in the index; counting the columns with a bit in the exists frame counts the lineorders.",
+		},
+		{
+			Title:  "A first SSB query",
+			Action: "query",
+			Query:  "1.1",
+			Text:   "Query 1.1 sums revenue for 1993 lineorders with a discount of 1-3 and quantity under 25: one Intersect of a year bitmap with three range conditions, then a Sum over a BSI field.",
+		},
+		{
+			Title:  "A breakdown",
+			Action: "query",
+			Query:  "2.1",
+			Text:   "Query 2.1 breaks revenue down by year and brand, one Sum per combination. Its bydimension shows whether some years or brands are slower than others.",
+		},
+		{
+			Title:  "Tuning the client",
+			Action: "grid",
+			Query:  "1.1",
+			Text:   "The same query is swept over concurrency and batch size settings, showing how much the client's settings matter.",
+		},
+	},
+}
+
+// HandleWalkthrough runs the walkthrough and serves its annotated report.
+func (s *Server) HandleWalkthrough(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.Path)
+	report := s.RunScenario(walkthrough)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logError(nil, "writing walkthrough report: %v\n", err)
+	}
+}
+
+// HandleScenario runs the Scenario posted in the request body.
+func (s *Server) HandleScenario(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.Path)
+	sc, err := ReadScenario(r.Body)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "%v", err))
+		return
+	}
+	report := s.RunScenario(sc)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logError(nil, "writing scenario report: %v\n", err)
+	}
+}
+
+// runScenarioFile implements the "scenario <file>" command, printing the
+// report as JSON.
+func (s *Server) runScenarioFile(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: scenario <file>")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc, err := ReadScenario(f)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.RunScenario(sc))
+}
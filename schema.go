@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// rangeFrames are the BSI frames; each has a single integer field of the same
+// name, bounded as in rangeFields. All other frames are plain set frames.
+var rangeFrames = map[string]bool{
+	"lo_quantity":         true,
+	"lo_extendedprice":    true,
+	"lo_discount":         true,
+	"lo_revenue":          true,
+	"lo_supplycost":       true,
+	"lo_profit":           true,
+	"lo_revenue_computed": true,
+}
+
+// rangeField is the value range of a BSI frame's field.
+type rangeField struct {
+	Min, Max int
+}
+
+// rangeFields bound the fields InitSchema creates. The ranges cover dbgen's
+// values at any scale factor.
+var rangeFields = map[string]rangeField{
+	"lo_quantity":         {1, 50},
+	"lo_extendedprice":    {0, 20000000},
+	"lo_discount":         {0, 10},
+	"lo_revenue":          {0, 20000000},
+	"lo_supplycost":       {0, 1000000},
+	"lo_profit":           {-1000000, 20000000},
+	"lo_revenue_computed": {0, 200000000},
+}
+
+// frameOptions returns the options a demo frame is created with: range frames
+// get their field, and set frames a ranked cache, so TopN can count their rows.
+func frameOptions(name string) (*pilosa.FrameOptions, error) {
+	if !rangeFrames[name] {
+		return &pilosa.FrameOptions{CacheType: pilosa.CacheTypeRanked}, nil
+	}
+	f, ok := rangeFields[name]
+	if !ok {
+		return nil, fmt.Errorf("no field range for range frame %v", name)
+	}
+	options := &pilosa.FrameOptions{RangeEnabled: true}
+	if err := options.AddIntField(name, f.Min, f.Max); err != nil {
+		return nil, fmt.Errorf("field %v: %v", name, err)
+	}
+	return options, nil
+}
+
+// InitSchema creates every demo frame that doesn't exist with its options, and
+// returns their handles. Existing frames are left as they are, with a warning
+// if a range frame lacks its field, as it does if it was created without
+// options; delete such frames and run it again.
+func (s *Server) InitSchema() (map[string]*pilosa.Frame, error) {
+	// s.Index already holds handles without options, which Frame would
+	// return, so the frames are defined on a fresh handle.
+	index, err := pilosa.NewIndex(s.Index.Name(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("pilosa.NewIndex: %v", err)
+	}
+	frames := make(map[string]*pilosa.Frame)
+	for _, name := range demoFrames {
+		options, err := frameOptions(name)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := index.Frame(name, options)
+		if err != nil {
+			return nil, fmt.Errorf("index.Frame %v: %v", name, err)
+		}
+		if existing, ok := s.liveFrames[name]; ok {
+			if enabled, _ := existing["rangeEnabled"].(bool); rangeFrames[name] && !enabled {
+				logWarn(nil, "frame %v exists without its range field; delete it and initialize the schema again\n", name)
+			}
+		} else {
+			if err := s.pilosaClient().EnsureFrame(frame); err != nil {
+				return nil, fmt.Errorf("client.EnsureFrame %v: %v", name, err)
+			}
+			logf(nil, "created frame %v\n", name)
+		}
+		frames[name] = frame
+		s.Frames[name] = frame
+	}
+	// Refresh the live frames so ValidateFrames sees them when the demo serves
+	// the data next.
+	live, err := getPilosaSchema(s.pilosaAddr(), s.Index.Name())
+	if err != nil {
+		return nil, fmt.Errorf("fetching pilosa schema: %v", err)
+	}
+	s.liveFrames = live
+	return frames, nil
+}
+
+// SchemaFrame describes one frame as configured in the server and as found in
+// the live Pilosa index.
+type SchemaFrame struct {
+	Name    string                 `json:"name"`
+	Type    string                 `json:"type"`
+	Fields  []string               `json:"fields,omitempty"`
+	Exists  bool                   `json:"exists"`
+	Options map[string]interface{} `json:"options,omitempty"`
+	Rows    int                    `json:"rows"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// Schema describes the index and its frames.
+type Schema struct {
+	Index  string        `json:"index"`
+	Frames []SchemaFrame `json:"frames"`
+}
+
+// pilosaSchema is the subset of Pilosa's /schema response used here. Pilosa
+// 0.x lists an index's frames, 1.x its fields.
+type pilosaSchema struct {
+	Indexes []struct {
+		Name   string        `json:"name"`
+		Frames []pilosaFrame `json:"frames"`
+		Fields []pilosaFrame `json:"fields"`
+	} `json:"indexes"`
+}
+
+// pilosaFrame is a frame of a Pilosa 0.x schema or a field of a 1.x one.
+type pilosaFrame struct {
+	Name    string                 `json:"name"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// fieldFrameOptions returns the options of a Pilosa 1.x field as those of the
+// equivalent frame: an int field becomes a range frame with a field of the
+// same name, as the demo's BSI frames are.
+func fieldFrameOptions(name string, options map[string]interface{}) map[string]interface{} {
+	frame := make(map[string]interface{}, len(options)+2)
+	for k, v := range options {
+		frame[k] = v
+	}
+	if options["type"] == "int" {
+		frame["rangeEnabled"] = true
+		frame["fields"] = []interface{}{map[string]interface{}{
+			"name": name,
+			"type": "int",
+			"min":  options["min"],
+			"max":  options["max"],
+		}}
+	}
+	return frame
+}
+
+// getPilosaSchema fetches the frame options of the given index from Pilosa,
+// keyed by frame name. The fields of a Pilosa 1.x index are returned as
+// frames.
+func getPilosaSchema(host, index string) (map[string]map[string]interface{}, error) {
+	frames, _, err := readPilosaSchema(host, index)
+	return frames, err
+}
+
+// readPilosaSchema is getPilosaSchema, also reporting whether the index is
+// described with 1.x fields rather than frames.
+func readPilosaSchema(host, index string) (frames map[string]map[string]interface{}, fieldModel bool, err error) {
+	resp, err := pilosaHTTP.get(host, "/schema")
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status %v: %s", resp.Status, body)
+	}
+	var ps pilosaSchema
+	if err := json.Unmarshal(body, &ps); err != nil {
+		return nil, false, err
+	}
+
+	frames = make(map[string]map[string]interface{})
+	for _, idx := range ps.Indexes {
+		if idx.Name != index {
+			continue
+		}
+		for _, f := range idx.Frames {
+			frames[f.Name] = f.Options
+		}
+		for _, f := range idx.Fields {
+			frames[f.Name] = fieldFrameOptions(f.Name, f.Options)
+			fieldModel = true
+		}
+	}
+	return frames, fieldModel, nil
+}
+
+// GetSchema combines the server's frame configuration with live Pilosa state.
+// Row counts for set frames come from TopN, so they reflect the rows held in
+// each frame's cache.
+func (s *Server) GetSchema() (Schema, error) {
+	live, err := getPilosaSchema(s.pilosaAddr(), s.Index.Name())
+	if err != nil {
+		return Schema{}, fmt.Errorf("fetching pilosa schema: %v", err)
+	}
+
+	names := make([]string, 0, len(s.Frames))
+	for name := range s.Frames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schema := Schema{Index: s.Index.Name()}
+	for _, name := range names {
+		sf := SchemaFrame{Name: name, Type: "set"}
+		sf.Options, sf.Exists = live[name]
+		if rangeFrames[name] {
+			sf.Type = "range"
+			sf.Fields = []string{name}
+		} else {
+			response, err := s.query(s.Frames[name].TopN(0))
+			if err != nil {
+				sf.Error = err.Error()
+			} else {
+				sf.Rows = len(response.Result().CountItems)
+			}
+		}
+		schema.Frames = append(schema.Frames, sf)
+	}
+	return schema, nil
+}
+
+// HandleSchema serves the frames, their types, options and row counts.
+func (s *Server) HandleSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := s.GetSchema()
+	if err != nil {
+		logError(nil, "getting schema: %v\n", err)
+		writeError(w, pilosaError(err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(schema); err != nil {
+		logError(nil, "writing schema: %v\n", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ssbQueries are the 13 queries of the standard SSB suite, in flight order.
+var ssbQueries = []string{
+	"1.1", "1.2", "1.3",
+	"2.1", "2.2", "2.3",
+	"3.1", "3.2", "3.3", "3.4",
+	"4.1", "4.2", "4.3",
+}
+
+// lineOrdersPerSF is the number of lineorder rows dbgen generates at scale
+// factor 1.
+const lineOrdersPerSF = 6000000
+
+// Scorecard holds the composite metrics of a full SSB suite run, comparable
+// with published SSB results: the total and per-flight query times, their
+// geometric mean, and a power score of 3600*SF/GeoMean, i.e. queries per hour
+// normalized by scale factor. Complete is false, and the composites are not
+// computed, unless every query succeeded. Mode is the suite mode the queries
+// ran in; only isolated runs are comparable with published numbers.
+type Scorecard struct {
+	Mode           string             `json:"mode"`
+	ScaleFactor    float64            `json:"scalefactor"`
+	Complete       bool               `json:"complete"`
+	Queries        []BenchmarkResult  `json:"queries"`
+	Flights        map[string]float64 `json:"flights"`
+	TotalSeconds   float64            `json:"totalseconds"`
+	GeoMeanSeconds float64            `json:"geomeanseconds"`
+	QphSSB         float64            `json:"qphssb"`
+}
+
+// estimateScaleFactor estimates the SSB scale factor from the lineorder count,
+// to two decimal places.
+func estimateScaleFactor(lineOrders uint64) float64 {
+	return math.Floor(float64(lineOrders)/lineOrdersPerSF*100+0.5) / 100
+}
+
+// NewScorecard computes the scorecard of a suite's results.
+func NewScorecard(results []BenchmarkResult, scaleFactor float64) Scorecard {
+	sc := Scorecard{
+		ScaleFactor: scaleFactor,
+		Complete:    len(results) == len(ssbQueries),
+		Queries:     results,
+		Flights:     make(map[string]float64),
+	}
+	logSum := 0.0
+	for _, br := range results {
+		if br.Error != nil || br.Seconds <= 0 {
+			sc.Complete = false
+			continue
+		}
+		flight := strings.SplitN(br.Name, ".", 2)[0]
+		sc.Flights[flight] += br.Seconds
+		sc.TotalSeconds += br.Seconds
+		logSum += math.Log(br.Seconds)
+	}
+	if !sc.Complete {
+		return sc
+	}
+	sc.GeoMeanSeconds = math.Exp(logSum / float64(len(results)))
+	sc.QphSSB = 3600 * scaleFactor / sc.GeoMeanSeconds
+	return sc
+}
+
+// Suite modes: isolated runs one query set at a time for clean numbers;
+// parallel runs all of them at once as a stress test.
+const (
+	suiteIsolated = "isolated"
+	suiteParallel = "parallel"
+)
+
+// RunSuite runs the SSB queries in the given mode, recording them in run unless
+// it is nil, and returns their scorecard.
+func (s *Server) RunSuite(run *Run, mode string, concurrency, batchSize int) (Scorecard, error) {
+	results := make([]BenchmarkResult, len(ssbQueries))
+	switch mode {
+	case suiteIsolated:
+		for n, name := range ssbQueries {
+			results[n] = s.runRecorded(run, getQuerySet(name), concurrency, batchSize)
+		}
+	case suiteParallel:
+		var wg sync.WaitGroup
+		for n, name := range ssbQueries {
+			wg.Add(1)
+			go func(n int, name string) {
+				defer wg.Done()
+				results[n] = s.runRecorded(run, getQuerySet(name), concurrency, batchSize)
+			}(n, name)
+		}
+		wg.Wait()
+	default:
+		return Scorecard{}, fmt.Errorf("unknown suite mode %q, want %v or %v", mode, suiteIsolated, suiteParallel)
+	}
+
+	sf := s.scaleFactor
+	if sf == 0 {
+		sf = estimateScaleFactor(s.NumLineOrders())
+	}
+	sc := NewScorecard(results, sf)
+	sc.Mode = mode
+	return sc, nil
+}
+
+// HandleScorecard runs the full SSB suite and serves its scorecard. The mode
+// parameter selects isolated (the default) or parallel execution, e.g.
+// /scorecard?mode=parallel
+func (s *Server) HandleScorecard(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(w, r)
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = suiteIsolated
+	}
+	if mode != suiteIsolated && mode != suiteParallel {
+		s.reject(w, "scorecard", "ssb/"+mode, requestID, newAPIError(http.StatusBadRequest, errBadRequest, "unknown mode %q, want %v or %v", mode, suiteIsolated, suiteParallel))
+		return
+	}
+
+	run := s.runs.Start("scorecard", "ssb/"+mode, requestID, s.concurrency, s.batchSize)
+	defer s.failOnPanic(run)
+	logf(run, "handling %v\n", r.URL.Path)
+	w.Header().Set("X-Run-ID", run.ID)
+
+	results, sc := s.execute(run)
+	s.finish(run, results)
+	if err := json.NewEncoder(w).Encode(sc); err != nil {
+		logError(run, "writing scorecard: %v\n", err)
+	}
+}
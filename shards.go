@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// defaultShardWidth is the number of columns per Pilosa shard (slice).
+const defaultShardWidth = 1 << 20
+
+// getShardCount returns the number of shards in the index, from the highest
+// shard number Pilosa reports. Older Pilosa versions call shards slices and
+// serve them from /slices/max; newer ones from /internal/shards/max.
+func getShardCount(host, index string) (uint64, error) {
+	var lastErr error
+	for _, endpoint := range []string{"/slices/max", "/internal/shards/max"} {
+		resp, err := pilosaHTTP.get(host, endpoint)
+		if err != nil {
+			return 0, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%v: unexpected status %v", endpoint, resp.Status)
+			continue
+		}
+		var max struct {
+			MaxSlices map[string]uint64 `json:"maxSlices"`
+			Standard  map[string]uint64 `json:"standard"`
+		}
+		if err := json.Unmarshal(body, &max); err != nil {
+			return 0, fmt.Errorf("parsing %v: %v", endpoint, err)
+		}
+		if n, ok := max.MaxSlices[index]; ok {
+			return n + 1, nil
+		}
+		if n, ok := max.Standard[index]; ok {
+			return n + 1, nil
+		}
+		return 0, fmt.Errorf("%v: no shards for index %v", endpoint, index)
+	}
+	return 0, lastErr
+}
+
+// shardQPS is the throughput normalized by dataset size: queries times shards
+// per second. It stays level across datasets of different sizes as long as
+// the per-shard cost does, so results on different datasets are comparable.
+func shardQPS(iterations int, shards uint64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(iterations) * float64(shards) / seconds
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ResultWriter consumes per-query results as workers produce them.
+type ResultWriter interface {
+	Write(res QueryResult) error
+	Close() error
+}
+
+// resultWriters lists the kinds of ResultWriter newResultWriter creates:
+// plain text or JSON lines files, plain text on stdout, or nothing, for
+// benchmarking latency alone or running where the filesystem is read-only.
+var resultWriters = []string{"file", "jsonl", "stdout", "discard"}
+
+// newResultWriter creates a ResultWriter of the given kind for a run of the
+// named query set. Files are created in dir, named after the query set and
+// timestamp, with a non-empty tag, such as a request ID, appended, and
+// gzipped if compress is set.
+func newResultWriter(kind, dir, name string, timestamp int64, tag string, compress bool) (ResultWriter, error) {
+	switch kind {
+	case "file":
+		return newFileSink(dir, name, timestamp, tag, compress)
+	case "jsonl":
+		f, fname, err := createResultsFile(dir, name, timestamp, tag, "jsonl", compress)
+		if err != nil {
+			return nil, err
+		}
+		ss := newStreamSink(f)
+		ss.closer, ss.fname = f, fname
+		return ss, nil
+	case "stdout":
+		return &fileSink{w: os.Stdout, fname: "stdout"}, nil
+	case "discard":
+		return discardSink{}, nil
+	}
+	return nil, fmt.Errorf("unknown result writer %q, want one of %v", kind, strings.Join(resultWriters, ", "))
+}
+
+// createResultsFile creates the results file dir/name-timestamp[-tag].ext, or,
+// if compress is set, the gzipped dir/name-timestamp[-tag].ext.gz. An existing
+// file is never overwritten: if the name is taken, e.g. by the previous repeat
+// of a benchmark in the same second, a counter is added, -2, -3 and so on.
+func createResultsFile(dir, name string, timestamp int64, tag, ext string, compress bool) (io.WriteCloser, string, error) {
+	base := fmt.Sprintf("%v-%v", name, timestamp)
+	if tag != "" {
+		base += "-" + tag
+	}
+	suffix := "." + ext
+	if compress {
+		suffix += ".gz"
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", fmt.Errorf("creating results directory: %v", err)
+	}
+	var f *os.File
+	var fname string
+	for n := 1; ; n++ {
+		fname = filepath.Join(dir, base+suffix)
+		if n > 1 {
+			fname = filepath.Join(dir, fmt.Sprintf("%v-%d%v", base, n, suffix))
+		}
+		var err error
+		f, err = os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			break
+		} else if !os.IsExist(err) {
+			return nil, "", fmt.Errorf("creating results file: %v", err)
+		}
+	}
+	if compress {
+		return gzipFile{gzip.NewWriter(f), f}, fname, nil
+	}
+	return f, fname, nil
+}
+
+// gzipFile compresses what is written to a file, closing both.
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (gf gzipFile) Close() error {
+	err := gf.Writer.Close()
+	if cerr := gf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fileSink writes results as plain text, one query per line: the raw output,
+// the inputs, then the batch ID. It closes the file it writes to, if any.
+type fileSink struct {
+	w      io.Writer
+	closer io.Closer
+	fname  string
+	nbytes int
+}
+
+// newFileSink creates a plain text results file in dir for a run of the named
+// query set.
+func newFileSink(dir, name string, timestamp int64, tag string, compress bool) (*fileSink, error) {
+	f, fname, err := createResultsFile(dir, name, timestamp, tag, "txt", compress)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{w: f, closer: f, fname: fname}, nil
+}
+
+func (fs *fileSink) Write(res QueryResult) error {
+	n, err := fmt.Fprintf(fs.w, "%v %v %v\n", res.outputs[0], res.inputs, res.batch)
+	fs.nbytes += n
+	if err != nil {
+		return fmt.Errorf("writing results to %v: %v", fs.fname, err)
+	}
+	return nil
+}
+
+func (fs *fileSink) Close() error {
+	if fs.closer == nil {
+		return nil
+	}
+	logf(nil, "wrote %d bytes to %v\n", fs.nbytes, fs.fname)
+	return fs.closer.Close()
+}
+
+// teeSink hands every result to each of its sinks.
+type teeSink []ResultWriter
+
+func (ts teeSink) Write(res QueryResult) error {
+	for _, sink := range ts {
+		if err := sink.Write(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts teeSink) Close() error {
+	var err error
+	for _, sink := range ts {
+		if cerr := sink.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// discardSink drops every result.
+type discardSink struct{}
+
+func (discardSink) Write(res QueryResult) error { return nil }
+func (discardSink) Close() error                { return nil }
+
+// streamSink encodes each result as a line of JSON to a buffered writer, as a
+// client streaming results over the network would. It closes closer, if set,
+// after flushing.
+type streamSink struct {
+	w      *bufio.Writer
+	enc    *json.Encoder
+	closer io.Closer
+	fname  string
+}
+
+func newStreamSink(w io.Writer) *streamSink {
+	bw := bufio.NewWriter(w)
+	return &streamSink{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (ss *streamSink) Write(res QueryResult) error {
+	return ss.enc.Encode(struct {
+		Batch   string        `json:"batch"`
+		Inputs  []interface{} `json:"inputs"`
+		Outputs []interface{} `json:"outputs"`
+	}{res.batch, res.inputs, res.outputs})
+}
+
+func (ss *streamSink) Close() error {
+	err := ss.w.Flush()
+	if ss.closer != nil {
+		logf(nil, "wrote %v\n", ss.fname)
+		if cerr := ss.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ConsumptionResult reports a run of a query set under one result-consumption
+// strategy, and its overhead relative to discarding results.
+type ConsumptionResult struct {
+	Strategy        string          `json:"strategy"`
+	Result          BenchmarkResult `json:"result"`
+	OverheadSeconds float64         `json:"overheadseconds"`
+	OverheadPercent float64         `json:"overheadpercent"`
+}
+
+// RunConsumptionExperiment runs the same query set with results discarded,
+// written to a results file, and streamed as JSON, to quantify the client-side
+// cost of consuming results.
+func (s *Server) RunConsumptionExperiment(qs QuerySet, concurrency, batchSize int) []ConsumptionResult {
+	strategies := []string{"discard", "write", "stream"}
+	results := make([]ConsumptionResult, 0, len(strategies))
+	for _, strategy := range strategies {
+		var sink ResultWriter
+		switch strategy {
+		case "discard":
+			sink = discardSink{}
+		case "write":
+			fs, err := newFileSink(s.resultsDir, qs.Name, time.Now().Unix(), "", s.resultsGzip)
+			if err != nil {
+				logError(nil, "%v\n", err)
+				continue
+			}
+			sink = fs
+		case "stream":
+			sink = newStreamSink(ioutil.Discard)
+		}
+		br := s.runSumMultiBatch(nil, qs, concurrency, batchSize, sink)
+		results = append(results, ConsumptionResult{Strategy: strategy, Result: br})
+	}
+
+	if len(results) > 0 && results[0].Strategy == "discard" && results[0].Result.Seconds > 0 {
+		base := results[0].Result.Seconds
+		for n := range results {
+			results[n].OverheadSeconds = results[n].Result.Seconds - base
+			results[n].OverheadPercent = 100 * results[n].OverheadSeconds / base
+		}
+	}
+	return results
+}
+
+// HandleConsumption runs the result-consumption experiment for a query set.
+func (s *Server) HandleConsumption(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.Path)
+	qname := mux.Vars(r)["qname"]
+	qs := getQuerySet(qname)
+	if qs.Name == "" {
+		writeError(w, newAPIError(http.StatusBadRequest, errUnknownQuery, "unknown query set %q; see /queries", qname))
+		return
+	}
+	if err := s.Supported(qs); err != nil {
+		writeError(w, newAPIError(http.StatusNotImplemented, errUnsupported, "%v", err))
+		return
+	}
+	results := s.RunConsumptionExperiment(qs, s.concurrency, s.batchSize)
+
+	enc := json.NewEncoder(w)
+	err := enc.Encode(results)
+	if err != nil {
+		logError(nil, "writing results: %v to responsewriter: %v", results, err)
+	}
+}
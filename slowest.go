@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryTrace times one query sent alone: connection setup, time from sending
+// the request to the first response byte (Pilosa's execution time), and time
+// to read the response.
+type QueryTrace struct {
+	Seconds         float64 `json:"seconds"`
+	ConnectSeconds  float64 `json:"connectseconds"`
+	ServerSeconds   float64 `json:"serverseconds"`
+	TransferSeconds float64 `json:"transferseconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// SlowQuery is one of the slowest queries of a run. BatchSeconds is the
+// latency of the batch it was sent in; Isolated is its trace when re-executed
+// alone. Victim is set when it ran in under half the batch latency alone,
+// meaning it was slowed by the rest of its batch rather than slow itself.
+type SlowQuery struct {
+	Inputs       []interface{} `json:"inputs"`
+	Batch        string        `json:"batch"`
+	BatchSeconds float64       `json:"batchseconds"`
+	Isolated     QueryTrace    `json:"isolated"`
+	Victim       bool          `json:"victim"`
+}
+
+// slowestQueries keeps the n results with the highest batch latency.
+type slowestQueries struct {
+	n       int
+	queries []QueryResult
+}
+
+func (sq *slowestQueries) add(res QueryResult) {
+	if sq.n <= 0 {
+		return
+	}
+	if len(sq.queries) == sq.n && res.latency <= sq.queries[sq.n-1].latency {
+		return
+	}
+	i := sort.Search(len(sq.queries), func(i int) bool { return sq.queries[i].latency < res.latency })
+	sq.queries = append(sq.queries, QueryResult{})
+	copy(sq.queries[i+1:], sq.queries[i:])
+	sq.queries[i] = res
+	if len(sq.queries) > sq.n {
+		sq.queries = sq.queries[:sq.n]
+	}
+}
+
+// tracedQuery sends a raw query to Pilosa's HTTP API and traces it.
+func tracedQuery(host, index, raw string) QueryTrace {
+	var t QueryTrace
+	var connectStart, wrote, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) { connectStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			t.ConnectSeconds = time.Since(connectStart).Seconds()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+
+	req, err := http.NewRequest("POST", pilosaHTTP.url(host, "/index/"+index+"/query"), strings.NewReader(raw))
+	if err != nil {
+		t.Error = err.Error()
+		return t
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := pilosaHTTP.do(req)
+	if err != nil {
+		t.Error = err.Error()
+		return t
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Error = fmt.Sprintf("unexpected status %v: %s", resp.Status, body)
+	} else if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		t.Error = err.Error()
+	}
+	done := time.Now()
+	t.Seconds = done.Sub(start).Seconds()
+	t.ServerSeconds = firstByte.Sub(wrote).Seconds()
+	t.TransferSeconds = done.Sub(firstByte).Seconds()
+	return t
+}
+
+// TraceSlowest re-executes each of the given queries alone against index and
+// compares its isolated latency with that of its batch.
+func (s *Server) TraceSlowest(run *Run, index string, queries []QueryResult) []SlowQuery {
+	var slowest []SlowQuery
+	for _, q := range queries {
+		sq := SlowQuery{
+			Inputs:       q.inputs,
+			Batch:        q.batch,
+			BatchSeconds: q.latency.Seconds(),
+			Isolated:     tracedQuery(s.pilosaAddr(), index, s.pql(q.raw)),
+		}
+		if sq.Isolated.Error != "" {
+			logf(run, "tracing query %v: %v\n", q.inputs, sq.Isolated.Error)
+		} else {
+			sq.Victim = sq.Isolated.Seconds < sq.BatchSeconds/2
+		}
+		slowest = append(slowest, sq)
+	}
+	return slowest
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sortOutput names a query's output in a sort order.
+const sortOutput = "sum"
+
+// SortKey orders results by the value of one argset, or by the output if Arg
+// is negative. Desc reverses the order.
+type SortKey struct {
+	Arg  int
+	Desc bool
+}
+
+// ParseOrder parses a sort order: a comma-separated list of argset names, as
+// returned by Dimensions, or "sum" for the output, each optionally prefixed
+// with - for descending. E.g. "lo_year,-sum" matches SSB's
+// "order by d_year asc, revenue desc". "inputs" orders by each argset in turn,
+// and "outputs" by descending output. Inputs are ordered by rowID.
+func (qs *QuerySet) ParseOrder(spec string) ([]SortKey, error) {
+	switch spec {
+	case "":
+		return nil, nil
+	case "inputs":
+		order := make([]SortKey, qs.dim)
+		for k := range order {
+			order[k] = SortKey{Arg: k}
+		}
+		return order, nil
+	case "outputs":
+		return []SortKey{{Arg: -1, Desc: true}}, nil
+	}
+	dims := qs.Dimensions()
+	var order []SortKey
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		key := SortKey{Desc: strings.HasPrefix(name, "-")}
+		name = strings.TrimPrefix(name, "-")
+		if name == sortOutput {
+			key.Arg = -1
+		} else if key.Arg = indexOf(dims, name); key.Arg < 0 {
+			return nil, fmt.Errorf("can't sort %v by %q, want one of %v or %v", qs.Name, name, strings.Join(dims, ", "), sortOutput)
+		}
+		order = append(order, key)
+	}
+	return order, nil
+}
+
+// sortValue returns a numeric input or output for comparison. A TopN output
+// compares by the count of its top row.
+func sortValue(v interface{}) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	case []TopNItem:
+		if len(v) > 0 {
+			return float64(v[0].Count)
+		}
+	}
+	return 0
+}
+
+// lessResult reports whether a sorts before b in order.
+func lessResult(order []SortKey, a, b QueryResult) bool {
+	for _, key := range order {
+		var va, vb float64
+		if key.Arg < 0 {
+			va, vb = sortValue(a.outputs[0]), sortValue(b.outputs[0])
+		} else {
+			va, vb = sortValue(a.inputs[key.Arg]), sortValue(b.inputs[key.Arg])
+		}
+		if va == vb {
+			continue
+		}
+		return va < vb != key.Desc
+	}
+	return false
+}
+
+// sortingSink buffers results, which arrive in worker completion order, and
+// hands them to its sink in order when closed.
+type sortingSink struct {
+	sink    ResultWriter
+	order   []SortKey
+	results []QueryResult
+}
+
+func (ss *sortingSink) Write(res QueryResult) error {
+	ss.results = append(ss.results, res)
+	return nil
+}
+
+func (ss *sortingSink) Close() error {
+	sort.SliceStable(ss.results, func(i, j int) bool {
+		return lessResult(ss.order, ss.results[i], ss.results[j])
+	})
+	var err error
+	for _, res := range ss.results {
+		if err = ss.sink.Write(res); err != nil {
+			break
+		}
+	}
+	if cerr := ss.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
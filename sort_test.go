@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseOrder(t *testing.T) {
+	qs := NewQuerySet("test", `Sum(Intersect(Bitmap(frame="lo_year", rowID=%d), Bitmap(frame="p_brand1", rowID=%d)), frame="lo_revenue_computed", field="lo_revenue_computed")`, [][]int{{1, 2}, {3, 4}})
+	for _, test := range []struct {
+		spec    string
+		order   []SortKey
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"inputs", []SortKey{{Arg: 0}, {Arg: 1}}, ""},
+		{"outputs", []SortKey{{Arg: -1, Desc: true}}, ""},
+		{"lo_year,-sum", []SortKey{{Arg: 0}, {Arg: -1, Desc: true}}, ""},
+		{" -p_brand1 , lo_year", []SortKey{{Arg: 1, Desc: true}, {Arg: 0}}, ""},
+		{"lo_month", nil, `can't sort test by "lo_month", want one of lo_year, p_brand1 or sum`},
+	} {
+		order, err := qs.ParseOrder(test.spec)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("ParseOrder(%q) error = %v, want %q", test.spec, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseOrder(%q): %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(order, test.order) {
+			t.Errorf("ParseOrder(%q) = %v, want %v", test.spec, order, test.order)
+		}
+	}
+}
+
+func TestLessResult(t *testing.T) {
+	result := func(year, brand int, sum interface{}) QueryResult {
+		return QueryResult{inputs: []interface{}{year, brand}, outputs: []interface{}{sum}}
+	}
+	results := []QueryResult{
+		result(1993, 2, int64(10)),
+		result(1992, 1, int64(30)),
+		result(1993, 1, int64(20)),
+		result(1992, 2, int64(40)),
+		result(1994, 1, []TopNItem{{Count: 5}}),
+	}
+	for _, test := range []struct {
+		order []SortKey
+		want  []int // year*10 + brand
+	}{
+		{[]SortKey{{Arg: 0}, {Arg: 1}}, []int{19921, 19922, 19931, 19932, 19941}},
+		{[]SortKey{{Arg: 0}, {Arg: -1, Desc: true}}, []int{19922, 19921, 19931, 19932, 19941}},
+		{[]SortKey{{Arg: -1}}, []int{19941, 19932, 19931, 19921, 19922}},
+		{[]SortKey{{Arg: 1, Desc: true}, {Arg: 0, Desc: true}}, []int{19932, 19922, 19941, 19931, 19921}},
+		{nil, []int{19932, 19921, 19931, 19922, 19941}},
+	} {
+		sorted := append([]QueryResult(nil), results...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return lessResult(test.order, sorted[i], sorted[j])
+		})
+		got := make([]int, len(sorted))
+		for n, res := range sorted {
+			got[n] = res.inputs[0].(int)*10 + res.inputs[1].(int)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sorting by %v = %v, want %v", test.order, got, test.want)
+		}
+	}
+}
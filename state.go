@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// persistedRun is a Run as saved in the state directory, with its per-query
+// records, the dimensions of each set's records, and the batch latencies of
+// each result.
+type persistedRun struct {
+	Run
+	Records       []QueryRecord `json:"records"`
+	SetDimensions [][]string    `json:"setdimensions,omitempty"`
+	Latencies     [][]float64   `json:"latencies,omitempty"`
+}
+
+// runPath returns the file a run is saved in.
+func (rs *runStore) runPath(id string) string {
+	return filepath.Join(rs.dir, id+".json")
+}
+
+// writeFileAtomic writes body to path through a temporary file, so a crash
+// never leaves it half written.
+func writeFileAtomic(path string, body []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// encode serializes a run for the state directory, or returns nil if there is
+// none. The caller must hold rs.mu.
+func (rs *runStore) encode(run *Run) []byte {
+	if rs.dir == "" {
+		return nil
+	}
+	var latencies [][]float64
+	for _, br := range run.Results {
+		latencies = append(latencies, br.latencies)
+	}
+	body, err := json.Marshal(persistedRun{*run, run.records, run.setDimensions, latencies})
+	if err != nil {
+		logError(run, "serializing run %v: %v\n", run.ID, err)
+		return nil
+	}
+	return body
+}
+
+// save writes a run, as serialized by encode, to the state directory. It
+// doesn't need rs.mu, which shouldn't be held over file I/O.
+func (rs *runStore) save(run *Run, body []byte) {
+	if body == nil {
+		return
+	}
+	if err := writeFileAtomic(rs.runPath(run.ID), body); err != nil {
+		logError(run, "saving run %v: %v\n", run.ID, err)
+	}
+}
+
+// remove deletes the files of runs from the state directory. It doesn't need
+// rs.mu.
+func (rs *runStore) remove(ids ...string) {
+	if rs.dir == "" {
+		return
+	}
+	for _, id := range ids {
+		if err := os.Remove(rs.runPath(id)); err != nil && !os.IsNotExist(err) {
+			logError(nil, "removing run %v: %v\n", id, err)
+		}
+	}
+}
+
+// Load reloads the runs saved in dir and saves new runs there from now on.
+// Runs that were running or stalled when the server stopped are marked
+// interrupted and returned, so they can be resumed. The files of runs beyond
+// the newest maxRuns are deleted.
+func (rs *runStore) Load(dir string) ([]Run, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded, interrupted []*Run
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var pr persistedRun
+		if err := json.Unmarshal(body, &pr); err != nil {
+			return nil, fmt.Errorf("parsing %v: %v", file, err)
+		}
+		run := pr.Run
+		run.records, run.setDimensions = pr.Records, pr.SetDimensions
+		for n := range run.Results {
+			if n < len(pr.Latencies) {
+				run.Results[n].latencies = pr.Latencies[n]
+			}
+		}
+		run.cancel = make(chan struct{})
+		for _, rec := range run.records {
+			if rec.Set >= run.sets {
+				run.sets = rec.Set + 1
+			}
+		}
+		switch run.Status {
+		case "running", "stalled":
+			run.Status = "interrupted"
+			interrupted = append(interrupted, &run)
+		default:
+			if run.Finished != nil {
+				rs.cache(&run)
+			}
+		}
+		loaded = append(loaded, &run)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Started.Before(loaded[j].Started) })
+
+	rs.mu.Lock()
+	rs.dir = dir
+	for _, run := range loaded {
+		rs.runs[run.ID] = run
+		rs.order = append(rs.order, run.ID)
+	}
+	// Keep new run IDs distinct from reloaded ones started in the same second.
+	rs.seq += len(loaded)
+	var trimmed []string
+	for len(rs.order) > maxRuns {
+		trimmed = append(trimmed, rs.order[0])
+		delete(rs.runs, rs.order[0])
+		rs.order = rs.order[1:]
+	}
+	bodies := make([][]byte, len(interrupted))
+	runs := make([]Run, len(interrupted))
+	for n, run := range interrupted {
+		bodies[n], runs[n] = rs.encode(run), *run
+	}
+	rs.mu.Unlock()
+
+	for n, run := range interrupted {
+		rs.save(run, bodies[n])
+	}
+	rs.remove(trimmed...)
+	return runs, nil
+}
+
+// ResumeInterrupted re-executes interrupted runs one at a time in the
+// background, each as a new run.
+func (s *Server) ResumeInterrupted(runs []Run) {
+	go func() {
+		for _, old := range runs {
+			run := s.runs.Start(old.Type, old.Query, old.RequestID, old.Concurrency, old.BatchSize)
+			run.Warmup, run.Repeats, run.Sort, run.Verify = old.Warmup, old.Repeats, old.Sort, old.Verify
+			run.Index, run.Sample, run.Shuffle, run.Seed = old.Index, old.Sample, old.Shuffle, old.Seed
+			logf(run, "resuming interrupted run %v as %v\n", old.ID, run.ID)
+			results, _ := s.execute(run)
+			s.finish(run, results)
+		}
+	}()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	// The UI, embedded from ./static by go generate.
+	_ "github.com/pilosa/demo-ssb/statik"
+)
+
+// HandleStatic serves the UI, embedded in the binary or, with --static-dir,
+// from a directory for working on it without rebuilding. The root redirects
+// to the results viewer unless the UI has an index page of its own.
+func (s *Server) HandleStatic(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		f, err := s.static.Open("/index.html")
+		if err != nil {
+			http.Redirect(w, r, "/viewer/", http.StatusFound)
+			return
+		}
+		f.Close()
+	}
+	http.FileServer(s.static).ServeHTTP(w, r)
+}
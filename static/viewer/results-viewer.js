@@ -0,0 +1,157 @@
+// Embeddable results viewer for demo-ssb benchmark runs.
+//
+// Usage:
+//   <script src="http://demo-host:8000/viewer/results-viewer.js"></script>
+//   <div data-ssb-run="1508000000-1" data-ssb-server="http://demo-host:8000"></div>
+//
+// Every element with a data-ssb-run attribute is rendered as a summary of the
+// run's BenchmarkResults followed by a paged table of per-query results, using
+// the server's /runs API. While the run is going, a progress bar fed by
+// /ws/jobs/{id} is shown instead. SSBResultsViewer.render(element, server,
+// runID) can also be called directly.
+(function (global) {
+  'use strict';
+
+  var PAGE_SIZE = 50;
+
+  function el(tag, text) {
+    var e = document.createElement(tag);
+    if (text !== undefined) {
+      e.textContent = text;
+    }
+    return e;
+  }
+
+  function getJSON(url, cb) {
+    var xhr = new XMLHttpRequest();
+    xhr.open('GET', url);
+    xhr.onload = function () {
+      if (xhr.status !== 200) {
+        cb(new Error(url + ': ' + xhr.status + ' ' + xhr.responseText));
+        return;
+      }
+      cb(null, JSON.parse(xhr.responseText));
+    };
+    xhr.onerror = function () {
+      cb(new Error(url + ': request failed'));
+    };
+    xhr.send();
+  }
+
+  function table(headers, rows) {
+    var t = el('table');
+    t.className = 'ssb-table';
+    var tr = el('tr');
+    headers.forEach(function (h) { tr.appendChild(el('th', h)); });
+    t.appendChild(tr);
+    rows.forEach(function (row) {
+      var tr = el('tr');
+      row.forEach(function (v) { tr.appendChild(el('td', String(v))); });
+      t.appendChild(tr);
+    });
+    return t;
+  }
+
+  function renderSummary(container, run) {
+    container.appendChild(el('h3', 'Run ' + run.id + ': ' + run.type + ' ' + run.query + ' (' + run.status + ')'));
+    container.appendChild(table(
+      ['concurrency', 'batch size', 'queries', 'seconds', 'queries/s'],
+      (run.results || []).map(function (r) {
+        var qps = r.seconds > 0 ? (r.iterations / r.seconds).toFixed(1) : '-';
+        return [r.concurrency, r.batchsize, r.iterations, r.seconds.toFixed(3), qps];
+      })
+    ));
+  }
+
+  function renderPage(container, server, runID, offset) {
+    var url = server + '/runs/' + encodeURIComponent(runID) + '/results?limit=' + PAGE_SIZE + '&offset=' + offset;
+    getJSON(url, function (err, page) {
+      container.innerHTML = '';
+      if (err) {
+        container.appendChild(el('p', err.message));
+        return;
+      }
+      container.appendChild(table(
+        ['set'].concat(page.dimensions || [], ['output']),
+        page.records.map(function (rec) { return [rec.set].concat(rec.inputs, [rec.display || rec.output]); })
+      ));
+      var nav = el('div');
+      nav.className = 'ssb-nav';
+      var last = Math.min(offset + PAGE_SIZE, page.total);
+      nav.appendChild(el('span', (page.total ? offset + 1 : 0) + '-' + last + ' of ' + page.total + ' '));
+      if (offset > 0) {
+        var prev = el('button', 'prev');
+        prev.onclick = function () { renderPage(container, server, runID, Math.max(0, offset - PAGE_SIZE)); };
+        nav.appendChild(prev);
+      }
+      if (last < page.total) {
+        var next = el('button', 'next');
+        next.onclick = function () { renderPage(container, server, runID, offset + PAGE_SIZE); };
+        nav.appendChild(next);
+      }
+      container.appendChild(nav);
+    });
+  }
+
+  function renderProgress(element, server, runID, done) {
+    var bar = el('progress');
+    var label = el('span');
+    element.appendChild(bar);
+    element.appendChild(label);
+    var ws = new WebSocket(server.replace(/^http/, 'ws') + '/ws/jobs/' + encodeURIComponent(runID));
+    var finished = false;
+    ws.onmessage = function (msg) {
+      var p = JSON.parse(msg.data);
+      if (p.total) {
+        bar.max = p.total;
+        bar.value = p.completed;
+      }
+      label.textContent = ' ' + p.completed + (p.total ? ' of ' + p.total : '') + ' queries, ' + p.qps.toFixed(1) + ' queries/s' +
+        (p.last ? ', last: ' + p.last.name + ' in ' + p.last.seconds.toFixed(3) + 's' : '');
+      finished = p.status !== 'running' && p.status !== 'stalled';
+    };
+    ws.onclose = function () {
+      if (finished) {
+        done();
+      } else {
+        label.textContent += ' (progress unavailable)';
+      }
+    };
+  }
+
+  function render(element, server, runID) {
+    server = (server || location.origin).replace(/\/$/, '');
+    element.innerHTML = '';
+    if (element.className.indexOf('ssb-results-viewer') < 0) {
+      element.className += ' ssb-results-viewer';
+    }
+    getJSON(server + '/runs/' + encodeURIComponent(runID), function (err, run) {
+      if (err) {
+        element.appendChild(el('p', err.message));
+        return;
+      }
+      if (run.status === 'running' || run.status === 'stalled') {
+        renderProgress(element, server, runID, function () { render(element, server, runID); });
+        return;
+      }
+      renderSummary(element, run);
+      var records = el('div');
+      element.appendChild(records);
+      renderPage(records, server, runID, 0);
+    });
+  }
+
+  function renderAll() {
+    var nodes = document.querySelectorAll('[data-ssb-run]');
+    for (var i = 0; i < nodes.length; i++) {
+      render(nodes[i], nodes[i].getAttribute('data-ssb-server'), nodes[i].getAttribute('data-ssb-run'));
+    }
+  }
+
+  global.SSBResultsViewer = { render: render };
+  if (document.readyState === 'loading') {
+    document.addEventListener('DOMContentLoaded', renderAll);
+  } else {
+    renderAll();
+  }
+})(window);
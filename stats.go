@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// significanceLevel is the p-value below which a difference is reported as
+	// significant.
+	significanceLevel = 0.05
+	// minSamples is the smallest number of latency samples per side for which
+	// significance is tested.
+	minSamples = 5
+)
+
+// Comparison compares the batch latencies of two benchmark results. Latencies
+// are in seconds. Diff is the difference of mean latencies, B minus A, and
+// DiffLow and DiffHigh its 95% confidence interval. P is the two-sided p-value
+// of a Mann-Whitney U test; Significant is set when P is below 0.05.
+type Comparison struct {
+	A           string  `json:"a"`
+	B           string  `json:"b"`
+	SamplesA    int     `json:"samplesa"`
+	SamplesB    int     `json:"samplesb"`
+	MedianA     float64 `json:"mediana"`
+	MedianB     float64 `json:"medianb"`
+	Diff        float64 `json:"diff"`
+	DiffLow     float64 `json:"difflow"`
+	DiffHigh    float64 `json:"diffhigh"`
+	U           float64 `json:"u"`
+	P           float64 `json:"p"`
+	Significant bool    `json:"significant"`
+	Verdict     string  `json:"verdict"`
+}
+
+// mean returns the mean and sample variance of xs.
+func mean(xs []float64) (m, variance float64) {
+	for _, x := range xs {
+		m += x
+	}
+	m /= float64(len(xs))
+	if len(xs) < 2 {
+		return m, 0
+	}
+	for _, x := range xs {
+		variance += (x - m) * (x - m)
+	}
+	return m, variance / float64(len(xs)-1)
+}
+
+// median returns the median of xs, which need not be sorted.
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// percentile returns the pth percentile, 0 to 100, of sorted xs,
+// interpolating linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// mannWhitney returns the U statistic of a and the two-sided p-value of the
+// Mann-Whitney U test, using the normal approximation with tie correction.
+func mannWhitney(a, b []float64) (u, p float64) {
+	type sample struct {
+		x     float64
+		fromA bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, x := range a {
+		all = append(all, sample{x, true})
+	}
+	for _, x := range b {
+		all = append(all, sample{x, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].x < all[j].x })
+
+	// Rank, averaging ranks over ties.
+	var rankA, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].x == all[i].x {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	u = rankA - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return u, math.Erfc(z / math.Sqrt2)
+}
+
+// sparklinePoints is the number of points in a BenchmarkResult's sparkline.
+const sparklinePoints = 100
+
+// maxLatencySamples bounds the batch latencies a BenchmarkResult keeps for
+// comparisons and histograms, and saves with its run.
+const maxLatencySamples = 1000
+
+// subsample returns at most n of xs, evenly spaced through them, so a long
+// run is sampled from start to finish.
+func subsample(xs []float64, n int) []float64 {
+	if len(xs) <= n {
+		return xs
+	}
+	sampled := make([]float64, n)
+	for i := range sampled {
+		sampled[i] = xs[i*len(xs)/n]
+	}
+	return sampled
+}
+
+// downsample reduces xs to at most n points, each the mean of a consecutive
+// run of samples.
+func downsample(xs []float64, n int) []float64 {
+	if len(xs) <= n {
+		return append([]float64(nil), xs...)
+	}
+	points := make([]float64, n)
+	for i := range points {
+		lo, hi := i*len(xs)/n, (i+1)*len(xs)/n
+		points[i], _ = mean(xs[lo:hi])
+	}
+	return points
+}
+
+// CompareSamples compares two sets of latency samples.
+func CompareSamples(nameA, nameB string, a, b []float64) Comparison {
+	c := Comparison{
+		A:        nameA,
+		B:        nameB,
+		SamplesA: len(a),
+		SamplesB: len(b),
+		MedianA:  median(a),
+		MedianB:  median(b),
+		P:        1,
+	}
+	if len(a) < minSamples || len(b) < minSamples {
+		c.Verdict = fmt.Sprintf("not enough samples to test significance (need %d per side)", minSamples)
+		return c
+	}
+
+	ma, va := mean(a)
+	mb, vb := mean(b)
+	se := math.Sqrt(va/float64(len(a)) + vb/float64(len(b)))
+	c.Diff = mb - ma
+	c.DiffLow, c.DiffHigh = c.Diff-1.96*se, c.Diff+1.96*se
+	c.U, c.P = mannWhitney(a, b)
+	c.Significant = c.P < significanceLevel
+	if c.Significant {
+		c.Verdict = fmt.Sprintf("significant difference (p=%.3g)", c.P)
+	} else {
+		c.Verdict = fmt.Sprintf("no significant difference (p=%.3g)", c.P)
+	}
+	return c
+}
+
+// CompareResults compares the batch latencies of two benchmark results.
+func CompareResults(a, b BenchmarkResult) Comparison {
+	return CompareSamples(a.Name, b.Name, a.latencies, b.latencies)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4}
+	for _, test := range []struct {
+		p, want float64
+	}{
+		{0, 1},
+		{25, 1.75},
+		{50, 2.5},
+		{100, 4},
+	} {
+		if got := percentile(sorted, test.p); got != test.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", sorted, test.p, got, test.want)
+		}
+	}
+	if got := percentile([]float64{7}, 90); got != 7 {
+		t.Errorf("percentile of one sample = %v, want 7", got)
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of no samples = %v, want 0", got)
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	for _, test := range []struct {
+		a, b  []float64
+		wantU float64
+		wantP float64
+	}{
+		// Completely separated samples; p from the normal approximation with
+		// continuity correction, as scipy.stats.mannwhitneyu reports it.
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0, 0.012186},
+		{[]float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 25, 0.012186},
+		// Identical samples.
+		{[]float64{1, 2, 3, 4, 5}, []float64{1, 2, 3, 4, 5}, 12.5, 1},
+		// All ties: no variance to test.
+		{[]float64{2, 2, 2}, []float64{2, 2, 2}, 4.5, 1},
+	} {
+		u, p := mannWhitney(test.a, test.b)
+		if u != test.wantU || math.Abs(p-test.wantP) > 1e-5 {
+			t.Errorf("mannWhitney(%v, %v) = %v, %v, want %v, %v", test.a, test.b, u, p, test.wantU, test.wantP)
+		}
+	}
+}
+
+func TestCompareSamples(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{6, 7, 8, 9, 10}
+
+	c := CompareSamples("a", "b", a, b)
+	if !c.Significant || !strings.HasPrefix(c.Verdict, "significant") {
+		t.Errorf("separated samples: significant = %v, verdict %q", c.Significant, c.Verdict)
+	}
+	if c.MedianA != 3 || c.MedianB != 8 || c.Diff != 5 {
+		t.Errorf("separated samples: medians %v, %v, diff %v, want 3, 8, 5", c.MedianA, c.MedianB, c.Diff)
+	}
+	if c.DiffLow >= c.Diff || c.DiffHigh <= c.Diff || c.DiffLow <= 0 {
+		t.Errorf("separated samples: diff interval %v to %v, want around 5 and above 0", c.DiffLow, c.DiffHigh)
+	}
+
+	c = CompareSamples("a", "a", a, a)
+	if c.Significant || c.Diff != 0 || !strings.HasPrefix(c.Verdict, "no significant") {
+		t.Errorf("identical samples: significant = %v, diff %v, verdict %q", c.Significant, c.Diff, c.Verdict)
+	}
+
+	c = CompareSamples("a", "b", a[:4], b)
+	if c.Significant || c.P != 1 || c.SamplesA != 4 || !strings.HasPrefix(c.Verdict, "not enough samples") {
+		t.Errorf("too few samples: %+v", c)
+	}
+}
+
+func TestSubsample(t *testing.T) {
+	xs := []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if got := subsample(xs, 20); !reflect.DeepEqual(got, xs) {
+		t.Errorf("subsample(xs, 20) = %v, want all of xs", got)
+	}
+	if got, want := subsample(xs, 5), []float64{0, 2, 4, 6, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("subsample(xs, 5) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdFlushInterval is how often buffered metrics are sent, and
+// statsdPacketSize the most sent in one UDP packet, small enough not to be
+// fragmented.
+const (
+	statsdFlushInterval = time.Second
+	statsdPacketSize    = 1432
+)
+
+// statsd sends metrics to a StatsD server, for Graphite dashboards. Metrics are
+// buffered and sent over UDP, so a missing server loses them rather than
+// slowing the benchmark. A nil statsd sends nothing.
+type statsd struct {
+	conn   net.Conn
+	prefix string
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// newStatsd returns a statsd sending to addr, host:port, with each metric
+// name prefixed by prefix.
+func newStatsd(addr, prefix string) (*statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &statsd{conn: conn, prefix: prefix}, nil
+}
+
+// metricName makes s safe as one component of a Graphite metric name, in
+// which dots separate components, e.g. query set 1.1 becomes 1_1.
+func metricName(s string) string {
+	return strings.NewReplacer(".", "_", " ", "_", ":", "_", "|", "_", "/", "_").Replace(s)
+}
+
+// Timing records a duration, in milliseconds.
+func (sd *statsd) Timing(name string, d time.Duration) {
+	sd.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)+"|ms")
+}
+
+// Count adds n to a counter.
+func (sd *statsd) Count(name string, n int) {
+	sd.send(name, strconv.Itoa(n)+"|c")
+}
+
+// Gauge sets a gauge.
+func (sd *statsd) Gauge(name string, value float64) {
+	sd.send(name, strconv.FormatFloat(value, 'f', -1, 64)+"|g")
+}
+
+func (sd *statsd) send(name, value string) {
+	if sd == nil {
+		return
+	}
+	line := sd.prefix + name + ":" + value + "\n"
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if sd.buf.Len()+len(line) > statsdPacketSize {
+		sd.flush()
+	}
+	sd.buf.WriteString(line)
+}
+
+// flush sends the buffered metrics. sd.mu must be held.
+func (sd *statsd) flush() {
+	if sd.buf.Len() == 0 {
+		return
+	}
+	if _, err := sd.conn.Write(bytes.TrimSuffix(sd.buf.Bytes(), []byte("\n"))); err != nil {
+		logDebug(nil, "sending metrics to statsd: %v\n", err)
+	}
+	sd.buf.Reset()
+}
+
+// Flush sends the buffered metrics.
+func (sd *statsd) Flush() {
+	if sd == nil {
+		return
+	}
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.flush()
+}
+
+// StartFlusher sends buffered metrics every interval.
+func (sd *statsd) StartFlusher(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			sd.Flush()
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamFlushInterval bounds how long streamed lines wait in the response
+// buffer, so clients see progress without a flush per query.
+const streamFlushInterval = 100 * time.Millisecond
+
+// queryStream writes a run's per-query results, and each BenchmarkResult as
+// it completes, to an HTTP response as newline-delimited JSON, so long runs
+// show progress. Every line has a type: "query", "result", or "done" last.
+type queryStream struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	flusher   http.Flusher
+	lastFlush time.Time
+	err       error
+}
+
+// streamedQuery is a "query" line. Set is the index of the result the query
+// belongs to.
+type streamedQuery struct {
+	Type           string        `json:"type"`
+	Set            int           `json:"set"`
+	Name           string        `json:"name"`
+	Inputs         []interface{} `json:"inputs"`
+	Sum            interface{}   `json:"sum"`
+	LatencySeconds float64       `json:"latencyseconds"`
+	Batch          string        `json:"batch"`
+}
+
+func newQueryStream(w io.Writer) *queryStream {
+	qs := &queryStream{enc: json.NewEncoder(w)}
+	qs.flusher, _ = w.(http.Flusher)
+	return qs
+}
+
+// send writes a line, flushing if the last flush was long enough ago or
+// force is set. After a write fails, for instance because the client went
+// away, lines are dropped; the run itself carries on.
+func (qs *queryStream) send(v interface{}, force bool) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if qs.err != nil {
+		return
+	}
+	if qs.err = qs.enc.Encode(v); qs.err != nil {
+		logError(nil, "streaming results: %v\n", qs.err)
+		return
+	}
+	if qs.flusher != nil && (force || time.Since(qs.lastFlush) >= streamFlushInterval) {
+		qs.flusher.Flush()
+		qs.lastFlush = time.Now()
+	}
+}
+
+// Result streams a completed BenchmarkResult.
+func (qs *queryStream) Result(br BenchmarkResult) {
+	qs.send(struct {
+		Type   string          `json:"type"`
+		Result BenchmarkResult `json:"result"`
+	}{"result", br}, true)
+}
+
+// Done ends the stream with the run's response, e.g. its RepeatedResults.
+func (qs *queryStream) Done(runID string, response interface{}) {
+	qs.send(struct {
+		Type     string      `json:"type"`
+		RunID    string      `json:"runid"`
+		Response interface{} `json:"response"`
+	}{"done", runID, response}, true)
+}
+
+// Sink returns a ResultWriter streaming the queries of one result.
+func (qs *queryStream) Sink(set int, name string) ResultWriter {
+	return streamSetSink{qs, set, name}
+}
+
+type streamSetSink struct {
+	stream *queryStream
+	set    int
+	name   string
+}
+
+func (ss streamSetSink) Write(res QueryResult) error {
+	var sum interface{}
+	if len(res.outputs) > 0 {
+		sum = res.outputs[0]
+	}
+	ss.stream.send(streamedQuery{
+		Type:           "query",
+		Set:            ss.set,
+		Name:           ss.name,
+		Inputs:         res.inputs,
+		Sum:            sum,
+		LatencySeconds: res.latency.Seconds(),
+		Batch:          res.batch,
+	}, false)
+	return nil
+}
+
+func (ss streamSetSink) Close() error { return nil }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// suiteSlowest is the number of slowest queries a SuiteReport lists.
+const suiteSlowest = 10
+
+// SuiteReport is the combined report of running every query set in the
+// catalog, one after another, each with its configured concurrency and batch
+// size: the total time, each query set's result, and the slowest queries
+// across all of them. Query sets the cluster can't run are listed in Skipped
+// with the reason.
+type SuiteReport struct {
+	Started      time.Time         `json:"started"`
+	TotalSeconds float64           `json:"totalseconds"`
+	QuerySets    []BenchmarkResult `json:"querysets"`
+	Failed       []string          `json:"failed,omitempty"`
+	Skipped      map[string]string `json:"skipped,omitempty"`
+	Slowest      []SuiteQuery      `json:"slowest"`
+}
+
+// SuiteQuery is one of the slowest queries of a suite, with the query set it
+// belongs to. Its Seconds is the latency of the batch it was sent in.
+type SuiteQuery struct {
+	QuerySet string `json:"queryset"`
+	QueryRecord
+}
+
+// RunAll runs every query set in the catalog, recording them in run, and
+// returns the report.
+func (s *Server) RunAll(run *Run) SuiteReport {
+	report := SuiteReport{Started: time.Now().UTC(), QuerySets: []BenchmarkResult{}, Skipped: make(map[string]string), Slowest: []SuiteQuery{}}
+	start := time.Now()
+	for _, name := range queries.Names() {
+		qs := s.onIndex(run, getQuerySet(name))
+		if err := s.Supported(qs); err != nil {
+			report.Skipped[name] = err.Error()
+			continue
+		}
+		concurrency, batchSize := s.querySettings(name)
+		br := s.runRecorded(run, qs, concurrency, batchSize)
+		if br.Error != nil {
+			report.Failed = append(report.Failed, name)
+		}
+		report.QuerySets = append(report.QuerySets, br)
+	}
+	report.TotalSeconds = time.Since(start).Seconds()
+
+	for n, records := range s.runs.recordsBySet(run, len(report.QuerySets)) {
+		for _, rec := range records {
+			report.Slowest = append(report.Slowest, SuiteQuery{report.QuerySets[n].Name, rec})
+		}
+	}
+	sort.SliceStable(report.Slowest, func(i, j int) bool { return report.Slowest[i].Seconds > report.Slowest[j].Seconds })
+	if len(report.Slowest) > suiteSlowest {
+		report.Slowest = report.Slowest[:suiteSlowest]
+	}
+	return report
+}
+
+// suiteQueries returns the number of queries a suite run executes.
+func suiteQueries() int {
+	total := 0
+	for _, name := range queries.Names() {
+		total += getQuerySet(name).iterations
+	}
+	return total
+}
+
+// HandleSuite runs every query set and serves the SuiteReport. index selects
+// one of the indexes given with --indexes.
+func (s *Server) HandleSuite(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(w, r)
+	index, apiErr := s.indexParam(r)
+	if apiErr != nil {
+		s.reject(w, "suite", "all", requestID, apiErr)
+		return
+	}
+	run := s.runs.Start("suite", "all", requestID, s.concurrency, s.batchSize)
+	defer s.failOnPanic(run)
+	run.Index = index
+	logf(run, "handling %v\n", r.URL.Path)
+	w.Header().Set("X-Run-ID", run.ID)
+
+	results, report := s.execute(run)
+	s.finish(run, results)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logError(run, "writing suite report: %v\n", err)
+	}
+}
+
+// runSuiteCommand implements the suite command, which prints the SuiteReport
+// to stdout and fails if any query set did.
+func (s *Server) runSuiteCommand(args []string) error {
+	flags := pflag.NewFlagSet("suite", pflag.ContinueOnError)
+	index := flags.String("index", "", "one of the indexes given with --indexes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, ok := s.demoIndex(*index); !ok {
+		return fmt.Errorf("unknown index %q, want one of %v", *index, s.IndexNames())
+	}
+	run := s.runs.Start("suite", "all", "", s.concurrency, s.batchSize)
+	run.Index = *index
+	results, report := s.execute(run)
+	s.finish(run, results)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if failed := report.(SuiteReport).Failed; len(failed) > 0 {
+		return fmt.Errorf("%d query sets failed: %v", len(failed), failed)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SummaryPoint is a benchmark's duration at the time it ran.
+type SummaryPoint struct {
+	X     time.Time `json:"x"`
+	Y     float64   `json:"y"`
+	RunID string    `json:"runid"`
+}
+
+// SummarySeries is the durations of one query set at one concurrency and
+// batch size over time, shaped as a Chart.js dataset.
+type SummarySeries struct {
+	Label       string         `json:"label"`
+	Query       string         `json:"query"`
+	Concurrency int            `json:"concurrency"`
+	BatchSize   int            `json:"batchsize"`
+	Data        []SummaryPoint `json:"data"`
+}
+
+// SummaryValue is a benchmark's duration as a flat record, the shape of Vega
+// and Vega-Lite data values.
+type SummaryValue struct {
+	Time        time.Time `json:"time"`
+	Query       string    `json:"query"`
+	Concurrency int       `json:"concurrency"`
+	BatchSize   int       `json:"batchsize"`
+	Seconds     float64   `json:"seconds"`
+	RunID       string    `json:"runid"`
+}
+
+// summarize groups the durations of successful results by query set,
+// concurrency and batch size, each series in time order.
+func summarize(entries []HistoryEntry) []SummarySeries {
+	type key struct {
+		query                  string
+		concurrency, batchSize int
+	}
+	byKey := make(map[key]*SummarySeries)
+	series := []SummarySeries{}
+	for _, e := range entries {
+		br := e.Result
+		if br.Error != nil {
+			continue
+		}
+		k := key{br.Name, br.Concurrency, br.BatchSize}
+		ss, ok := byKey[k]
+		if !ok {
+			ss = &SummarySeries{
+				Label:       fmt.Sprintf("%v c=%d b=%d", br.Name, br.Concurrency, br.BatchSize),
+				Query:       br.Name,
+				Concurrency: br.Concurrency,
+				BatchSize:   br.BatchSize,
+			}
+			byKey[k] = ss
+		}
+		ss.Data = append(ss.Data, SummaryPoint{X: e.Time, Y: br.Seconds, RunID: e.RunID})
+	}
+	for _, ss := range byKey {
+		sort.SliceStable(ss.Data, func(i, j int) bool { return ss.Data[i].X.Before(ss.Data[j].X) })
+		series = append(series, *ss)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.Query != b.Query {
+			return a.Query < b.Query
+		}
+		if a.Concurrency != b.Concurrency {
+			return a.Concurrency < b.Concurrency
+		}
+		return a.BatchSize < b.BatchSize
+	})
+	return series
+}
+
+// HandleResultsSummary returns benchmark durations over time from the
+// history, for charts: one series per query set, concurrency and batch size,
+// so a grid sweep plots as one line per configuration. It takes the filters
+// of /results. The default, format=chartjs, returns {"datasets": [...]}, a
+// Chart.js time-scale line chart's data; format=vega returns flat records for
+// Vega or Vega-Lite.
+func (s *Server) HandleResultsSummary(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "chartjs" && format != "vega" {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "invalid format %q, want chartjs or vega", format))
+		return
+	}
+	f, apiErr := parseHistoryFilter(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	entries, err := s.history.Find(f)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, errInternal, "reading history: %v", err))
+		return
+	}
+	series := summarize(entries)
+
+	var response interface{} = struct {
+		Datasets []SummarySeries `json:"datasets"`
+	}{series}
+	if format == "vega" {
+		values := []SummaryValue{}
+		for _, ss := range series {
+			for _, p := range ss.Data {
+				values = append(values, SummaryValue{p.X, ss.Query, ss.Concurrency, ss.BatchSize, p.Y, p.RunID})
+			}
+		}
+		response = values
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logError(nil, "writing results summary: %v\n", err)
+	}
+}
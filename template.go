@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateCall matches a call of a template function in a format, e.g.
+// {{unionRows "lo_quantity_b" 1 24}}; templateArg matches each of its quoted
+// string or integer arguments. A call without arguments would be an argset
+// reference, so a call has at least one.
+var (
+	templateCall = regexp.MustCompile(`\{\{(\w+)((?:\s+(?:"[^"]*"|-?\d+))+)\s*\}\}`)
+	templateArg  = regexp.MustCompile(`"([^"]*)"|(-?\d+)`)
+)
+
+// templateFuncs are the functions a format may call. Each gets its arguments
+// with quotes removed.
+var templateFuncs = map[string]func(args []string) (string, error){
+	"unionRows": unionRows,
+}
+
+// unionRows returns the Union of rows first to last of a bucket frame, e.g.
+// the "b" variant of a Range on its field:
+// {{unionRows "lo_quantity_b" 1 24}} matches lo_quantity < 25. Only bucket
+// frames are allowed, and only their rows, which bounds the query's size.
+func unionRows(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", fmt.Errorf("unionRows needs a frame, first and last row")
+	}
+	frame := args[0]
+	first, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", fmt.Errorf("unionRows: invalid first row %q", args[1])
+	}
+	last, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("unionRows: invalid last row %q", args[2])
+	}
+	if first > last {
+		return "", fmt.Errorf("unionRows: first row %d is after last row %d", first, last)
+	}
+	var bucket *bucketFrame
+	var names []string
+	for n, bf := range bucketFrames {
+		if bf.Frame == frame {
+			bucket = &bucketFrames[n]
+		}
+		names = append(names, bf.Frame)
+	}
+	if bucket == nil {
+		return "", fmt.Errorf("unionRows: %v is not a bucket frame, want one of %v", frame, names)
+	}
+	if first < bucket.Min || last > bucket.Max {
+		return "", fmt.Errorf("unionRows: %v has rows %d to %d, not %d to %d", frame, bucket.Min, bucket.Max, first, last)
+	}
+	rows := make([]string, 0, last-first+1)
+	for row := first; row <= last; row++ {
+		rows = append(rows, fmt.Sprintf(`Bitmap(frame="%s", rowID=%d)`, frame, row))
+	}
+	return "Union(" + strings.Join(rows, ", ") + ")", nil
+}
+
+// expandTemplates replaces each template function call in format with its
+// result.
+func expandTemplates(format string) (string, error) {
+	var err error
+	expanded := templateCall.ReplaceAllStringFunc(format, func(call string) string {
+		m := templateCall.FindStringSubmatch(call)
+		f, ok := templateFuncs[m[1]]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unknown template function %v", m[1])
+			}
+			return call
+		}
+		var args []string
+		for _, a := range templateArg.FindAllStringSubmatch(m[2], -1) {
+			args = append(args, a[1]+a[2])
+		}
+		result, callErr := f(args)
+		if callErr != nil && err == nil {
+			err = callErr
+		}
+		return result
+	})
+	return expanded, err
+}
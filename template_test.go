@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	for _, test := range []struct {
+		format  string
+		want    string
+		wantErr string
+	}{
+		{
+			format: `Count(Bitmap(frame="lo_year", rowID=%d))`,
+			want:   `Count(Bitmap(frame="lo_year", rowID=%d))`,
+		},
+		{
+			format: `Count(Bitmap(frame="p_brand1", rowID={{brand}}))`,
+			want:   `Count(Bitmap(frame="p_brand1", rowID={{brand}}))`,
+		},
+		{
+			format: `Count({{unionRows "lo_quantity_b" 1 3}})`,
+			want:   `Count(Union(Bitmap(frame="lo_quantity_b", rowID=1), Bitmap(frame="lo_quantity_b", rowID=2), Bitmap(frame="lo_quantity_b", rowID=3)))`,
+		},
+		{
+			format: `Count(Intersect({{unionRows "lo_discount_b" 4 4}}, {{unionRows "lo_quantity_b" 50 50 }}))`,
+			want:   `Count(Intersect(Union(Bitmap(frame="lo_discount_b", rowID=4)), Union(Bitmap(frame="lo_quantity_b", rowID=50))))`,
+		},
+		{
+			format:  `Count({{rangeRows "lo_quantity_b" 1 3}})`,
+			wantErr: "unknown template function rangeRows",
+		},
+		{
+			format:  `Count({{unionRows "lo_quantity" 1 3}})`,
+			wantErr: "lo_quantity is not a bucket frame",
+		},
+		{
+			format:  `Count({{unionRows "lo_quantity_b" 0 24}})`,
+			wantErr: "lo_quantity_b has rows 1 to 50, not 0 to 24",
+		},
+		{
+			format:  `Count({{unionRows "lo_quantity_b" 24 1}})`,
+			wantErr: "first row 24 is after last row 1",
+		},
+		{
+			format:  `Count({{unionRows "lo_quantity_b" 24}})`,
+			wantErr: "unionRows needs a frame, first and last row",
+		},
+	} {
+		got, err := expandTemplates(test.format)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expandTemplates(%q) error = %v, want %q", test.format, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandTemplates(%q): %v", test.format, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("expandTemplates(%q) = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
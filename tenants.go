@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// TenantResult holds the results of a query set run across several small
+// indexes at once, one BenchmarkResult per index, plus aggregate throughput.
+type TenantResult struct {
+	Indexes []string `json:"indexes"`
+	MultiResult
+}
+
+// tenantIndexes expands an index name template containing {n} for each of k
+// tenants, numbered from 0.
+func tenantIndexes(template string, k int) []string {
+	names := make([]string, k)
+	for n := range names {
+		names[n] = strings.Replace(template, "{n}", strconv.Itoa(n), -1)
+	}
+	return names
+}
+
+// RunTenants runs the query set on every index simultaneously, splitting
+// concurrency between them, modeling a multi-tenant deployment of many small
+// indexes rather than one large one. Each result's ColumnCount is that of its
+// own index.
+func (s *Server) RunTenants(qname string, indexes []string, concurrency, batchSize int) (TenantResult, error) {
+	res := TenantResult{Indexes: indexes, MultiResult: MultiResult{Sets: make([]BenchmarkResult, len(indexes))}}
+	perIndex := concurrency / len(indexes)
+	if perIndex < 1 {
+		perIndex = 1
+	}
+
+	sets := make([]QuerySet, len(indexes))
+	for n, name := range indexes {
+		live, err := getPilosaSchema(s.pilosaAddr(), name)
+		if err != nil {
+			return res, fmt.Errorf("fetching pilosa schema: %v", err)
+		}
+		if len(live) == 0 {
+			return res, fmt.Errorf("index %v doesn't exist or has no frames", name)
+		}
+		index, err := pilosa.NewIndex(name, nil)
+		if err != nil {
+			return res, fmt.Errorf("pilosa.NewIndex: %v", err)
+		}
+		sets[n] = getQuerySet(qname)
+		sets[n].index = index
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for n := range sets {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			res.Sets[n] = s.RunSumMultiBatch(sets[n], perIndex, batchSize)
+		}(n)
+	}
+	wg.Wait()
+	res.Seconds = time.Since(start).Seconds()
+
+	for n, qs := range sets {
+		q := fmt.Sprintf(`Count(Bitmap(frame="%s", rowID=0))`, existsFrame)
+		if response, err := s.query(qs.index.RawQuery(s.pql(q))); err == nil {
+			res.Sets[n].ColumnCount = response.Result().Count
+			res.Sets[n].ColumnAge = 0
+		} else {
+			logWarn(nil, "counting lineorders in %v: %v\n", qs.index.Name(), err)
+		}
+	}
+	for _, br := range res.Sets {
+		res.Iterations += br.Iterations
+		res.Concurrency += br.Concurrency
+	}
+	if res.Seconds > 0 {
+		res.QPS = float64(res.Iterations) / res.Seconds
+	}
+	return res, nil
+}
+
+// HandleTenants runs a query set across K existing indexes named by a
+// template, e.g. /tenants?query=1.1&k=8&template=ssb_{n}
+// The template defaults to the server's index followed by _{n}.
+func (s *Server) HandleTenants(w http.ResponseWriter, r *http.Request) {
+	logf(nil, "handling %v\n", r.URL.String())
+	q := r.URL.Query()
+	qname := q.Get("query")
+	if getQuerySet(qname).Name == "" {
+		writeError(w, newAPIError(http.StatusBadRequest, errUnknownQuery, "unknown query set %q; see /queries", qname))
+		return
+	}
+	k, err := strconv.Atoi(q.Get("k"))
+	if err != nil || k < 1 {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "invalid k: %q", q.Get("k")))
+		return
+	}
+	template := q.Get("template")
+	if template == "" {
+		template = s.Index.Name() + "_{n}"
+	}
+	if !strings.Contains(template, "{n}") {
+		writeError(w, newAPIError(http.StatusBadRequest, errBadRequest, "template must contain {n}"))
+		return
+	}
+
+	res, err := s.RunTenants(qname, tenantIndexes(template, k), s.concurrency, s.batchSize)
+	if err != nil {
+		writeError(w, pilosaError(err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logError(nil, "writing tenant results: %v\n", err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceExportInterval is how often spans are sent to the OTLP collector, and
+// maxQueuedSpans how many may wait between exports before new ones are
+// dropped.
+const (
+	traceExportInterval = 5 * time.Second
+	maxQueuedSpans      = 20000
+)
+
+// OTLP span kinds and status codes.
+const (
+	spanInternal = 1
+	spanClient   = 3
+
+	statusError = 2
+)
+
+// tracer records spans and exports them to an OpenTelemetry collector over
+// OTLP/HTTP, as JSON, without the OpenTelemetry SDK. A nil tracer records
+// nothing, so tracing costs nothing unless --otlp-endpoint is set.
+type tracer struct {
+	url     string
+	service string
+	client  *http.Client
+
+	mu      sync.Mutex
+	spans   []otlpSpan
+	dropped int
+}
+
+// newTracer returns a tracer exporting to the collector at endpoint, e.g.
+// http://localhost:4318. The service name is taken from OTEL_SERVICE_NAME if
+// set.
+func newTracer(endpoint string) *tracer {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "demo-ssb"
+	}
+	return &tracer{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// span is an operation being timed. Its methods do nothing on a nil span.
+type span struct {
+	t       *tracer
+	traceID string
+	id      string
+	parent  string
+	name    string
+	kind    int
+	start   time.Time
+	attrs   []otlpAttribute
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Start begins a span, a child of parent, or the root of a new trace if parent
+// is nil.
+func (t *tracer) Start(parent *span, name string, kind int) *span {
+	if t == nil {
+		return nil
+	}
+	sp := &span{t: t, id: randomHex(8), name: name, kind: kind, start: time.Now()}
+	if parent != nil {
+		sp.traceID, sp.parent = parent.traceID, parent.id
+	} else {
+		sp.traceID = randomHex(16)
+	}
+	return sp
+}
+
+// Set records an attribute of the span: a string, bool, int, int64 or
+// float64.
+func (sp *span) Set(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.String = &value
+	case bool:
+		v.Bool = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.Int = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.Int = &s
+	case float64:
+		v.Double = &value
+	default:
+		s := fmt.Sprint(value)
+		v.String = &s
+	}
+	sp.attrs = append(sp.attrs, otlpAttribute{key, v})
+}
+
+// End finishes the span, marking it failed if err is not nil, and queues it
+// for export.
+func (sp *span) End(err error) {
+	if sp == nil {
+		return
+	}
+	s := otlpSpan{
+		TraceID:      sp.traceID,
+		SpanID:       sp.id,
+		ParentSpanID: sp.parent,
+		Name:         sp.name,
+		Kind:         sp.kind,
+		Start:        strconv.FormatInt(sp.start.UnixNano(), 10),
+		End:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:   sp.attrs,
+	}
+	if err != nil {
+		s.Status = &otlpStatus{Code: statusError, Message: err.Error()}
+	}
+	t := sp.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+}
+
+// StartExporter exports queued spans every interval.
+func (t *tracer) StartExporter(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			t.Flush()
+		}
+	}()
+}
+
+// Flush exports the queued spans, logging a failure rather than retrying.
+func (t *tracer) Flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		logWarn(nil, "dropped %d spans: more than %d queued between exports\n", dropped, maxQueuedSpans)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(spans); err != nil {
+		logWarn(nil, "exporting %d spans to %v: %v\n", len(spans), t.url, err)
+	}
+}
+
+func (t *tracer) export(spans []otlpSpan) error {
+	service := t.service
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{"service.name", otlpValue{String: &service}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "demo-ssb", Version: Version},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %v", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of an ExportTraceServiceRequest, as much of it
+// as the demo uses.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue; one field is set. Integers are strings, as OTLP's
+// JSON encoding has them.
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"`
+	Double *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
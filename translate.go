@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// translations maps a language tag, e.g. "fr", to translations of labels,
+// keyed by the canonical (English) label. Only labels change; IDs in responses
+// stay canonical.
+type translations map[string]map[string]string
+
+// loadTranslations reads one JSON object of label translations per language
+// from dir, named after the language, e.g. fr.json.
+func loadTranslations(dir string) (translations, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	t := make(translations)
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var labels map[string]string
+		if err := json.Unmarshal(body, &labels); err != nil {
+			return nil, fmt.Errorf("parsing %v: %v", file, err)
+		}
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		t[lang] = labels
+	}
+	return t, nil
+}
+
+// Translate returns the label in lang, or the canonical label if there is no
+// translation.
+func (t translations) Translate(lang, label string) string {
+	if tr, ok := t[lang][label]; ok {
+		return tr
+	}
+	return label
+}
+
+// match returns the best available language for a tag such as "fr-CA",
+// falling back from region to base language, or "" if none is available.
+func (t translations) match(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := t[tag]; ok {
+		return tag
+	}
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		if _, ok := t[tag[:i]]; ok {
+			return tag[:i]
+		}
+	}
+	return ""
+}
+
+// Lang returns the language to label a response in: the lang query parameter
+// if given, else the most preferred available language in Accept-Language,
+// else "" for canonical labels.
+func (t translations) Lang(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return t.match(lang)
+	}
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		p := pref{tag: strings.TrimSpace(fields[0]), q: 1}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if q, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					p.q = q
+				}
+			}
+		}
+		if p.tag != "" && p.q > 0 {
+			prefs = append(prefs, p)
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if lang := t.match(p.tag); lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+// translateNode translates the labels of a hierarchy node, its parent and
+// children.
+func (t translations) translateNode(lang string, n *Node) {
+	if lang == "" {
+		return
+	}
+	n.Label = t.Translate(lang, n.Label)
+	if n.Parent != nil {
+		p := *n.Parent
+		t.translateNode(lang, &p)
+		n.Parent = &p
+	}
+	for k := range n.Children {
+		t.translateNode(lang, &n.Children[k])
+	}
+}
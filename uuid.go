@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// validRequestID matches the request IDs accepted from clients. They tag
+// results files, so they mustn't be able to name a path.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// requestIDFor returns the request's X-Request-ID, or a fresh ID if it has none
+// or an invalid one, and sets it on the response.
+func requestIDFor(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if !validRequestID.MatchString(id) {
+		id = newUUID()
+	}
+	w.Header().Set("X-Request-ID", id)
+	return id
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// GoldenAnswers are the expected sums of a query set's queries at one
+// lineorder count, i.e. one SSB scale factor.
+type GoldenAnswers struct {
+	Query       string         `json:"query"`
+	ColumnCount uint64         `json:"columncount"`
+	Answers     []GoldenAnswer `json:"answers"`
+}
+
+// GoldenAnswer is the expected sum of the query with the given inputs.
+type GoldenAnswer struct {
+	Inputs []int64 `json:"inputs"`
+	Sum    int64   `json:"sum"`
+}
+
+// Verification reports how a result's sums compare with the golden answers.
+// In Sums, sum1 is the expected sum and sum2 the one Pilosa returned.
+// Recorded is set instead if the run's sums were stored as the golden answers.
+type Verification struct {
+	Golden     string        `json:"golden"`
+	Recorded   bool          `json:"recorded,omitempty"`
+	Checked    int           `json:"checked"`
+	Missing    int           `json:"missing"`
+	Mismatches int           `json:"mismatches"`
+	Sums       []SumMismatch `json:"sums,omitempty"`
+}
+
+// goldenPath returns the golden answers file for a query set at a lineorder
+// count. Each count has its own directory, so answers for several scale
+// factors can be kept side by side.
+func goldenPath(dir, qname string, columnCount uint64) string {
+	return filepath.Join(dir, strconv.FormatUint(columnCount, 10), qname+".json")
+}
+
+// goldenKey identifies a query by its inputs.
+func goldenKey(inputs []int64) string {
+	return fmt.Sprint(inputs)
+}
+
+// recordInputs converts a record's inputs for goldenKey.
+func recordInputs(rec QueryRecord) []int64 {
+	inputs := make([]int64, len(rec.Inputs))
+	for k, v := range rec.Inputs {
+		inputs[k] = int64(sortValue(v))
+	}
+	return inputs
+}
+
+// loadGolden reads golden answers from path.
+func loadGolden(path string) (GoldenAnswers, error) {
+	var g GoldenAnswers
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return g, err
+	}
+	if err := json.Unmarshal(body, &g); err != nil {
+		return g, fmt.Errorf("parsing %v: %v", path, err)
+	}
+	return g, nil
+}
+
+// saveGolden writes golden answers to path, creating its directory.
+func saveGolden(path string, g GoldenAnswers) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(body, '\n'), 0644)
+}
+
+// verifyRun checks the sums of every result in a run against the golden
+// answers for its query set and lineorder count, attaching a Verification to
+// each result. With verify=record, the sums of the run's first result are
+// stored as the golden answers instead. Failed results are left alone, and
+// errors are logged, since HandleQuery checks for the answers beforehand.
+func (s *Server) verifyRun(run *Run, results []BenchmarkResult) {
+	if run.Verify == "" || len(results) == 0 {
+		return
+	}
+	bySet := s.runs.recordsBySet(run, len(results))
+	path := goldenPath(s.goldenDir, run.Query, results[0].ColumnCount)
+
+	if run.Verify == "record" {
+		if results[0].Error != nil {
+			return
+		}
+		g := GoldenAnswers{Query: run.Query, ColumnCount: results[0].ColumnCount, Answers: []GoldenAnswer{}}
+		for _, rec := range bySet[0] {
+			g.Answers = append(g.Answers, GoldenAnswer{Inputs: recordInputs(rec), Sum: int64(sortValue(rec.Output))})
+		}
+		if err := saveGolden(path, g); err != nil {
+			logf(run, "recording golden answers: %v\n", err)
+			return
+		}
+		logf(run, "recorded %d golden answers in %v\n", len(g.Answers), path)
+		results[0].Verification = &Verification{Golden: path, Recorded: true, Checked: len(g.Answers)}
+		return
+	}
+
+	g, err := loadGolden(path)
+	if err != nil {
+		logf(run, "loading golden answers: %v\n", err)
+		return
+	}
+	for n := range results {
+		if results[n].Error != nil {
+			continue
+		}
+		results[n].Verification = verifySums(g, path, bySet[n])
+		if m := results[n].Verification.Mismatches; m > 0 {
+			logf(run, "%v: %d sums differ from the golden answers\n", results[n].Name, m)
+		}
+	}
+}
+
+// checkVerify validates a verify parameter for a query set run on an index:
+// true checks sums against golden answers, which must exist for the index's
+// current lineorder count,
+// and record stores them. Sums can't be verified with noise enabled, nor TopN
+// results at all.
+func (s *Server) checkVerify(verify, qname, index string) *APIError {
+	switch verify {
+	case "", "false":
+		return nil
+	case "true", "record":
+	default:
+		return newAPIError(http.StatusBadRequest, errBadRequest, "invalid verify %q, want true or record", verify)
+	}
+	if s.noise != nil {
+		return newAPIError(http.StatusBadRequest, errBadRequest, "can't verify sums with noise enabled")
+	}
+	if getQuerySet(qname).Kind == ResultTopN {
+		return newAPIError(http.StatusBadRequest, errBadRequest, "can't verify TopN results of %v, only sums and counts", qname)
+	}
+	if verify == "true" {
+		di, _ := s.demoIndex(index)
+		count, _ := di.lineOrders.Get()
+		path := goldenPath(s.goldenDir, qname, count)
+		if _, err := os.Stat(path); err != nil {
+			return newAPIError(http.StatusNotFound, errNotFound, "no golden answers for %v at %d lineorders; record them with verify=record", qname, count)
+		}
+	}
+	return nil
+}
+
+// verifySums compares records with golden answers. Answers without a
+// matching record, for instance because a batch failed, are missing.
+func verifySums(g GoldenAnswers, path string, records []QueryRecord) *Verification {
+	v := &Verification{Golden: path, Sums: []SumMismatch{}}
+	want := make(map[string]int64, len(g.Answers))
+	for _, a := range g.Answers {
+		want[goldenKey(a.Inputs)] = a.Sum
+	}
+	for _, rec := range records {
+		key := goldenKey(recordInputs(rec))
+		expected, ok := want[key]
+		if !ok {
+			continue
+		}
+		delete(want, key)
+		v.Checked++
+		got := int64(sortValue(rec.Output))
+		if got == expected {
+			continue
+		}
+		v.Mismatches++
+		if len(v.Sums) < maxSumMismatches {
+			v.Sums = append(v.Sums, SumMismatch{
+				Set:    rec.Set,
+				Inputs: rec.Inputs,
+				Sum1:   expected,
+				Sum2:   got,
+				Change: percentChange(float64(expected), float64(got)),
+			})
+		}
+	}
+	v.Missing = len(want)
+	return v
+}
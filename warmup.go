@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	pilosa "github.com/pilosa/go-pilosa"
+)
+
+// Warmup configures queries executed untimed before a benchmark's stopwatch
+// starts, so cold caches don't skew the first of several configurations
+// compared: either the first Queries queries of the set, or as many as run in
+// Duration, cycling through the set. The zero value disables warmup.
+type Warmup struct {
+	Queries  int
+	Duration time.Duration
+}
+
+// parseWarmup parses a query count, e.g. "500", or a duration, e.g. "10s".
+// The empty string disables warmup.
+func parseWarmup(s string) (Warmup, error) {
+	if s == "" {
+		return Warmup{}, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return Warmup{}, fmt.Errorf("negative warmup %q", s)
+		}
+		return Warmup{Queries: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return Warmup{}, fmt.Errorf("invalid warmup %q, want a query count or a duration", s)
+	}
+	if d < 0 {
+		return Warmup{}, fmt.Errorf("negative warmup %q", s)
+	}
+	return Warmup{Duration: d}, nil
+}
+
+func (w Warmup) String() string {
+	if w.Duration > 0 {
+		return w.Duration.String()
+	}
+	if w.Queries > 0 {
+		return strconv.Itoa(w.Queries)
+	}
+	return ""
+}
+
+// warmupFor returns the warmup for run, which overrides the server's.
+func (s *Server) warmupFor(run *Run) Warmup {
+	if run != nil && run.Warmup != "" {
+		if w, err := parseWarmup(run.Warmup); err == nil {
+			return w
+		}
+	}
+	return s.warmup
+}
+
+// warm executes a warmup of qs on index in batches of batchSize, wrapped in
+// the query set's setup and teardown, and returns the number of queries run.
+// Results are discarded. Teardown runs even if the warmup fails.
+func (s *Server) warm(run *Run, index *pilosa.Index, qs QuerySet, w Warmup, batchSize int) (n int, err error) {
+	if w.Queries == 0 && w.Duration == 0 || qs.iterations == 0 {
+		return 0, nil
+	}
+	if setup := qs.SetupQuery(); setup != "" {
+		if _, err := s.query(index.RawQuery(s.pql(setup))); err != nil {
+			return 0, fmt.Errorf("warmup setup: %v", err)
+		}
+	}
+	if teardown := qs.TeardownQuery(); teardown != "" {
+		defer func() {
+			if _, terr := s.query(index.RawQuery(s.pql(teardown))); terr != nil && err == nil {
+				err = fmt.Errorf("warmup teardown: %v", terr)
+			}
+		}()
+	}
+
+	start := time.Now()
+	for w.Duration > 0 && time.Since(start) < w.Duration || w.Duration == 0 && n < w.Queries {
+		raw := ""
+		k := 0
+		for ; k < batchSize && (w.Duration > 0 || n+k < w.Queries); k++ {
+			raw += qs.QueryResultN((n + k) % qs.iterations).raw
+		}
+		if _, err := s.query(index.RawQuery(s.pql(raw))); err != nil {
+			return n, fmt.Errorf("warmup: %v", err)
+		}
+		n += k
+		s.runs.Beat(run)
+	}
+	logf(run, "warmed up %v with %d queries in %v\n", qs.Name, n, time.Since(start))
+	return n, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InFlightBatch is a batch query that has been sent to Pilosa and has not yet
+// returned.
+type InFlightBatch struct {
+	ID       int       `json:"id"`
+	Batch    string    `json:"batch"`
+	QuerySet string    `json:"queryset"`
+	Queries  int       `json:"queries"`
+	Started  time.Time `json:"started"`
+	Raw      string    `json:"raw"`
+}
+
+// inflightTracker keeps track of batches currently being executed.
+type inflightTracker struct {
+	mu      sync.Mutex
+	seq     int
+	batches map[int]InFlightBatch
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{batches: make(map[int]InFlightBatch)}
+}
+
+// Add registers a batch as in flight and returns its ID.
+func (t *inflightTracker) Add(name, batch string, queries int, raw string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	t.batches[t.seq] = InFlightBatch{t.seq, batch, name, queries, time.Now(), raw}
+	return t.seq
+}
+
+// Remove unregisters a batch once it returns.
+func (t *inflightTracker) Remove(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.batches, id)
+}
+
+// List returns the in-flight batches, oldest first.
+func (t *inflightTracker) List() []InFlightBatch {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]InFlightBatch, 0, len(t.batches))
+	for _, b := range t.batches {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Diagnostic is a snapshot of the process taken when a run stalls.
+type Diagnostic struct {
+	Time       time.Time       `json:"time"`
+	InFlight   []InFlightBatch `json:"inflight"`
+	Goroutines string          `json:"goroutines"`
+}
+
+// snapshot captures the in-flight batches and a dump of all goroutines.
+func (s *Server) snapshot() *Diagnostic {
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	return &Diagnostic{
+		Time:       time.Now(),
+		InFlight:   s.inflight.List(),
+		Goroutines: string(buf[:n]),
+	}
+}
+
+// StartWatchdog periodically checks running runs, marking a run stalled if
+// no result has arrived within timeout, and recording a diagnostic snapshot.
+// If cancel is set, stalled runs are also cancelled, which returns control to
+// the waiting HTTP request.
+func (s *Server) StartWatchdog(timeout time.Duration, cancel bool) {
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		for range time.Tick(interval) {
+			s.checkStalled(timeout, cancel)
+		}
+	}()
+}
+
+func (s *Server) checkStalled(timeout time.Duration, cancel bool) {
+	rs := s.runs
+	rs.mu.Lock()
+	var stalled []*Run
+	for _, run := range rs.runs {
+		if run.Status != "running" {
+			continue
+		}
+		last := run.Started
+		if run.Heartbeat != nil {
+			last = *run.Heartbeat
+		}
+		if time.Since(last) > timeout {
+			stalled = append(stalled, run)
+		}
+	}
+	rs.mu.Unlock()
+
+	for _, run := range stalled {
+		diag := s.snapshot()
+		rs.mu.Lock()
+		if run.Status == "running" {
+			run.Status = "stalled"
+			run.Diagnostic = diag
+			logWarn(run, "run %v stalled: no results for %v, %d batches in flight\n", run.ID, timeout, len(diag.InFlight))
+			if cancel {
+				run.Status = "cancelled"
+				close(run.cancel)
+			}
+		}
+		rs.mu.Unlock()
+	}
+}
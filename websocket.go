@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout bounds each write to a WebSocket client, so one that stops
+// reading can't hold a handler forever.
+const wsWriteTimeout = 10 * time.Second
+
+// webSocket is a server-side WebSocket connection that only pushes messages.
+// closed is closed once the client closes the connection or it fails.
+type webSocket struct {
+	conn   *websocket.Conn
+	closed chan struct{}
+}
+
+// checkWebSocketOrigin allows WebSocket connections from pages on the demo's
+// own host or one of --cors-origins, and from clients that aren't browsers,
+// which send no Origin.
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || indexOf(s.corsOrigins, origin) >= 0 || indexOf(s.corsOrigins, "*") >= 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket completes the WebSocket handshake for r. On error it has
+// already responded.
+func (s *Server) upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	ws := &webSocket{conn: conn, closed: make(chan struct{})}
+	go ws.readLoop()
+	return ws, nil
+}
+
+// WriteJSON sends v as a text message.
+func (ws *webSocket) WriteJSON(v interface{}) error {
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return ws.conn.WriteJSON(v)
+}
+
+// CloseWith sends a close message with a status code and reason.
+func (ws *webSocket) CloseWith(code int, reason string) error {
+	return ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+}
+
+// Close closes the connection.
+func (ws *webSocket) Close() error {
+	return ws.conn.Close()
+}
+
+// readLoop reads from the client, which lets the connection answer pings and
+// notice close, until the connection ends. Messages from the client are
+// discarded.
+func (ws *webSocket) readLoop() {
+	defer close(ws.closed)
+	for {
+		if _, _, err := ws.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}